@@ -3,11 +3,18 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
+	// AppEnv is "development" or "production". Development allows running
+	// without the files a deployment must provide, such as the JWT key.
+	AppEnv string
+
 	DBHost     string
 	DBPort     string
 	DBUser     string
@@ -15,7 +22,107 @@ type Config struct {
 	DBName     string
 	DBSSLMode  string
 	ServerPort string
-	JWTSecret  string
+
+	// CIDR ranges of the reverse proxies in front of the server. The client IP
+	// is taken from X-Forwarded-For only for requests coming from them; with
+	// none configured it is the address of the connection.
+	TrustedProxies []string
+
+	// JWT signing. The signing key is a PEM encoded RSA or Ed25519 private key;
+	// verification keys are previous keys still accepted during rotation. The
+	// signing key is required outside development.
+	JWTSigningKeyFile       string
+	JWTVerificationKeyFiles []string
+	// iss of every token, and aud of full access tokens. Tokens limited to a
+	// scope carry "<audience>:<scope>" so other services reject them.
+	JWTIssuer   string
+	JWTAudience string
+
+	// Token lifetimes
+	AccessTokenTTL            time.Duration
+	RefreshTokenTTL           time.Duration
+	RememberMeRefreshTokenTTL time.Duration
+
+	// Public URL of the SPA, used to build links in emails
+	AppBaseURL string
+
+	// Outgoing mail. When SMTPHost is empty emails are written to the log.
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	PasswordResetTokenTTL time.Duration
+	// Lifetime of the set-password links mailed to imported users
+	UserInvitationTTL time.Duration
+
+	// Password policy
+	BannedPasswordsFile string
+
+	// OpenID Connect identity providers, keyed by the name used in the URL
+	OIDCProviders         []OIDCProviderConfig
+	OIDCPostLoginRedirect string
+
+	// LDAP / Active Directory authenticators
+	LDAPProviders []LDAPProviderConfig
+
+	// Where login rate limit counters live: "memory" for a single instance,
+	// "postgres" to share them between instances
+	RateLimitStore string
+
+	// In-process session validation cache. A TTL of 0 disables it.
+	SessionCacheTTL        time.Duration
+	SessionCacheMaxEntries int
+
+	// How often users are checked for passwords about to expire. 0 disables
+	// the warnings.
+	PasswordExpiryCheckInterval time.Duration
+
+	// Uploaded files: "local" keeps them in StorageLocalDir, "s3" in an
+	// S3-compatible bucket. Either way they are served at /uploads and
+	// StoragePublicURL is the absolute URL of that path.
+	StorageBackend   string
+	StorageLocalDir  string
+	StoragePublicURL string
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3AccessKey      string
+	S3SecretKey      string
+	S3UseSSL         bool
+}
+
+// LDAPProviderConfig is read from LDAP_<NAME>_* variables for every name
+// listed in LDAP_PROVIDERS. GroupRoles comes from LDAP_<NAME>_GROUP_ROLES in
+// the form "group:ROLE_CODE;group:ROLE_CODE" where group is a DN or CN.
+type LDAPProviderConfig struct {
+	Name               string
+	URL                string
+	StartTLS           bool
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	GroupAttribute     string
+	GroupRoles         map[string]string
+	Domains            []string
+	JITProvisioning    bool
+	DefaultStatusID    int
+}
+
+// OIDCProviderConfig is read from OIDC_<NAME>_* variables for every name
+// listed in OIDC_PROVIDERS.
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 var AppConfig *Config
@@ -28,6 +135,8 @@ func InitConfig() {
 	}
 
 	AppConfig = &Config{
+		AppEnv: getEnv("APP_ENV", "production"),
+
 		DBHost:     getEnv("DB_HOST", "localhost"),
 		DBPort:     getEnv("DB_PORT", "5432"),
 		DBUser:     getEnv("DB_USER", "postgres"),
@@ -35,8 +144,78 @@ func InitConfig() {
 		DBName:     getEnv("DB_NAME", "spa_ardnoan"),
 		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
 		ServerPort: getEnv("SERVER_PORT", "8080"),
-		JWTSecret:  getEnv("JWT_SECRET", "default_secret"),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		JWTSigningKeyFile:       getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTVerificationKeyFiles: getEnvList("JWT_VERIFICATION_KEY_FILES"),
+		JWTIssuer:               getEnv("JWT_ISSUER", "spa-system"),
+		JWTAudience:             getEnv("JWT_AUDIENCE", "spa-system-api"),
+
+		AccessTokenTTL:            getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:           getEnvDuration("REFRESH_TOKEN_TTL", 24*time.Hour),
+		RememberMeRefreshTokenTTL: getEnvDuration("REMEMBER_ME_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		AppBaseURL: getEnv("APP_BASE_URL", "http://localhost:3000"),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "no-reply@localhost"),
+
+		PasswordResetTokenTTL: getEnvDuration("PASSWORD_RESET_TOKEN_TTL", time.Hour),
+		UserInvitationTTL:     getEnvDuration("USER_INVITATION_TTL", 72*time.Hour),
+
+		BannedPasswordsFile: getEnv("BANNED_PASSWORDS_FILE", "config/banned_passwords.txt"),
+
+		OIDCProviders: loadOIDCProviders(),
+		LDAPProviders: loadLDAPProviders(),
+
+		RateLimitStore: getEnv("RATE_LIMIT_STORE", "memory"),
+
+		SessionCacheTTL:        getEnvDuration("SESSION_CACHE_TTL", 30*time.Second),
+		SessionCacheMaxEntries: getEnvInt("SESSION_CACHE_MAX_ENTRIES", 10000),
+
+		PasswordExpiryCheckInterval: getEnvDuration("PASSWORD_EXPIRY_CHECK_INTERVAL", time.Hour),
+
+		StorageBackend:  getEnv("STORAGE_BACKEND", "local"),
+		StorageLocalDir: getEnv("STORAGE_LOCAL_DIR", "uploads"),
+		S3Endpoint:      getEnv("S3_ENDPOINT", "localhost:9000"),
+		S3Region:        getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:        getEnv("S3_BUCKET", "spa-uploads"),
+		S3AccessKey:     getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:     getEnv("S3_SECRET_KEY", ""),
+		S3UseSSL:        getEnvBool("S3_USE_SSL", false),
+	}
+	AppConfig.OIDCPostLoginRedirect = getEnv("OIDC_POST_LOGIN_REDIRECT", AppConfig.AppBaseURL+"/auth/callback")
+	AppConfig.StoragePublicURL = getEnv("STORAGE_PUBLIC_URL", "http://localhost:"+AppConfig.ServerPort+"/uploads")
+}
+
+// IsDevelopment reports whether APP_ENV is development.
+func (c *Config) IsDevelopment() bool {
+	return c.AppEnv == "development"
+}
+
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS") {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:       getEnvList(prefix + "SCOPES"),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			log.Printf("OIDC provider %s skipped: %sISSUER, %sCLIENT_ID and %sREDIRECT_URL are required", name, prefix, prefix, prefix)
+			continue
+		}
+		providers = append(providers, provider)
 	}
+	return providers
 }
 
 func getEnv(key, defaultValue string) string {
@@ -45,3 +224,94 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s (%q), using default %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+func loadLDAPProviders() []LDAPProviderConfig {
+	var providers []LDAPProviderConfig
+	for _, name := range getEnvList("LDAP_PROVIDERS") {
+		prefix := "LDAP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := LDAPProviderConfig{
+			Name:               name,
+			URL:                getEnv(prefix+"URL", ""),
+			StartTLS:           getEnvBool(prefix+"START_TLS", false),
+			BindDN:             getEnv(prefix+"BIND_DN", ""),
+			BindPassword:       getEnv(prefix+"BIND_PASSWORD", ""),
+			BaseDN:             getEnv(prefix+"BASE_DN", ""),
+			UserFilter:         getEnv(prefix+"USER_FILTER", "(uid=%s)"),
+			EmailAttribute:     getEnv(prefix+"EMAIL_ATTRIBUTE", "mail"),
+			FirstNameAttribute: getEnv(prefix+"FIRST_NAME_ATTRIBUTE", "givenName"),
+			LastNameAttribute:  getEnv(prefix+"LAST_NAME_ATTRIBUTE", "sn"),
+			GroupAttribute:     getEnv(prefix+"GROUP_ATTRIBUTE", "memberOf"),
+			GroupRoles:         map[string]string{},
+			Domains:            getEnvList(prefix + "DOMAINS"),
+			JITProvisioning:    getEnvBool(prefix+"JIT_PROVISIONING", true),
+			DefaultStatusID:    getEnvInt(prefix+"DEFAULT_STATUS_ID", 1),
+		}
+		for _, mapping := range strings.Split(os.Getenv(prefix+"GROUP_ROLES"), ";") {
+			separator := strings.LastIndex(mapping, ":")
+			if separator <= 0 {
+				continue
+			}
+			group := strings.TrimSpace(mapping[:separator])
+			role := strings.TrimSpace(mapping[separator+1:])
+			if group != "" && role != "" {
+				provider.GroupRoles[group] = role
+			}
+		}
+		if provider.URL == "" || provider.BaseDN == "" {
+			log.Printf("LDAP provider %s skipped: %sURL and %sBASE_DN are required", name, prefix, prefix)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s (%q), using default %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s (%q), using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvList splits a comma separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
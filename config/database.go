@@ -10,8 +10,9 @@ import (
 
 var DB *sql.DB
 
-func InitDatabase() {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+// DatabaseDSN returns the connection string for the configured database.
+func DatabaseDSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		AppConfig.DBHost,
 		AppConfig.DBPort,
 		AppConfig.DBUser,
@@ -19,9 +20,11 @@ func InitDatabase() {
 		AppConfig.DBName,
 		AppConfig.DBSSLMode,
 	)
+}
 
+func InitDatabase() {
 	var err error
-	DB, err = sql.Open("postgres", dsn)
+	DB, err = sql.Open("postgres", DatabaseDSN())
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
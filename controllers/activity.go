@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// activityActor describes the authenticated caller of the request for
+// activity log entries.
+func activityActor(c echo.Context) services.ActivityActor {
+	userID, _ := c.Get("user_id").(int)
+	username, _ := c.Get("username").(string)
+	sessionID, _ := c.Get("session_id").(int)
+	impersonatorID, _ := c.Get("impersonator_id").(int)
+
+	return services.ActivityActor{
+		UserID:         userID,
+		Username:       username,
+		SessionID:      sessionID,
+		IPAddress:      c.RealIP(),
+		UserAgent:      c.Request().UserAgent(),
+		ImpersonatorID: impersonatorID,
+	}
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeysController manages the API keys of service accounts.
+type APIKeysController struct {
+	apiKeyService *services.APIKeyService
+}
+
+func NewAPIKeysController(apiKeyService *services.APIKeyService) *APIKeysController {
+	return &APIKeysController{apiKeyService: apiKeyService}
+}
+
+// ListAPIKeys returns the keys of a service account without their secrets.
+func (kc *APIKeysController) ListAPIKeys(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	keys, err := kc.apiKeyService.List(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to load API keys",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    keys,
+	})
+}
+
+// CreateAPIKey issues a key. The key itself is only part of this response.
+func (kc *APIKeysController) CreateAPIKey(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req services.CreateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	callerID, _ := c.Get("user_id").(int)
+	createdBy, _ := c.Get("username").(string)
+	key, err := kc.apiKeyService.Create(callerID, userID, req, createdBy)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		case errors.Is(err, services.ErrAPIKeyPermissions):
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrNotServiceAccount), errors.Is(err, services.ErrAPIKeyLifetime):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create API key",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"message": "Store this key now; it will not be shown again",
+		"data":    key,
+	})
+}
+
+// UpdateAPIKey renames a key or changes its scopes.
+func (kc *APIKeysController) UpdateAPIKey(c echo.Context) error {
+	userID, keyID, err := apiKeyParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	var req services.UpdateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	key, err := kc.apiKeyService.Update(userID, keyID, req)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update API key",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    key,
+	})
+}
+
+// RevokeAPIKey disables a key immediately.
+func (kc *APIKeysController) RevokeAPIKey(c echo.Context) error {
+	userID, keyID, err := apiKeyParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if err := kc.apiKeyService.Revoke(userID, keyID); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to revoke API key",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "API key revoked",
+	})
+}
+
+func apiKeyParams(c echo.Context) (int, int, error) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, errors.New("Invalid user ID")
+	}
+	keyID, err := strconv.Atoi(c.Param("key_id"))
+	if err != nil {
+		return 0, 0, errors.New("Invalid API key ID")
+	}
+	return userID, keyID, nil
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type EmailVerificationController struct {
+	verification *services.EmailVerificationService
+	passwords    *services.PasswordService
+}
+
+func NewEmailVerificationController(verification *services.EmailVerificationService, passwords *services.PasswordService) *EmailVerificationController {
+	return &EmailVerificationController{
+		verification: verification,
+		passwords:    passwords,
+	}
+}
+
+// VerifyEmail consumes the token from a verification email. It needs no
+// session, so the link works in any browser.
+func (vc *EmailVerificationController) VerifyEmail(c echo.Context) error {
+	var req services.VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "token is required",
+		})
+	}
+
+	result, err := vc.verification.Verify(req.Token, c.RealIP(), c.Request().UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidVerificationToken):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrEmailTaken):
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Email verification error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to verify email",
+		})
+	}
+
+	message := "Email address verified. Log in again to continue."
+	if result.Changed {
+		message = "Email address changed"
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": message,
+		"data":    result,
+	})
+}
+
+// ResendVerification mails a new verification link for the caller's address.
+func (vc *EmailVerificationController) ResendVerification(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+
+	if err := vc.verification.SendVerification(userID); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		case errors.Is(err, services.ErrEmailAlreadyVerified):
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Resend verification error for user %d: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to send verification email",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "A verification link has been sent",
+	})
+}
+
+// ChangeEmail starts a change of the caller's address. The current password
+// is required; the change only takes effect once the new address is verified.
+func (vc *EmailVerificationController) ChangeEmail(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+
+	var req services.ChangeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "A valid new_email and current_password are required",
+		})
+	}
+
+	ok, err := vc.passwords.VerifyPassword(userID, req.CurrentPassword)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to verify password",
+		})
+	}
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": services.ErrCurrentPasswordIncorrect.Error(),
+		})
+	}
+
+	if err := vc.verification.RequestEmailChange(activityActor(c), userID, req.NewEmail); err != nil {
+		switch {
+		case errors.Is(err, services.ErrEmailUnchanged):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrEmailTaken):
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Email change error for user %d: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to change email",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "A confirmation link has been sent to the new address. Your current address stays active until it is confirmed.",
+	})
+}
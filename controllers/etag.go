@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jsonWithETag writes body as JSON with an ETag derived from its content, and
+// answers 304 Not Modified when the client already holds that version. The
+// response may be cached privately but has to be revalidated on every use.
+func jsonWithETag(c echo.Context, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(payload)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderCacheControl, "private, no-cache")
+	header.Set("ETag", etag)
+
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, payload)
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
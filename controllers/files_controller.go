@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FilesController serves uploaded files from the configured storage backend.
+type FilesController struct {
+	storage services.FileStorage
+}
+
+func NewFilesController(storage services.FileStorage) *FilesController {
+	return &FilesController{storage: storage}
+}
+
+// ServeFile streams the file stored under the key in the URL. Keys are never
+// reused, so responses may be cached indefinitely.
+func (fc *FilesController) ServeFile(c echo.Context) error {
+	body, contentType, err := fc.storage.Get(c.Request().Context(), c.Param("*"))
+	if err != nil {
+		if errors.Is(err, services.ErrFileNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "File not found",
+			})
+		}
+		log.Printf("Failed to read file %s: %v", c.Param("*"), err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to read file",
+		})
+	}
+	defer body.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderCacheControl, "public, max-age=31536000, immutable")
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	return c.Stream(http.StatusOK, contentType, body)
+}
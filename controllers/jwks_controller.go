@@ -0,0 +1,23 @@
+package controller
+
+import (
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type JWKSController struct {
+	authService *services.AuthService
+}
+
+func NewJWKSController(authService *services.AuthService) *JWKSController {
+	return &JWKSController{authService: authService}
+}
+
+// GetJWKS publishes the token verification keys. Other services cache the
+// document, so a new key must be listed here before it is used for signing.
+func (jc *JWKSController) GetJWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, jc.authService.JWKS())
+}
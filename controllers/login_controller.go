@@ -1,8 +1,14 @@
 package controller
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"v01_system_backend/services"
 
@@ -11,14 +17,28 @@ import (
 
 type LoginController struct {
 	authService *services.AuthService
+	rateLimiter *services.LoginRateLimiter
+	profiles    *services.ProfileService
 }
 
-func NewLoginController(authService *services.AuthService) *LoginController {
+func NewLoginController(authService *services.AuthService, rateLimiter *services.LoginRateLimiter, profiles *services.ProfileService) *LoginController {
 	return &LoginController{
 		authService: authService,
+		rateLimiter: rateLimiter,
+		profiles:    profiles,
 	}
 }
 
+// currentUserResponse keeps the identity fields /auth/me always returned and
+// adds the bootstrap data next to them.
+type currentUserResponse struct {
+	UserID         int    `json:"user_id"`
+	Username       string `json:"username"`
+	Impersonating  bool   `json:"impersonating"`
+	ImpersonatorID int    `json:"impersonator_id"`
+	*services.CurrentUser
+}
+
 func (lc *LoginController) Login(c echo.Context) error {
 	var req services.LoginRequest
 	if err := c.Bind(&req); err != nil {
@@ -38,6 +58,12 @@ func (lc *LoginController) Login(c echo.Context) error {
 	clientIP := c.RealIP()
 	userAgent := c.Request().UserAgent()
 
+	// Throttle before any credential is checked
+	if refused, err := rateLimited(c, lc.rateLimiter, services.RateLimitLogin, req.Username,
+		"Too many login attempts. Please try again later."); refused {
+		return err
+	}
+
 	// Call service (which calls stored procedure)
 	response, err := lc.authService.Login(req, clientIP, userAgent)
 	if err != nil {
@@ -81,39 +107,139 @@ func (lc *LoginController) Logout(c echo.Context) error {
 }
 func (lc *LoginController) GetCurrentUser(c echo.Context) error {
 	// Get user info from context (set by middleware)
-	userID := c.Get("user_id")
-	username := c.Get("username")
+	userID, _ := c.Get("user_id").(int)
+	username, _ := c.Get("username").(string)
+	impersonatorID, _ := c.Get("impersonator_id").(int)
+
+	current, err := lc.profiles.CurrentUser(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to load current user %d: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to load user",
+		})
+	}
+
+	// Clients show a banner while an administrator is signed in as the user.
+	// The ETag lets them revalidate the bootstrap data without downloading it.
+	return jsonWithETag(c, currentUserResponse{
+		UserID:         userID,
+		Username:       username,
+		Impersonating:  impersonatorID != 0,
+		ImpersonatorID: impersonatorID,
+		CurrentUser:    current,
+	})
+}
+
+// UpdatePreferences replaces the caller's preferences with the JSON object in
+// the request body.
+func (lc *LoginController) UpdatePreferences(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 64*1024))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+
+	if err := lc.profiles.SetPreferences(userID, body); err != nil {
+		if errors.Is(err, services.ErrInvalidPreferences) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to save preferences of user %d: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to save preferences",
+		})
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"user_id":  userID,
-		"username": username,
+		"success":     true,
+		"preferences": json.RawMessage(body),
 	})
 }
 
+// Impersonate signs the caller in as another user. The returned tokens belong
+// to that user and carry the caller as impersonator_id.
+func (lc *LoginController) Impersonate(c echo.Context) error {
+	targetID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req services.ImpersonateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "A reason is required",
+		})
+	}
+
+	response, err := lc.authService.Impersonate(activityActor(c), targetID, req.Reason)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		case errors.Is(err, services.ErrImpersonateSelf), errors.Is(err, services.ErrNestedImpersonation):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrImpersonationNotAllowed):
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to start impersonation",
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// RefreshToken exchanges a refresh token for a new access/refresh token pair.
+// The presented refresh token is rotated and can never be used again.
 func (lc *LoginController) RefreshToken(c echo.Context) error {
-	tokenString := lc.extractTokenFromHeader(c)
-	if tokenString == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Authorization header required",
+	var req services.RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Validation failed",
 		})
 	}
 
-	// For now, we'll implement simple token refresh
-	// In production, you might want a separate refresh token mechanism
-	claims, err := lc.authService.ValidateToken(tokenString)
+	response, err := lc.authService.RefreshToken(req.RefreshToken, c.RealIP(), c.Request().UserAgent())
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
 		})
 	}
 
-	// Generate new token (this is simplified - in production use refresh tokens)
-	// This would need to be implemented in the service
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": "Token refresh not yet implemented",
-		"user_id": claims.UserID,
-	})
+	return c.JSON(http.StatusOK, response)
 }
 
 func (lc *LoginController) extractTokenFromHeader(c echo.Context) string {
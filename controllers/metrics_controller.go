@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type MetricsController struct {
+	authService *services.AuthService
+}
+
+func NewMetricsController(authService *services.AuthService) *MetricsController {
+	return &MetricsController{authService: authService}
+}
+
+// GetSessionCacheMetrics returns the session cache counters of this instance.
+// Counters are per process and reset on restart.
+func (mc *MetricsController) GetSessionCacheMetrics(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    mc.authService.SessionCacheStats(),
+	})
+}
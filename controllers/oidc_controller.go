@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// oidcStateCookie keeps the state of a login in the browser that started it.
+const oidcStateCookie = "oidc_state"
+
+// OIDCController drives the browser through the OpenID Connect login. The
+// callback hands the result to the SPA in the URL fragment of
+// postLoginRedirect, so tokens never reach server logs.
+type OIDCController struct {
+	oidcService       *services.OIDCService
+	postLoginRedirect string
+}
+
+func NewOIDCController(oidcService *services.OIDCService, postLoginRedirect string) *OIDCController {
+	return &OIDCController{oidcService: oidcService, postLoginRedirect: postLoginRedirect}
+}
+
+// Providers lists the configured identity providers for the login page.
+func (oc *OIDCController) Providers(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    oc.oidcService.Providers(),
+	})
+}
+
+// Login redirects the browser to the identity provider and keeps the login
+// state in a cookie for the callback.
+func (oc *OIDCController) Login(c echo.Context) error {
+	rememberMe := c.QueryParam("remember_me") == "true"
+
+	authURL, state, err := oc.oidcService.AuthorizationURL(c.Request().Context(), c.Param("provider"), rememberMe)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownOIDCProvider) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("OIDC login for %s failed: %v", c.Param("provider"), err)
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": "Identity provider is unavailable",
+		})
+	}
+
+	// Lax so that the cookie comes along on the provider's top-level
+	// redirect to the callback
+	c.SetCookie(oc.stateCookie(c, state, int(services.OIDCStateTTL.Seconds())))
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes the login and redirects back to the SPA. The state must
+// match the cookie set by Login.
+func (oc *OIDCController) Callback(c echo.Context) error {
+	browserState := ""
+	if cookie, err := c.Cookie(oidcStateCookie); err == nil {
+		browserState = cookie.Value
+	}
+	c.SetCookie(oc.stateCookie(c, "", -1))
+
+	if providerError := c.QueryParam("error"); providerError != "" {
+		return oc.redirectWithError(c, providerError, c.QueryParam("error_description"))
+	}
+
+	state := c.QueryParam("state")
+	code := c.QueryParam("code")
+	if state == "" || code == "" {
+		return oc.redirectWithError(c, "invalid_request", "state and code are required")
+	}
+
+	response, err := oc.oidcService.HandleCallback(c.Request().Context(), c.Param("provider"), state, browserState, code, c.RealIP(), c.Request().UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownOIDCProvider),
+			errors.Is(err, services.ErrInvalidOIDCState),
+			errors.Is(err, services.ErrOIDCAccountNotLinked):
+			return oc.redirectWithError(c, "access_denied", err.Error())
+		default:
+			log.Printf("OIDC callback for %s failed: %v", c.Param("provider"), err)
+			return oc.redirectWithError(c, "server_error", "Sign-in with the identity provider failed")
+		}
+	}
+
+	if !response.Success {
+		return oc.redirectWithError(c, "access_denied", response.Message)
+	}
+
+	fragment := url.Values{}
+	if response.MFARequired {
+		fragment.Set("mfa_required", "true")
+		fragment.Set("challenge_token", response.ChallengeToken)
+	} else {
+		fragment.Set("access_token", response.Token)
+		fragment.Set("refresh_token", response.RefreshToken)
+		fragment.Set("expires_in", strconv.Itoa(response.ExpiresIn))
+		fragment.Set("token_type", "Bearer")
+		if response.MFAEnrollmentRequired {
+			fragment.Set("mfa_enrollment_required", "true")
+		}
+		if response.EmailVerificationRequired {
+			fragment.Set("email_verification_required", "true")
+		}
+		if response.PasswordChangeRequired {
+			fragment.Set("password_change_required", "true")
+		}
+	}
+	return oc.redirect(c, fragment)
+}
+
+// stateCookie returns the login state cookie of the provider in the request
+// path; a negative maxAge deletes it.
+func (oc *OIDCController) stateCookie(c echo.Context, state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/v1/auth/oidc/" + c.Param("provider"),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (oc *OIDCController) redirectWithError(c echo.Context, code, description string) error {
+	fragment := url.Values{}
+	fragment.Set("error", code)
+	if description != "" {
+		fragment.Set("error_description", description)
+	}
+	return oc.redirect(c, fragment)
+}
+
+func (oc *OIDCController) redirect(c echo.Context, fragment url.Values) error {
+	target, err := url.Parse(oc.postLoginRedirect)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Invalid post-login redirect",
+		})
+	}
+	target.Fragment = ""
+	target.RawFragment = ""
+	return c.Redirect(http.StatusFound, target.String()+"#"+fragment.Encode())
+}
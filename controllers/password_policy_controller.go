@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PasswordPolicyController struct {
+	policyService *services.PasswordPolicyService
+}
+
+func NewPasswordPolicyController(policyService *services.PasswordPolicyService) *PasswordPolicyController {
+	return &PasswordPolicyController{policyService: policyService}
+}
+
+// GetPasswordPolicy returns the active password rules so clients can validate
+// passwords as they are typed.
+func (pc *PasswordPolicyController) GetPasswordPolicy(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    pc.policyService.Policy(),
+	})
+}
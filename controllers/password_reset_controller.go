@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PasswordResetController struct {
+	resetService *services.PasswordResetService
+	rateLimiter  *services.LoginRateLimiter
+}
+
+func NewPasswordResetController(resetService *services.PasswordResetService, rateLimiter *services.LoginRateLimiter) *PasswordResetController {
+	return &PasswordResetController{
+		resetService: resetService,
+		rateLimiter:  rateLimiter,
+	}
+}
+
+// ForgotPassword always answers with the same message so the response never
+// reveals whether an account exists for the email. Requests are throttled
+// like logins, by client IP and by email, so the endpoint cannot be used to
+// flood a mailbox.
+func (pc *PasswordResetController) ForgotPassword(c echo.Context) error {
+	response := map[string]interface{}{
+		"success": true,
+		"message": "If an account exists for this email, a password reset link has been sent",
+	}
+
+	var req services.ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "A valid email is required",
+		})
+	}
+
+	if refused, err := rateLimited(c, pc.rateLimiter, services.RateLimitForgotPassword, req.Email,
+		"Too many password reset requests. Please try again later."); refused {
+		return err
+	}
+
+	if err := pc.resetService.RequestReset(req.Email); err != nil {
+		log.Printf("Forgot password error: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword sets a new password using the token from the reset email.
+func (pc *PasswordResetController) ResetPassword(c echo.Context) error {
+	var req services.ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "token and new_password are required",
+		})
+	}
+
+	if err := pc.resetService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error":  "Password does not meet the password policy",
+				"errors": policyErr.Violations,
+			})
+		}
+		if errors.Is(err, services.ErrInvalidResetToken) || errors.Is(err, services.ErrPasswordReused) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Password has been reset. Please log in with your new password.",
+	})
+}
+
+// SendResetLink lets an administrator send a reset link to a user.
+func (pc *PasswordResetController) SendResetLink(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Invalid user ID",
+		})
+	}
+
+	if err := pc.resetService.RequestResetForUser(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"success": false,
+				"message": "User not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to send password reset link",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]string{
+			"message": "Password reset link sent",
+		},
+	})
+}
@@ -55,12 +55,13 @@ func init() {
 	permissionValidate.RegisterValidation("lowercase_underscore", validateLowercaseUnderscore)
 }
 
-// Custom validator for lowercase with underscores
+// Custom validator for lowercase with underscores, dots separate the module
+// from the action (e.g. users.delete)
 func validateLowercaseUnderscore(fl validator.FieldLevel) bool {
 	value := fl.Field().String()
-	// Check if contains only lowercase letters, numbers, and underscores
+	// Check if contains only lowercase letters, numbers, underscores and dots
 	for _, char := range value {
-		if !((char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '_') {
+		if !((char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '_' || char == '.') {
 			return false
 		}
 	}
@@ -106,7 +107,7 @@ func (pc *PermissionController) CreatePermission(c echo.Context) error {
 				case "max":
 					validationErrors = append(validationErrors, fieldError.Field()+" must be at most "+fieldError.Param()+" characters")
 				case "lowercase_underscore":
-					validationErrors = append(validationErrors, fieldError.Field()+" must contain only lowercase letters, numbers, and underscores")
+					validationErrors = append(validationErrors, fieldError.Field()+" must contain only lowercase letters, numbers, underscores, and dots")
 				default:
 					validationErrors = append(validationErrors, fieldError.Field()+" is invalid")
 				}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ProfileController lets signed-in users edit their own profile.
+type ProfileController struct {
+	profiles *services.ProfileService
+	avatars  *services.AvatarService
+}
+
+func NewProfileController(profiles *services.ProfileService, avatars *services.AvatarService) *ProfileController {
+	return &ProfileController{profiles: profiles, avatars: avatars}
+}
+
+// UpdateOwnProfile changes the caller's names and phone number. Other fields
+// in the body are ignored; they are managed by administrators.
+func (pc *ProfileController) UpdateOwnProfile(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+	username, _ := c.Get("username").(string)
+
+	var req services.UpdateProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Names are limited to 100 characters and phone to 20",
+		})
+	}
+
+	profile, err := pc.profiles.UpdateProfile(userID, req, username)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		case errors.Is(err, services.ErrBlankName):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to update profile of user %d: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update profile",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    profile,
+	})
+}
+
+// UploadAvatar replaces the caller's avatar with the image in the "avatar"
+// field of a multipart form.
+func (pc *ProfileController) UploadAvatar(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+	username, _ := c.Get("username").(string)
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "An image is required in the avatar field",
+		})
+	}
+	if header.Size > pc.avatars.MaxSize() {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": services.ErrAvatarTooLarge.Error(),
+		})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": services.ErrAvatarUnreadable.Error(),
+		})
+	}
+	defer file.Close()
+
+	avatar, err := pc.avatars.Upload(c.Request().Context(), userID, file, username)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		case errors.Is(err, services.ErrAvatarTooLarge):
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrAvatarType):
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrAvatarDimensions), errors.Is(err, services.ErrAvatarUnreadable):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to store avatar of user %d: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to store avatar",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    avatar,
+	})
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// rateLimited records an attempt at action for username and, when the
+// limiter refuses it, writes the 429 response with message. It reports
+// whether the request was refused; the caller then returns the error. The
+// limiter falls back to local counters when its store fails, so an error
+// left here means the attempt could not be counted at all and is refused.
+func rateLimited(c echo.Context, limiter *services.LoginRateLimiter, action, username, message string) (bool, error) {
+	decision, err := limiter.AllowAction(action, c.RealIP(), username, c.Request().UserAgent())
+	if err != nil {
+		log.Printf("Rate limiter unavailable for %s: %v", action, err)
+		return true, c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Service temporarily unavailable, please try again later",
+		})
+	}
+	if decision.Allowed {
+		return false, nil
+	}
+
+	retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return true, c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error":       message,
+		"retry_after": retryAfter,
+	})
+}
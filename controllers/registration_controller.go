@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RegistrationController struct {
+	registrations *services.RegistrationService
+	rateLimiter   *services.LoginRateLimiter
+}
+
+func NewRegistrationController(registrations *services.RegistrationService, rateLimiter *services.LoginRateLimiter) *RegistrationController {
+	return &RegistrationController{registrations: registrations, rateLimiter: rateLimiter}
+}
+
+// Register creates an account that waits for an administrator's approval.
+// A username or email that is already taken gets the same answer as a new
+// one, so the endpoint does not reveal which accounts exist. Requests are
+// throttled by client IP and by email.
+func (rc *RegistrationController) Register(c echo.Context) error {
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Your account request has been received. Please verify your email address; you will be notified once an administrator has reviewed the request.",
+	}
+
+	if !rc.registrations.Enabled() {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": services.ErrRegistrationDisabled.Error(),
+		})
+	}
+
+	var req services.RegisterRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "username, a valid email, password, first_name and last_name are required",
+		})
+	}
+
+	if refused, err := rateLimited(c, rc.rateLimiter, services.RateLimitRegister, req.Email,
+		"Too many registration requests. Please try again later."); refused {
+		return err
+	}
+
+	if _, err := rc.registrations.Register(req, c.RealIP(), c.Request().UserAgent()); err != nil {
+		var policyErr *services.PasswordPolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error":  "Password does not meet the password policy",
+				"errors": policyErr.Violations,
+			})
+		case errors.Is(err, services.ErrRegistrationDisabled):
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrRegistrationConflict):
+			log.Printf("Registration for %s refused: %v", req.Username, err)
+			return c.JSON(http.StatusAccepted, response)
+		}
+		log.Printf("Registration error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to register",
+		})
+	}
+
+	return c.JSON(http.StatusAccepted, response)
+}
+
+// ApproveRegistration activates a pending account.
+func (rc *RegistrationController) ApproveRegistration(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	if err := rc.registrations.Approve(activityActor(c), userID); err != nil {
+		return rc.decisionError(c, userID, err, "Failed to approve registration")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Registration approved",
+	})
+}
+
+// RejectRegistration turns down a pending account. The optional reason is
+// included in the email to the applicant.
+func (rc *RegistrationController) RejectRegistration(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req services.RejectRegistrationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "reason is limited to 500 characters",
+		})
+	}
+
+	if err := rc.registrations.Reject(activityActor(c), userID, req.Reason); err != nil {
+		return rc.decisionError(c, userID, err, "Failed to reject registration")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Registration rejected",
+	})
+}
+
+func (rc *RegistrationController) decisionError(c echo.Context, userID int, err error, fallback string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	case errors.Is(err, services.ErrRegistrationNotPending):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	log.Printf("Registration decision error for user %d: %v", userID, err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": fallback,
+	})
+}
@@ -176,10 +176,10 @@ func (c *RoleMenusController) GetAllRoleMenus(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, map[string]interface{}{
 		"data": roleMenus,
 		"pagination": map[string]interface{}{
-			"current_page":  page,
-			"total_pages":   totalPages,
-			"total_count":   totalRecords,
-			"per_page":      limit,
+			"current_page": page,
+			"total_pages":  totalPages,
+			"total_count":  totalRecords,
+			"per_page":     limit,
 		},
 	})
 }
@@ -187,7 +187,7 @@ func (c *RoleMenusController) GetAllRoleMenus(ctx echo.Context) error {
 // GetRoleMenuByID handles GET /roles-menus/:id
 func (c *RoleMenusController) GetRoleMenuByID(ctx echo.Context) error {
 	id := ctx.Param("id")
-	
+
 	query := `
 		SELECT rm.role_menu_id, rm.role_id, ur.roles_name, rm.menu_id, m.menu_name,
 		       rm.can_view, rm.can_create, rm.can_modify, rm.can_delete, 
@@ -316,7 +316,7 @@ func (c *RoleMenusController) CreateRoleMenu(ctx echo.Context) error {
 // UpdateRoleMenu handles PUT /roles-menus/:id
 func (c *RoleMenusController) UpdateRoleMenu(ctx echo.Context) error {
 	id := ctx.Param("id")
-	
+
 	var req RoleMenuRequest
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{
@@ -401,7 +401,7 @@ func (c *RoleMenusController) DeleteRoleMenu(ctx echo.Context) error {
 // GetAllRoles handles GET /users-roles (for dropdown)
 func (c *RoleMenusController) GetAllRoles(ctx echo.Context) error {
 	query := `SELECT roles_id, roles_name FROM users_roles`
-	
+
 	rows, err := c.DB.Query(query)
 	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
@@ -432,7 +432,7 @@ func (c *RoleMenusController) GetAllRoles(ctx echo.Context) error {
 // GetAllMenus handles GET /menus (for dropdown)
 func (c *RoleMenusController) GetAllMenus(ctx echo.Context) error {
 	query := `SELECT menus_id, menu_name FROM menus WHERE is_active = true ORDER BY menu_name`
-	
+
 	rows, err := c.DB.Query(query)
 	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
@@ -463,10 +463,10 @@ func (c *RoleMenusController) GetAllMenus(ctx echo.Context) error {
 // BulkUpdatePermissions handles POST /roles-menus/bulk-update
 func (c *RoleMenusController) BulkUpdatePermissions(ctx echo.Context) error {
 	var req struct {
-		RoleID      int                    `json:"role_id"`
-		Permissions []RoleMenuRequest      `json:"permissions"`
+		RoleID      int               `json:"role_id"`
+		Permissions []RoleMenuRequest `json:"permissions"`
 	}
-	
+
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{
 			"error":   "Invalid request body",
@@ -526,7 +526,7 @@ func (c *RoleMenusController) CopyPermissions(ctx echo.Context) error {
 		FromRoleID int `json:"from_role_id"`
 		ToRoleID   int `json:"to_role_id"`
 	}
-	
+
 	if err := ctx.Bind(&req); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{
 			"error":   "Invalid request body",
@@ -558,7 +558,7 @@ func (c *RoleMenusController) CopyPermissions(ctx echo.Context) error {
 		SELECT $1, menu_id, can_view, can_create, can_modify, can_delete, can_upload, can_download
 		FROM role_menus WHERE role_id = $2
 	`
-	
+
 	result, err := tx.Exec(query, req.ToRoleID, req.FromRoleID)
 	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]interface{}{
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SessionController serves the caller's own sessions. Administrators use
+// UserSessionsController for the global listing.
+type SessionController struct {
+	sessionService *services.SessionService
+}
+
+func NewSessionController(sessionService *services.SessionService) *SessionController {
+	return &SessionController{sessionService: sessionService}
+}
+
+// ListSessions returns the caller's active sessions.
+func (sc *SessionController) ListSessions(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+	sessionID, _ := c.Get("session_id").(int)
+
+	sessions, err := sc.sessionService.ListActive(userID, sessionID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to load sessions",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+// RevokeSession ends one of the caller's sessions.
+func (sc *SessionController) RevokeSession(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid session ID",
+		})
+	}
+
+	if err := sc.sessionService.Revoke(userID, id); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Session revoked",
+	})
+}
+
+// RevokeOtherSessions ends every session of the caller except the current one.
+func (sc *SessionController) RevokeOtherSessions(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+	sessionID, _ := c.Get("session_id").(int)
+
+	count, err := sc.sessionService.RevokeOthers(userID, sessionID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Other sessions revoked",
+		"data": map[string]int{
+			"revoked_count": count,
+		},
+	})
+}
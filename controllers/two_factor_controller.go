@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type TwoFactorController struct {
+	authService *services.AuthService
+	mfaService  *services.MFAService
+	rateLimiter *services.LoginRateLimiter
+}
+
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func NewTwoFactorController(authService *services.AuthService, mfaService *services.MFAService, rateLimiter *services.LoginRateLimiter) *TwoFactorController {
+	return &TwoFactorController{
+		authService: authService,
+		mfaService:  mfaService,
+		rateLimiter: rateLimiter,
+	}
+}
+
+const tooManyCodeAttempts = "Too many two-factor attempts. Please try again later."
+
+// Verify completes a login that returned mfa_required with a TOTP code or a
+// recovery code.
+func (tc *TwoFactorController) Verify(c echo.Context) error {
+	var req services.MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload",
+		})
+	}
+
+	if err := c.Validate(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "challenge_token and code or recovery_code are required",
+		})
+	}
+
+	// Limited by the account of the challenge when it is readable; the
+	// service rejects it otherwise
+	username := ""
+	if claims, err := tc.authService.ValidateToken(req.ChallengeToken); err == nil {
+		username = claims.Username
+	}
+	if refused, err := rateLimited(c, tc.rateLimiter, services.RateLimitMFA, username, tooManyCodeAttempts); refused {
+		return err
+	}
+
+	response, err := tc.authService.VerifyMFAChallenge(req, c.RealIP(), c.Request().UserAgent())
+	if err != nil {
+		return tc.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Enroll starts TOTP enrolment and returns the secret and otpauth:// URL.
+func (tc *TwoFactorController) Enroll(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+
+	enrollment, err := tc.mfaService.Enroll(userID)
+	if err != nil {
+		return tc.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    enrollment,
+	})
+}
+
+// Confirm activates TOTP with a first valid code and returns recovery codes.
+func (tc *TwoFactorController) Confirm(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "code is required",
+		})
+	}
+
+	codes, err := tc.mfaService.Confirm(userID, req.Code)
+	if err != nil {
+		return tc.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Two-factor authentication enabled. Store the recovery codes somewhere safe; log in again to continue.",
+		"data": map[string]interface{}{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// Disable turns TOTP off after checking a current code or a recovery code.
+func (tc *TwoFactorController) Disable(c echo.Context) error {
+	actor := activityActor(c)
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "code or recovery_code is required",
+		})
+	}
+
+	if refused, err := rateLimited(c, tc.rateLimiter, services.RateLimitMFA, actor.Username, tooManyCodeAttempts); refused {
+		return err
+	}
+
+	if err := tc.mfaService.Disable(actor, req.Code, req.RecoveryCode); err != nil {
+		return tc.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP code.
+func (tc *TwoFactorController) RegenerateRecoveryCodes(c echo.Context) error {
+	actor := activityActor(c)
+
+	var req TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "code is required",
+		})
+	}
+
+	if refused, err := rateLimited(c, tc.rateLimiter, services.RateLimitMFA, actor.Username, tooManyCodeAttempts); refused {
+		return err
+	}
+
+	codes, err := tc.mfaService.RegenerateRecoveryCodes(actor, req.Code)
+	if err != nil {
+		return tc.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+func (tc *TwoFactorController) handleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidChallenge), errors.Is(err, services.ErrMFAInvalidCode),
+		errors.Is(err, services.ErrAccountLocked):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnrolled), errors.Is(err, services.ErrMFAAlreadyEnabled):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrMFARequiredForRoles):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	log.Printf("Two-factor error: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Two-factor authentication failed"})
+}
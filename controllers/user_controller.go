@@ -2,14 +2,17 @@ package controller
 
 import (
 	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"v01_system_backend/services"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -29,6 +32,8 @@ type User struct {
 	FailedLoginAttempts int        `json:"failed_login_attempts" db:"failed_login_attempts"`
 	LockedUntil         *time.Time `json:"locked_until" db:"locked_until"`
 	IsActive            bool       `json:"is_active" db:"is_active"`
+	IsServiceAccount    bool       `json:"is_service_account" db:"is_service_account"`
+	MustChangePassword  bool       `json:"must_change_password" db:"must_change_password"`
 	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
 	CreatedBy           *string    `json:"created_by" db:"created_by"`
 	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
@@ -38,25 +43,31 @@ type User struct {
 type CreateUserRequest struct {
 	Username     string  `json:"username" validate:"required,min=3,max=50"`
 	Email        string  `json:"email" validate:"required,email,max=100"`
-	Password     string  `json:"password" validate:"required,min=6,max=100"`
+	Password     string  `json:"password" validate:"required"`
 	FirstName    string  `json:"first_name" validate:"required,min=1,max=50"`
 	LastName     string  `json:"last_name" validate:"required,min=1,max=50"`
 	StatusID     int     `json:"status_id" validate:"required,min=1"`
 	DepartmentID *int    `json:"department_id" validate:"omitempty,min=1"`
 	EmployeeID   *string `json:"employee_id" validate:"omitempty,max=50"`
 	Phone        *string `json:"phone" validate:"omitempty,max=20"`
+	// Service accounts cannot sign in and authenticate with API keys only
+	IsServiceAccount bool `json:"is_service_account"`
+	// MustChangePassword limits the first sessions to changing the password
+	MustChangePassword bool `json:"must_change_password"`
 }
 
 type UpdateUserRequest struct {
 	FirstName    string  `json:"first_name" validate:"required,min=1,max=100"`
 	LastName     string  `json:"last_name" validate:"required,min=1,max=100"`
-	Email        string  `json:"email" validate:"required,email,max=255"`
+	Email        string  `json:"email" validate:"required,email,max=100"`
 	StatusID     int     `json:"status_id" validate:"required,min=1"`
 	DepartmentID *int    `json:"department_id"`
 	EmployeeID   *string `json:"employee_id" validate:"omitempty,max=50"`
 	Phone        *string `json:"phone" validate:"omitempty,max=20"`
 	IsActive     bool    `json:"is_active"`
-	Password     *string `json:"password,omitempty" validate:"omitempty,min=6"`
+	Password     *string `json:"password,omitempty"`
+	// MustChangePassword is left unchanged when omitted
+	MustChangePassword *bool `json:"must_change_password,omitempty"`
 }
 
 type LoginRequest struct {
@@ -70,7 +81,20 @@ type LoginResponse struct {
 }
 
 type UserController struct {
-	DB *sql.DB
+	DB           *sql.DB
+	passwords    *services.PasswordService
+	lockout      *services.LockoutService
+	verification *services.EmailVerificationService
+	bulk         *services.UserBulkService
+}
+
+type LockUserRequest struct {
+	DurationMinutes int    `json:"duration_minutes" validate:"min=0"`
+	Reason          string `json:"reason" validate:"max=255"`
+}
+
+type UnlockUserRequest struct {
+	Reason string `json:"reason" validate:"max=255"`
 }
 
 var validate *validator.Validate
@@ -78,8 +102,8 @@ var validate *validator.Validate
 func init() {
 	validate = validator.New()
 }
-func NewUserController(db *sql.DB) *UserController {
-	return &UserController{DB: db}
+func NewUserController(db *sql.DB, passwords *services.PasswordService, lockout *services.LockoutService, verification *services.EmailVerificationService, bulk *services.UserBulkService) *UserController {
+	return &UserController{DB: db, passwords: passwords, lockout: lockout, verification: verification, bulk: bulk}
 }
 
 // Response helpers
@@ -97,6 +121,26 @@ func (uc *UserController) errorResponse(c echo.Context, code int, message string
 	})
 }
 
+// passwordErrorResponse maps password service errors to responses. Policy
+// violations are returned individually so the client can highlight them.
+func (uc *UserController) passwordErrorResponse(c echo.Context, err error, fallback string) error {
+	var policyErr *services.PasswordPolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Password does not meet the password policy",
+			"errors":  policyErr.Violations,
+		})
+	case errors.Is(err, services.ErrPasswordReused):
+		return uc.errorResponse(c, http.StatusBadRequest, err.Error())
+	case err == sql.ErrNoRows:
+		return uc.errorResponse(c, http.StatusNotFound, "User not found")
+	default:
+		return uc.errorResponse(c, http.StatusInternalServerError, fallback)
+	}
+}
+
 // Create User
 func (uc *UserController) CreateUser(c echo.Context) error {
 	var req CreateUserRequest
@@ -106,25 +150,7 @@ func (uc *UserController) CreateUser(c echo.Context) error {
 
 	// Validate request menggunakan validator langsung
 	if err := validate.Struct(&req); err != nil {
-		// Format validation errors
-		validationErrors := make([]string, 0)
-		if validatorErr, ok := err.(validator.ValidationErrors); ok {
-			for _, fieldError := range validatorErr {
-				switch fieldError.Tag() {
-				case "required":
-					validationErrors = append(validationErrors, fieldError.Field()+" is required")
-				case "email":
-					validationErrors = append(validationErrors, "Invalid email format")
-				case "min":
-					validationErrors = append(validationErrors, fieldError.Field()+" must be at least "+fieldError.Param()+" characters")
-				case "max":
-					validationErrors = append(validationErrors, fieldError.Field()+" must be at most "+fieldError.Param()+" characters")
-				default:
-					validationErrors = append(validationErrors, fieldError.Field()+" is invalid")
-				}
-			}
-		}
-		return uc.errorResponse(c, http.StatusBadRequest, strings.Join(validationErrors, ", "))
+		return uc.errorResponse(c, http.StatusBadRequest, strings.Join(createUserValidationErrors(err), ", "))
 	}
 
 	// Check if username or email already exists
@@ -138,8 +164,19 @@ func (uc *UserController) CreateUser(c echo.Context) error {
 		return uc.errorResponse(c, http.StatusConflict, "Username or email already exists")
 	}
 
+	// Check password policy
+	subject := services.PasswordSubject{
+		Username:  req.Username,
+		Email:     req.Email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	}
+	if err := uc.passwords.ValidatePassword(req.Password, subject); err != nil {
+		return uc.passwordErrorResponse(c, err, "Failed to process password")
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := uc.passwords.HashPassword(req.Password)
 	if err != nil {
 		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to process password")
 	}
@@ -147,20 +184,29 @@ func (uc *UserController) CreateUser(c echo.Context) error {
 	// Insert to database
 	query := `INSERT INTO users_application 
               (username, email, password_hash, first_name, last_name, status_id, 
-               department_id, employee_id, phone, is_active, created_by, password_changed_at) 
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, true, $10, CURRENT_TIMESTAMP) 
+               department_id, employee_id, phone, is_active, is_service_account, created_by, password_changed_at,
+               must_change_password) 
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, true, $10, $11, CURRENT_TIMESTAMP, $12) 
               RETURNING user_apps_id`
 
 	var userID int
 	err = uc.DB.QueryRow(query,
-		req.Username, req.Email, string(hashedPassword),
+		req.Username, req.Email, hashedPassword,
 		req.FirstName, req.LastName, req.StatusID, req.DepartmentID,
-		req.EmployeeID, req.Phone, "system").Scan(&userID)
+		req.EmployeeID, req.Phone, req.IsServiceAccount, "system",
+		req.MustChangePassword && !req.IsServiceAccount).Scan(&userID)
 
 	if err != nil {
 		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to create user")
 	}
 
+	// Service accounts never receive mail
+	if !req.IsServiceAccount {
+		if err := uc.verification.SendVerification(userID); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		}
+	}
+
 	return uc.successResponse(c, map[string]interface{}{
 		"id":      userID,
 		"message": "User created successfully",
@@ -178,7 +224,7 @@ func (uc *UserController) GetUser(c echo.Context) error {
 	query := `SELECT user_apps_id, username, email, first_name, last_name, status_id, 
               department_id, employee_id, phone, avatar_url, last_login_at, 
               password_changed_at, failed_login_attempts, locked_until, is_active, 
-              created_at, created_by, updated_at, updated_by
+              is_service_account, must_change_password, created_at, created_by, updated_at, updated_by
               FROM users_application WHERE user_apps_id = $1`
 
 	err = uc.DB.QueryRow(query, id).Scan(
@@ -186,7 +232,7 @@ func (uc *UserController) GetUser(c echo.Context) error {
 		&user.LastName, &user.StatusID, &user.DepartmentID, &user.EmployeeID,
 		&user.Phone, &user.AvatarURL, &user.LastLoginAt, &user.PasswordChangedAt,
 		&user.FailedLoginAttempts, &user.LockedUntil, &user.IsActive,
-		&user.CreatedAt, &user.CreatedBy, &user.UpdatedAt, &user.UpdatedBy)
+		&user.IsServiceAccount, &user.MustChangePassword, &user.CreatedAt, &user.CreatedBy, &user.UpdatedAt, &user.UpdatedBy)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -197,13 +243,27 @@ func (uc *UserController) GetUser(c echo.Context) error {
 
 	return uc.successResponse(c, user)
 }
+
+// userFilterFromQuery reads the user filters shared by GET /users and the
+// exports. Malformed values are ignored.
+func userFilterFromQuery(c echo.Context) *services.UserFilter {
+	filter := &services.UserFilter{Search: c.QueryParam("search")}
+	if sid, err := strconv.Atoi(c.QueryParam("status_id")); err == nil {
+		filter.StatusID = &sid
+	}
+	if did, err := strconv.Atoi(c.QueryParam("department_id")); err == nil {
+		filter.DepartmentID = &did
+	}
+	if sa, err := strconv.ParseBool(c.QueryParam("service_account")); err == nil {
+		filter.IsServiceAccount = &sa
+	}
+	return filter
+}
+
 func (uc *UserController) GetAllUsers(c echo.Context) error {
 	// Get query parameters
 	page := c.QueryParam("page")
 	limit := c.QueryParam("limit")
-	statusID := c.QueryParam("status_id")
-	departmentID := c.QueryParam("department_id")
-	search := c.QueryParam("search")
 
 	// Set default pagination
 	pageInt := 1
@@ -216,43 +276,9 @@ func (uc *UserController) GetAllUsers(c echo.Context) error {
 	}
 	offset := (pageInt - 1) * limitInt
 
-	// Build dynamic WHERE clause
-	whereConditions := []string{}
-	args := []interface{}{}
-	argIndex := 1
-
-	if statusID != "" {
-		if sid, err := strconv.Atoi(statusID); err == nil {
-			whereConditions = append(whereConditions, "status_id = $"+strconv.Itoa(argIndex))
-			args = append(args, sid)
-			argIndex++
-		}
-	}
-
-	if departmentID != "" {
-		if did, err := strconv.Atoi(departmentID); err == nil {
-			whereConditions = append(whereConditions, "department_id = $"+strconv.Itoa(argIndex))
-			args = append(args, did)
-			argIndex++
-		}
-	}
-
-	if search != "" {
-		searchPattern := "%" + strings.ToLower(search) + "%"
-		condition := `(LOWER(username) LIKE $` + strconv.Itoa(argIndex) +
-			` OR LOWER(email) LIKE $` + strconv.Itoa(argIndex) +
-			` OR LOWER(first_name) LIKE $` + strconv.Itoa(argIndex) +
-			` OR LOWER(last_name) LIKE $` + strconv.Itoa(argIndex) +
-			` OR LOWER(employee_id) LIKE $` + strconv.Itoa(argIndex) + `)`
-		whereConditions = append(whereConditions, condition)
-		args = append(args, searchPattern)
-		argIndex++
-	}
-
-	whereClause := ""
-	if len(whereConditions) > 0 {
-		whereClause = "WHERE " + strings.Join(whereConditions, " AND ")
-	}
+	where, args := userFilterFromQuery(c).Where("", 1)
+	whereClause := "WHERE " + where
+	argIndex := len(args) + 1
 
 	// Count total users
 	var totalCount int
@@ -265,7 +291,7 @@ func (uc *UserController) GetAllUsers(c echo.Context) error {
 	query := `SELECT user_apps_id, username, email, first_name, last_name, status_id, 
               department_id, employee_id, phone, avatar_url, last_login_at, 
               password_changed_at, failed_login_attempts, locked_until, is_active, 
-              created_at, created_by, updated_at, updated_by
+              is_service_account, must_change_password, created_at, created_by, updated_at, updated_by
               FROM users_application ` + whereClause + `
               ORDER BY created_at DESC 
               LIMIT $` + strconv.Itoa(argIndex) + ` OFFSET $` + strconv.Itoa(argIndex+1)
@@ -286,7 +312,7 @@ func (uc *UserController) GetAllUsers(c echo.Context) error {
 			&user.LastName, &user.StatusID, &user.DepartmentID, &user.EmployeeID,
 			&user.Phone, &user.AvatarURL, &user.LastLoginAt, &user.PasswordChangedAt,
 			&user.FailedLoginAttempts, &user.LockedUntil, &user.IsActive,
-			&user.CreatedAt, &user.CreatedBy, &user.UpdatedAt, &user.UpdatedBy,
+			&user.IsServiceAccount, &user.MustChangePassword, &user.CreatedAt, &user.CreatedBy, &user.UpdatedAt, &user.UpdatedBy,
 		); err == nil {
 			users = append(users, user)
 		}
@@ -330,11 +356,7 @@ func (uc *UserController) UpdateUser(c echo.Context) error {
 				case "email":
 					validationErrors = append(validationErrors, "Invalid email format")
 				case "min":
-					if fieldError.Field() == "Password" {
-						validationErrors = append(validationErrors, "Password must be at least 6 characters")
-					} else {
-						validationErrors = append(validationErrors, fieldError.Field()+" is too short")
-					}
+					validationErrors = append(validationErrors, fieldError.Field()+" is too short")
 				case "max":
 					validationErrors = append(validationErrors, fieldError.Field()+" is too long")
 				default:
@@ -346,14 +368,16 @@ func (uc *UserController) UpdateUser(c echo.Context) error {
 	}
 
 	// Check if user exists
-	var exists bool
-	checkQuery := `SELECT EXISTS(SELECT 1 FROM users_application WHERE user_apps_id = $1)`
-	err = uc.DB.QueryRow(checkQuery, id).Scan(&exists)
-	if err != nil || !exists {
+	var currentEmail string
+	var isServiceAccount bool
+	checkQuery := `SELECT email, is_service_account FROM users_application WHERE user_apps_id = $1`
+	err = uc.DB.QueryRow(checkQuery, id).Scan(&currentEmail, &isServiceAccount)
+	if err != nil {
 		return uc.errorResponse(c, http.StatusNotFound, "User not found")
 	}
 
 	// Check if email is taken by another user
+	var exists bool
 	checkEmailQuery := `SELECT EXISTS(SELECT 1 FROM users_application WHERE email = $1 AND user_apps_id != $2)`
 	err = uc.DB.QueryRow(checkEmailQuery, req.Email, id).Scan(&exists)
 	if err != nil {
@@ -363,51 +387,180 @@ func (uc *UserController) UpdateUser(c echo.Context) error {
 		return uc.errorResponse(c, http.StatusConflict, "Email already exists")
 	}
 
-	// Prepare the update query
-	var query string
-	var args []interface{}
+	actor, _ := c.Get("username").(string)
+
+	// A new address only replaces the current one once it is confirmed.
+	// Service accounts receive no mail, so their address changes directly.
+	email := req.Email
+	emailChanged := !strings.EqualFold(currentEmail, req.Email) && !isServiceAccount
+	if emailChanged {
+		email = currentEmail
+	}
+
+	query := `UPDATE users_application 
+			SET first_name = $1, last_name = $2, email = $3, status_id = $4,
+				department_id = $5, employee_id = $6, phone = $7, is_active = $8,
+				updated_by = $9, updated_at = CURRENT_TIMESTAMP,
+				must_change_password = COALESCE($11, must_change_password)
+			WHERE user_apps_id = $10`
+
+	args := []interface{}{
+		req.FirstName, req.LastName, email, req.StatusID,
+		req.DepartmentID, req.EmployeeID, req.Phone, req.IsActive,
+		actor, id, req.MustChangePassword,
+	}
+
+	tx, err := uc.DB.Begin()
+	if err != nil {
+		return uc.errorResponse(c, http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback()
 
+	// Password changes go through the password service so history is
+	// enforced; a rejected password leaves the profile unchanged
 	if req.Password != nil && *req.Password != "" {
-		// Update with password
-		query = `UPDATE users_application 
-				SET first_name = $1, last_name = $2, email = $3, status_id = $4,
-					department_id = $5, employee_id = $6, phone = $7, is_active = $8,
-					password_hash = $9, updated_by = $10, updated_at = CURRENT_TIMESTAMP
-				WHERE user_apps_id = $11`
-
-		// Hash the password here
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return uc.errorResponse(c, http.StatusInternalServerError, "Failed to hash password")
+		if err := uc.passwords.SetPasswordTx(tx, id, *req.Password, actor, -1); err != nil {
+			return uc.passwordErrorResponse(c, err, "Failed to update password")
 		}
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to update user")
+	}
+	if err := tx.Commit(); err != nil {
+		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to update user")
+	}
 
-		args = []interface{}{
-			req.FirstName, req.LastName, req.Email, req.StatusID,
-			req.DepartmentID, req.EmployeeID, req.Phone, req.IsActive,
-			string(hashedPassword),
-			"system", id,
+	if emailChanged {
+		if err := uc.verification.RequestEmailChange(activityActor(c), id, req.Email); err != nil {
+			if errors.Is(err, services.ErrEmailTaken) {
+				return uc.errorResponse(c, http.StatusConflict, "Email already exists")
+			}
+			log.Printf("Email change error for user %d: %v", id, err)
+			return uc.errorResponse(c, http.StatusInternalServerError, "Failed to request email change")
 		}
-	} else {
-		// Update without password
-		query = `UPDATE users_application 
-				SET first_name = $1, last_name = $2, email = $3, status_id = $4,
-					department_id = $5, employee_id = $6, phone = $7, is_active = $8,
-					updated_by = $9, updated_at = CURRENT_TIMESTAMP
-				WHERE user_apps_id = $10`
-
-		args = []interface{}{
-			req.FirstName, req.LastName, req.Email, req.StatusID,
-			req.DepartmentID, req.EmployeeID, req.Phone, req.IsActive,
-			"system", id,
+		return uc.successResponse(c, map[string]string{"message": "User updated successfully. The new email address takes effect once it is confirmed."})
+	}
+
+	return uc.successResponse(c, map[string]string{"message": "User updated successfully"})
+}
+
+// Change own password
+func (uc *UserController) ChangeOwnPassword(c echo.Context) error {
+	userID, _ := c.Get("user_id").(int)
+	username, _ := c.Get("username").(string)
+	sessionID, _ := c.Get("session_id").(int)
+
+	return uc.changePassword(c, userID, userID, username, sessionID)
+}
+
+// Change another user's password (admin). current_password is the caller's
+// own password, re-checked before the target account is touched.
+func (uc *UserController) ChangePassword(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid user ID")
+	}
+
+	adminID, _ := c.Get("user_id").(int)
+	username, _ := c.Get("username").(string)
+	sessionID := 0
+	if id == adminID {
+		sessionID, _ = c.Get("session_id").(int)
+	}
+
+	return uc.changePassword(c, adminID, id, username, sessionID)
+}
+
+func (uc *UserController) changePassword(c echo.Context, verifyUserID, targetUserID int, updatedBy string, keepSessionID int) error {
+	var req services.ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid request format")
+	}
+
+	if err := uc.validateRequest(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "current_password and new_password are required")
+	}
+
+	// current_password is a password guess like a login and falls under the
+	// same lockout policy
+	lockedUntil, err := uc.lockout.LockedUntil(verifyUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return uc.errorResponse(c, http.StatusNotFound, "User not found")
 		}
+		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to verify password")
+	}
+	if lockedUntil != nil {
+		return uc.errorResponse(c, http.StatusForbidden, "Account is temporarily locked")
 	}
 
-	_, err = uc.DB.Exec(query, args...)
+	ok, err := uc.passwords.VerifyPassword(verifyUserID, req.CurrentPassword)
 	if err != nil {
-		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to update user")
+		if err == sql.ErrNoRows {
+			return uc.errorResponse(c, http.StatusNotFound, "User not found")
+		}
+		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to verify password")
+	}
+	if !ok {
+		locked, err := uc.lockout.RecordFailure(verifyUserID, activityActor(c))
+		if err != nil {
+			log.Printf("Failed to record failed password check of user %d: %v", verifyUserID, err)
+		}
+		if locked != nil {
+			return uc.errorResponse(c, http.StatusForbidden, "Too many failed attempts. Account is temporarily locked.")
+		}
+		return uc.errorResponse(c, http.StatusBadRequest, services.ErrCurrentPasswordIncorrect.Error())
+	}
+	if err := uc.lockout.RecordSuccess(verifyUserID); err != nil {
+		log.Printf("Failed to reset failed password checks of user %d: %v", verifyUserID, err)
 	}
 
-	return uc.successResponse(c, map[string]string{"message": "User updated successfully"})
+	revokeExcept := -1
+	if req.LogoutOtherSessions {
+		revokeExcept = keepSessionID
+	}
+
+	if err := uc.passwords.SetPassword(targetUserID, req.NewPassword, updatedBy, revokeExcept); err != nil {
+		return uc.passwordErrorResponse(c, err, "Failed to change password")
+	}
+
+	// An administrator may hand out a temporary password
+	if req.MustChangePassword && targetUserID != verifyUserID {
+		if err := uc.passwords.RequirePasswordChange(targetUserID, updatedBy); err != nil {
+			return uc.errorResponse(c, http.StatusInternalServerError, "Failed to require password change")
+		}
+	}
+
+	// A session limited to the password change stays limited
+	if scope, _ := c.Get("token_scope").(string); scope == services.ScopePasswordChange {
+		return uc.successResponse(c, map[string]string{"message": "Password changed successfully. Log in again to continue."})
+	}
+
+	return uc.successResponse(c, map[string]string{"message": "Password changed successfully"})
+}
+
+// createUserValidationErrors formats the validation errors of a
+// CreateUserRequest, one message per field
+func createUserValidationErrors(err error) []string {
+	validationErrors := make([]string, 0)
+	if validatorErr, ok := err.(validator.ValidationErrors); ok {
+		for _, fieldError := range validatorErr {
+			switch fieldError.Tag() {
+			case "required":
+				validationErrors = append(validationErrors, fieldError.Field()+" is required")
+			case "email":
+				validationErrors = append(validationErrors, "Invalid email format")
+			case "min":
+				validationErrors = append(validationErrors, fieldError.Field()+" must be at least "+fieldError.Param()+" characters")
+			case "max":
+				validationErrors = append(validationErrors, fieldError.Field()+" must be at most "+fieldError.Param()+" characters")
+			default:
+				validationErrors = append(validationErrors, fieldError.Field()+" is invalid")
+			}
+		}
+	}
+	return validationErrors
 }
 
 // validateRequest validates a struct using the validator package
@@ -442,6 +595,49 @@ func (uc *UserController) DeleteUser(c echo.Context) error {
 	return uc.successResponse(c, map[string]string{"message": "User deleted successfully"})
 }
 
+// Bulk update users: status, department or active flag for a list of IDs or
+// a filter. Users that fail are reported and left unchanged.
+func (uc *UserController) BulkUpdateUsers(c echo.Context) error {
+	var req services.BulkUpdateUsersRequest
+	if err := c.Bind(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid request format")
+	}
+	if err := uc.validateRequest(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "status_id and department_id must be positive")
+	}
+
+	response, err := uc.bulk.Update(activityActor(c), req)
+	if err != nil {
+		return uc.bulkErrorResponse(c, err, "Failed to update users")
+	}
+	return uc.successResponse(c, response)
+}
+
+// Bulk delete users (soft delete)
+func (uc *UserController) BulkDeleteUsers(c echo.Context) error {
+	var req services.BulkDeleteUsersRequest
+	if err := c.Bind(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid request format")
+	}
+
+	response, err := uc.bulk.Delete(activityActor(c), req)
+	if err != nil {
+		return uc.bulkErrorResponse(c, err, "Failed to delete users")
+	}
+	return uc.successResponse(c, response)
+}
+
+func (uc *UserController) bulkErrorResponse(c echo.Context, err error, fallback string) error {
+	switch {
+	case errors.Is(err, services.ErrBulkTarget), errors.Is(err, services.ErrBulkNoChanges),
+		errors.Is(err, services.ErrBulkTooMany), errors.Is(err, services.ErrBulkUnknownStatus),
+		errors.Is(err, services.ErrBulkUnknownDept):
+		return uc.errorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	log.Printf("Bulk user operation error: %v", err)
+	return uc.errorResponse(c, http.StatusInternalServerError, fallback)
+}
+
 // Login with enhanced security
 func (uc *UserController) Login(c echo.Context) error {
 	var req LoginRequest
@@ -455,16 +651,15 @@ func (uc *UserController) Login(c echo.Context) error {
 	}
 
 	var user User
-	var passwordHash string
-	query := `SELECT user_apps_id, username, email, first_name, last_name, password_hash, 
+	query := `SELECT user_apps_id, username, email, first_name, last_name, 
               status_id, department_id, employee_id, phone, avatar_url, 
               failed_login_attempts, locked_until, is_active
               FROM users_application 
-              WHERE username = $1 AND is_active = true`
+              WHERE username = $1 AND is_active = true AND is_service_account = false`
 
 	err := uc.DB.QueryRow(query, req.Username).Scan(
 		&user.ID, &user.Username, &user.Email, &user.FirstName,
-		&user.LastName, &passwordHash, &user.StatusID, &user.DepartmentID,
+		&user.LastName, &user.StatusID, &user.DepartmentID,
 		&user.EmployeeID, &user.Phone, &user.AvatarURL,
 		&user.FailedLoginAttempts, &user.LockedUntil, &user.IsActive)
 
@@ -481,20 +676,19 @@ func (uc *UserController) Login(c echo.Context) error {
 	}
 
 	// Check password
-	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password))
+	ok, err := uc.passwords.VerifyPassword(user.ID, req.Password)
 	if err != nil {
-		// Increment failed login attempts
-		uc.incrementFailedLogins(user.ID)
+		return uc.errorResponse(c, http.StatusInternalServerError, "Login failed")
+	}
+	if !ok {
+		// Count the failure against the lockout policy
+		uc.lockout.RecordFailure(user.ID, activityActor(c))
 		return uc.errorResponse(c, http.StatusUnauthorized, "Invalid credentials")
 	}
 
 	// Reset failed login attempts and update last login
-	updateQuery := `UPDATE users_application 
-                    SET last_login_at = CURRENT_TIMESTAMP, 
-                        failed_login_attempts = 0, 
-                        locked_until = NULL 
-                    WHERE user_apps_id = $1`
-	uc.DB.Exec(updateQuery, user.ID)
+	uc.lockout.RecordSuccess(user.ID)
+	uc.DB.Exec(`UPDATE users_application SET last_login_at = CURRENT_TIMESTAMP WHERE user_apps_id = $1`, user.ID)
 
 	return uc.successResponse(c, LoginResponse{
 		User:    user,
@@ -502,20 +696,66 @@ func (uc *UserController) Login(c echo.Context) error {
 	})
 }
 
-// Helper function to increment failed login attempts
-func (uc *UserController) incrementFailedLogins(userID int) {
-	const maxAttempts = 5
-	const lockDuration = 30 * time.Minute
+// Lock User
+func (uc *UserController) LockUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid user ID")
+	}
 
-	query := `UPDATE users_application 
-              SET failed_login_attempts = failed_login_attempts + 1,
-                  locked_until = CASE 
-                      WHEN failed_login_attempts + 1 >= $1 THEN $2
-                      ELSE locked_until
-                  END
-              WHERE user_apps_id = $3`
-
-	uc.DB.Exec(query, maxAttempts, time.Now().Add(lockDuration), userID)
+	var req LockUserRequest
+	if err := c.Bind(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid request format")
+	}
+	if err := uc.validateRequest(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "duration_minutes must not be negative and reason at most 255 characters")
+	}
+
+	if adminID, _ := c.Get("user_id").(int); adminID == id {
+		return uc.errorResponse(c, http.StatusBadRequest, "You cannot lock your own account")
+	}
+
+	lockedUntil, err := uc.lockout.Lock(id, time.Duration(req.DurationMinutes)*time.Minute, req.Reason, activityActor(c))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return uc.errorResponse(c, http.StatusNotFound, "User not found")
+		}
+		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to lock user")
+	}
+
+	data := map[string]interface{}{
+		"message":      "User locked successfully",
+		"locked_until": nil,
+	}
+	if req.DurationMinutes > 0 {
+		data["locked_until"] = lockedUntil
+	}
+	return uc.successResponse(c, data)
+}
+
+// Unlock User
+func (uc *UserController) UnlockUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid user ID")
+	}
+
+	var req UnlockUserRequest
+	if err := c.Bind(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "Invalid request format")
+	}
+	if err := uc.validateRequest(&req); err != nil {
+		return uc.errorResponse(c, http.StatusBadRequest, "reason must be at most 255 characters")
+	}
+
+	if err := uc.lockout.Unlock(id, req.Reason, activityActor(c)); err != nil {
+		if err == sql.ErrNoRows {
+			return uc.errorResponse(c, http.StatusNotFound, "User not found")
+		}
+		return uc.errorResponse(c, http.StatusInternalServerError, "Failed to unlock user")
+	}
+
+	return uc.successResponse(c, map[string]string{"message": "User unlocked successfully"})
 }
 
 // Get Users by Status
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserExportController serves GET /users/export/*. The query parameters are
+// those of GET /users without pagination, plus columns, a comma separated
+// list of column keys.
+type UserExportController struct {
+	exports *services.UserExportService
+}
+
+func NewUserExportController(exports *services.UserExportService) *UserExportController {
+	return &UserExportController{exports: exports}
+}
+
+// ExportUsersCSV exports the filtered users as CSV.
+func (ec *UserExportController) ExportUsersCSV(c echo.Context) error {
+	return ec.export(c, services.ExportCSV, "text/csv; charset=utf-8", "csv")
+}
+
+// ExportUsersExcel exports the filtered users as an Excel workbook.
+func (ec *UserExportController) ExportUsersExcel(c echo.Context) error {
+	return ec.export(c, services.ExportXLSX, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx")
+}
+
+// ExportUsersPDF exports the filtered users as a PDF table.
+func (ec *UserExportController) ExportUsersPDF(c echo.Context) error {
+	return ec.export(c, services.ExportPDF, "application/pdf", "pdf")
+}
+
+func (ec *UserExportController) export(c echo.Context, format, contentType, extension string) error {
+	columns, err := services.ParseUserExportColumns(c.QueryParam("columns"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":   err.Error(),
+			"columns": services.UserExportColumns(),
+		})
+	}
+
+	filter := userFilterFromQuery(c)
+
+	filename := "users_" + time.Now().Format("20060102_150405") + "." + extension
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	_, err = ec.exports.Export(c.Request().Context(), activityActor(c), format, filter, columns, c.Response())
+	if err == nil {
+		return nil
+	}
+	if c.Response().Committed {
+		// Part of the file has been sent; all that is left is to stop
+		log.Printf("User export (%s) aborted: %v", format, err)
+		return nil
+	}
+
+	header.Del(echo.HeaderContentDisposition)
+	if errors.Is(err, services.ErrExportTooLarge) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	log.Printf("User export (%s) error: %v", format, err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to export users",
+	})
+}
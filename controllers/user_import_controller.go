@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserImportController serves the /users/import endpoints: upload a file
+// for a preview, confirm it, follow the job and download its report.
+type UserImportController struct {
+	imports *services.UserImportService
+}
+
+func NewUserImportController(imports *services.UserImportService) *UserImportController {
+	return &UserImportController{imports: imports}
+}
+
+// ImportUsers validates the CSV or Excel file in the "file" field of a
+// multipart form and returns the per-row preview of a new import job. The
+// optional "mapping" field is a JSON object from file headers to fields and
+// "send_invitations" (default true) controls the invitation emails.
+func (ic *UserImportController) ImportUsers(c echo.Context) error {
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "A .csv or .xlsx file is required in the file field",
+		})
+	}
+	if header.Size > services.MaxUserImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": "Import files are limited to 10 MB",
+		})
+	}
+
+	var mapping map[string]string
+	if raw := c.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "mapping must be a JSON object from column headers to fields",
+			})
+		}
+	}
+	sendInvitations := true
+	if raw := c.FormValue("send_invitations"); raw != "" {
+		if sendInvitations, err = strconv.ParseBool(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "send_invitations must be true or false",
+			})
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": services.ErrImportUnreadable.Error(),
+		})
+	}
+	defer file.Close()
+
+	preview, err := ic.imports.Preview(activityActor(c), header.Filename, file, mapping, sendInvitations, checkImportedUser)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrImportFileType):
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrImportUnreadable), errors.Is(err, services.ErrImportEmpty),
+			errors.Is(err, services.ErrImportTooMany), errors.Is(err, services.ErrImportMissingColumns),
+			errors.Is(err, services.ErrImportUnknownField), errors.Is(err, services.ErrImportDuplicateColumns):
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("User import preview error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to read import file",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    preview,
+	})
+}
+
+// checkImportedUser applies the validation of POST /users to an imported
+// record. Imported users choose their password through the invitation and
+// the import service checks the status code itself, so both are filled in.
+func checkImportedUser(record services.UserImportRecord) []string {
+	req := CreateUserRequest{
+		Username:         record.Username,
+		Email:            record.Email,
+		Password:         "-",
+		FirstName:        record.FirstName,
+		LastName:         record.LastName,
+		StatusID:         1,
+		DepartmentID:     record.DepartmentID,
+		IsServiceAccount: record.IsServiceAccount,
+	}
+	if record.EmployeeID != "" {
+		req.EmployeeID = &record.EmployeeID
+	}
+	if record.Phone != "" {
+		req.Phone = &record.Phone
+	}
+	if err := validate.Struct(&req); err != nil {
+		return createUserValidationErrors(err)
+	}
+	return nil
+}
+
+// GetImport returns an import job with its progress.
+func (ic *UserImportController) GetImport(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid import ID",
+		})
+	}
+
+	job, err := ic.imports.Job(id)
+	if err != nil {
+		return ic.jobError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    job,
+	})
+}
+
+// ConfirmImport starts creating the valid rows of a previewed import. The
+// job runs in the background; poll GetImport to follow it.
+func (ic *UserImportController) ConfirmImport(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid import ID",
+		})
+	}
+
+	job, err := ic.imports.Confirm(activityActor(c), id)
+	if err != nil {
+		return ic.jobError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"message": "Import started",
+		"data":    job,
+	})
+}
+
+// DownloadImportReport returns the rows of an import and their outcome as CSV.
+func (ic *UserImportController) DownloadImportReport(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid import ID",
+		})
+	}
+	if _, err := ic.imports.Job(id); err != nil {
+		return ic.jobError(c, err)
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	header.Set(echo.HeaderContentDisposition, `attachment; filename="user_import_`+strconv.Itoa(id)+`_report.csv"`)
+	if err := ic.imports.WriteReport(id, c.Response()); err != nil {
+		if c.Response().Committed {
+			log.Printf("User import %d report aborted: %v", id, err)
+			return nil
+		}
+		header.Del(echo.HeaderContentDisposition)
+		return ic.jobError(c, err)
+	}
+	return nil
+}
+
+func (ic *UserImportController) jobError(c echo.Context, err error) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Import not found",
+		})
+	case errors.Is(err, services.ErrImportNotPending), errors.Is(err, services.ErrImportNothingValid):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrImportRolesNotHeld):
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	log.Printf("User import error: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to process import",
+	})
+}
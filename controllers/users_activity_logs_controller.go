@@ -16,20 +16,22 @@ type UsersActivityLogsController struct {
 }
 
 type UsersActivityLog struct {
-	LogsID         int              `json:"logs_id"`
-	UserID         *int             `json:"user_id"`
-	Username       *string          `json:"username"`
-	SessionID      *int             `json:"session_id"`
-	Action         string           `json:"action"`
-	TargetType     *string          `json:"target_type"`
-	TargetID       *int             `json:"target_id"`
-	MenuName       *string          `json:"menu_name"`
-	Description    *string          `json:"description"`
-	IPAddress      *string          `json:"ip_address"`
-	UserAgent      *string          `json:"user_agent"`
-	RequestData    *json.RawMessage `json:"request_data"` // tetap ini
-	ResponseStatus *int             `json:"response_status"`
-	CreatedAt      time.Time        `json:"created_at"`
+	LogsID               int              `json:"logs_id"`
+	UserID               *int             `json:"user_id"`
+	Username             *string          `json:"username"`
+	SessionID            *int             `json:"session_id"`
+	ImpersonatorID       *int             `json:"impersonator_id"` // real actor while impersonating user_id
+	ImpersonatorUsername *string          `json:"impersonator_username"`
+	Action               string           `json:"action"`
+	TargetType           *string          `json:"target_type"`
+	TargetID             *int             `json:"target_id"`
+	MenuName             *string          `json:"menu_name"`
+	Description          *string          `json:"description"`
+	IPAddress            *string          `json:"ip_address"`
+	UserAgent            *string          `json:"user_agent"`
+	RequestData          *json.RawMessage `json:"request_data"` // tetap ini
+	ResponseStatus       *int             `json:"response_status"`
+	CreatedAt            time.Time        `json:"created_at"`
 }
 
 func NewUsersActivityLogsController(db *sql.DB) *UsersActivityLogsController {
@@ -63,12 +65,14 @@ func (c *UsersActivityLogsController) GetAllUsersActivityLogs(ctx echo.Context)
 		`
 
 		query = `
-			SELECT ual.logs_id, ual.user_id, ua.username, ual.session_id, ual.action, 
+			SELECT ual.logs_id, ual.user_id, ua.username, ual.session_id,
+				   ual.impersonator_id, imp.username, ual.action, 
 				   ual.target_type, ual.target_id, ual.menu_name, ual.description,
 				   COALESCE(ual.ip_address::text, '') as ip_address, 
 				   ual.user_agent, ual.request_data, ual.response_status, ual.created_at
 			FROM users_activity_logs ual
 			LEFT JOIN users_application ua ON ual.user_id = ua.user_apps_id
+			LEFT JOIN users_application imp ON ual.impersonator_id = imp.user_apps_id
 			WHERE ua.username ILIKE $1 OR ual.action ILIKE $1 OR ual.menu_name ILIKE $1
 			ORDER BY ual.created_at DESC
 			LIMIT $2 OFFSET $3
@@ -82,12 +86,14 @@ func (c *UsersActivityLogsController) GetAllUsersActivityLogs(ctx echo.Context)
 		`
 
 		query = `
-			SELECT ual.logs_id, ual.user_id, ua.username, ual.session_id, ual.action, 
+			SELECT ual.logs_id, ual.user_id, ua.username, ual.session_id,
+				   ual.impersonator_id, imp.username, ual.action, 
 				   ual.target_type, ual.target_id, ual.menu_name, ual.description,
 				   COALESCE(ual.ip_address::text, '') as ip_address, 
 				   ual.user_agent, ual.request_data, ual.response_status, ual.created_at
 			FROM users_activity_logs ual
 			LEFT JOIN users_application ua ON ual.user_id = ua.user_apps_id
+			LEFT JOIN users_application imp ON ual.impersonator_id = imp.user_apps_id
 			ORDER BY ual.created_at DESC
 			LIMIT $1 OFFSET $2
 		`
@@ -127,6 +133,8 @@ func (c *UsersActivityLogsController) GetAllUsersActivityLogs(ctx echo.Context)
 			&log.UserID,
 			&log.Username,
 			&log.SessionID,
+			&log.ImpersonatorID,
+			&log.ImpersonatorUsername,
 			&log.Action,
 			&log.TargetType,
 			&log.TargetID,
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"v01_system_backend/config"
 	"v01_system_backend/routes"
@@ -38,6 +40,13 @@ func main() {
 	// Set custom validator
 	e.Validator = &CustomValidator{validator: validator.New()}
 
+	// Client IP used by rate limits, sessions and activity logs
+	ipExtractor, err := clientIPExtractor(config.AppConfig.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	e.IPExtractor = ipExtractor
+
 	// Middleware
 	// e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -46,14 +55,30 @@ func main() {
 	// Setup routes with DB
 	routes.SetupRoutes(e, config.DB)
 
-	// Optionally: basic ping test
-	e.GET("/ping", func(c echo.Context) error {
-		return c.JSON(200, map[string]string{
-			"message": "pong",
-		})
-	})
-
 	// Start server
 	log.Printf("Server starting on port %s", config.AppConfig.ServerPort)
 	e.Logger.Fatal(e.Start(":" + config.AppConfig.ServerPort))
 }
+
+// clientIPExtractor trusts X-Forwarded-For only when the request comes from
+// one of the trusted proxy ranges. Without proxies the connection address is
+// used and forwarding headers are ignored, so clients cannot choose their IP.
+func clientIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range trustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
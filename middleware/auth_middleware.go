@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"v01_system_backend/services"
@@ -8,18 +9,34 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// APIKeyHeader carries the API key of a service account.
+const APIKeyHeader = "X-API-Key"
+
+// Values of the auth_method context key.
+const (
+	AuthMethodSession = "session"
+	AuthMethodAPIKey  = "api_key"
+)
+
 type AuthMiddleware struct {
-	authService *services.AuthService
+	authService   *services.AuthService
+	apiKeyService *services.APIKeyService
 }
 
-func NewAuthMiddleware(authService *services.AuthService) *AuthMiddleware {
+func NewAuthMiddleware(authService *services.AuthService, apiKeyService *services.APIKeyService) *AuthMiddleware {
 	return &AuthMiddleware{
-		authService: authService,
+		authService:   authService,
+		apiKeyService: apiKeyService,
 	}
 }
 
 func (am *AuthMiddleware) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		// Machine clients authenticate with an API key instead of a session
+		if apiKey := c.Request().Header.Get(APIKeyHeader); apiKey != "" {
+			return am.authenticateAPIKey(c, apiKey, next)
+		}
+
 		// Get token from Authorization header
 		authHeader := c.Request().Header.Get("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
@@ -55,7 +72,38 @@ func (am *AuthMiddleware) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 		// Add user info to context
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("session_id", claims.SessionID)
+		c.Set("token_scope", claims.Scope)
+		c.Set("impersonator_id", claims.ImpersonatorID)
+		c.Set("auth_method", AuthMethodSession)
 
 		return next(c)
 	}
 }
+
+// authenticateAPIKey puts the key's service account in the context. There is
+// no session, so session_id is 0; api_key_scopes narrows the permission checks.
+func (am *AuthMiddleware) authenticateAPIKey(c echo.Context, apiKey string, next echo.HandlerFunc) error {
+	principal, err := am.apiKeyService.Authenticate(apiKey, c.RealIP())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAPIKey) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid API key",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "API key validation error",
+		})
+	}
+
+	c.Set("user_id", principal.UserID)
+	c.Set("username", principal.Username)
+	c.Set("session_id", 0)
+	c.Set("token_scope", "")
+	c.Set("impersonator_id", 0)
+	c.Set("auth_method", AuthMethodAPIKey)
+	c.Set("api_key_id", principal.KeyID)
+	c.Set("api_key_scopes", principal.Scopes)
+
+	return next(c)
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PermissionMiddleware guards routes with role based checks. It must run after
+// AuthMiddleware.RequireAuth, which puts the caller's user_id in the context.
+type PermissionMiddleware struct {
+	authorizationService *services.AuthorizationService
+}
+
+func NewPermissionMiddleware(authorizationService *services.AuthorizationService) *PermissionMiddleware {
+	return &PermissionMiddleware{
+		authorizationService: authorizationService,
+	}
+}
+
+// RequirePermission allows the request only if one of the caller's roles
+// grants permissionCode through role_permissions.
+func (pm *PermissionMiddleware) RequirePermission(permissionCode string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(int)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Authentication required",
+				})
+			}
+
+			if !apiKeyScopeAllows(c, permissionCode) {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":      "Forbidden",
+					"reason":     "missing_scope",
+					"permission": permissionCode,
+					"message":    "This API key is not scoped for " + permissionCode,
+				})
+			}
+
+			allowed, err := pm.authorizationService.HasPermission(userID, permissionCode)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": "Permission check failed",
+				})
+			}
+
+			if !allowed {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":      "Forbidden",
+					"reason":     "missing_permission",
+					"permission": permissionCode,
+					"message":    "You do not have the " + permissionCode + " permission",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// RequireMenuAction allows the request only if one of the caller's roles has
+// the action flag (can_view, can_create, can_modify, can_delete, can_upload,
+// can_download) set for menuCode in role_menus. It is used together with
+// RequirePermission, which also checks the scopes of API keys.
+func (pm *PermissionMiddleware) RequireMenuAction(menuCode, action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(int)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Authentication required",
+				})
+			}
+
+			allowed, err := pm.authorizationService.HasMenuAction(userID, menuCode, action)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": "Permission check failed",
+				})
+			}
+
+			if !allowed {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":   "Forbidden",
+					"reason":  "missing_menu_action",
+					"menu":    menuCode,
+					"action":  action,
+					"message": "Your roles do not allow " + action + " on menu " + menuCode,
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// apiKeyScopeAllows reports whether the caller's API key, if any, is scoped
+// for the given permission. Sessions and keys without scopes are limited by
+// their roles only.
+func apiKeyScopeAllows(c echo.Context, scope string) bool {
+	scopes, _ := c.Get("api_key_scopes").([]string)
+	if len(scopes) == 0 {
+		return true
+	}
+	scope = strings.ToLower(scope)
+	for _, allowed := range scopes {
+		if allowed == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"v01_system_backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+func newTestPermissionMiddleware(t *testing.T) (*PermissionMiddleware, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewPermissionMiddleware(services.NewAuthorizationService(db)), mock
+}
+
+// serve runs middleware in front of a handler answering 204 for user 7.
+func serve(t *testing.T, middleware echo.MiddlewareFunc, scopes []string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodDelete, "/api/v1/users/3", nil), rec)
+	c.Set("user_id", 7)
+	if scopes != nil {
+		c.Set("api_key_scopes", scopes)
+	}
+
+	handler := middleware(func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+	if err := handler(c); err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+	var body map[string]interface{}
+	if rec.Code != http.StatusNoContent {
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("response %q is not JSON: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, body
+}
+
+func TestRequireMenuActionRefusesMissingFlag(t *testing.T) {
+	pm, mock := newTestPermissionMiddleware(t)
+	mock.ExpectQuery(`rm.can_delete = true`).WithArgs(7, "USERS").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(false))
+
+	rec, body := serve(t, pm.RequireMenuAction("USERS", "can_delete"), nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	want := map[string]interface{}{
+		"error":   "Forbidden",
+		"reason":  "missing_menu_action",
+		"menu":    "USERS",
+		"action":  "can_delete",
+		"message": "Your roles do not allow can_delete on menu USERS",
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireMenuActionAllowsFlag(t *testing.T) {
+	pm, mock := newTestPermissionMiddleware(t)
+	mock.ExpectQuery(`rm.can_download = true`).WithArgs(7, "USERS").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(true))
+
+	if rec, _ := serve(t, pm.RequireMenuAction("USERS", "can_download"), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestRequireMenuActionRejectsUnknownAction(t *testing.T) {
+	pm, _ := newTestPermissionMiddleware(t)
+
+	if rec, _ := serve(t, pm.RequireMenuAction("USERS", "can_fly"), nil); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRequirePermissionRefusals(t *testing.T) {
+	pm, mock := newTestPermissionMiddleware(t)
+	mock.ExpectQuery(`p.permission_code = \$2`).WithArgs(7, "users.delete").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(false))
+
+	rec, body := serve(t, pm.RequirePermission("users.delete"), nil)
+	if rec.Code != http.StatusForbidden || body["reason"] != "missing_permission" || body["permission"] != "users.delete" {
+		t.Errorf("without the permission: status %d, body %v", rec.Code, body)
+	}
+
+	// An API key not scoped for the permission is refused before the roles
+	rec, body = serve(t, pm.RequirePermission("users.delete"), []string{"users.view"})
+	if rec.Code != http.StatusForbidden || body["reason"] != "missing_scope" || body["permission"] != "users.delete" {
+		t.Errorf("API key without the scope: status %d, body %v", rec.Code, body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
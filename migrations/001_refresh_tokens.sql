@@ -0,0 +1,25 @@
+-- Refresh token rotation for user_sessions.
+--
+-- Every login creates one user_sessions row. The row keeps the current access
+-- token in session_token (checked by security.validate_session) and expires_at
+-- marks the end of the refresh window. Each refresh token issued for the
+-- session is stored hashed in user_refresh_tokens; a token that has already
+-- been rotated must never be presented again.
+
+ALTER TABLE user_sessions
+    ALTER COLUMN session_token TYPE TEXT;
+
+CREATE TABLE IF NOT EXISTS user_refresh_tokens (
+    refresh_token_id BIGSERIAL PRIMARY KEY,
+    session_id       INTEGER     NOT NULL REFERENCES user_sessions (session_id) ON DELETE CASCADE,
+    user_id          INTEGER     NOT NULL REFERENCES users_application (user_apps_id),
+    token_hash       VARCHAR(64) NOT NULL UNIQUE,
+    parent_id        BIGINT      REFERENCES user_refresh_tokens (refresh_token_id),
+    expires_at       TIMESTAMP   NOT NULL,
+    rotated_at       TIMESTAMP,
+    revoked_at       TIMESTAMP,
+    created_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_refresh_tokens_session_id
+    ON user_refresh_tokens (session_id);
@@ -0,0 +1,61 @@
+-- Permission codes required by the routes in routes/*.go.
+--
+-- Codes follow <module>.<action>. The ADMIN role is granted every code so that
+-- administrators keep access once authorization is enforced; other roles,
+-- system roles included, are granted codes explicitly.
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT v.code, v.name, v.name, v.module, true, 'system', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('activity_logs.view', 'View Activity Logs', 'activity_logs'),
+    ('departments.create', 'Create Departments', 'departments'),
+    ('departments.delete', 'Delete Departments', 'departments'),
+    ('departments.update', 'Update Departments', 'departments'),
+    ('departments.view', 'View Departments', 'departments'),
+    ('email_templates.create', 'Create Email Templates', 'email_templates'),
+    ('email_templates.delete', 'Delete Email Templates', 'email_templates'),
+    ('email_templates.update', 'Update Email Templates', 'email_templates'),
+    ('email_templates.view', 'View Email Templates', 'email_templates'),
+    ('menus.view', 'View Menus', 'menus'),
+    ('notifications.view', 'View Notifications', 'notifications'),
+    ('password_history.view', 'View Password History', 'password_history'),
+    ('password_reset_tokens.view', 'View Password Reset Tokens', 'password_reset_tokens'),
+    ('permissions.create', 'Create Permissions', 'permissions'),
+    ('permissions.delete', 'Delete Permissions', 'permissions'),
+    ('permissions.update', 'Update Permissions', 'permissions'),
+    ('permissions.view', 'View Permissions', 'permissions'),
+    ('role_menus.create', 'Create Role Menus', 'role_menus'),
+    ('role_menus.delete', 'Delete Role Menus', 'role_menus'),
+    ('role_menus.update', 'Update Role Menus', 'role_menus'),
+    ('role_menus.view', 'View Role Menus', 'role_menus'),
+    ('role_permissions.view', 'View Role Permissions', 'role_permissions'),
+    ('roles.create', 'Create Roles', 'roles'),
+    ('roles.delete', 'Delete Roles', 'roles'),
+    ('roles.update', 'Update Roles', 'roles'),
+    ('roles.view', 'View Roles', 'roles'),
+    ('statuses.create', 'Create Statuses', 'statuses'),
+    ('statuses.delete', 'Delete Statuses', 'statuses'),
+    ('statuses.update', 'Update Statuses', 'statuses'),
+    ('statuses.view', 'View Statuses', 'statuses'),
+    ('system_settings.create', 'Create System Settings', 'system_settings'),
+    ('system_settings.delete', 'Delete System Settings', 'system_settings'),
+    ('system_settings.update', 'Update System Settings', 'system_settings'),
+    ('system_settings.view', 'View System Settings', 'system_settings'),
+    ('user_roles.view', 'View User Roles', 'user_roles'),
+    ('user_sessions.view', 'View User Sessions', 'user_sessions'),
+    ('users.create', 'Create Users', 'users'),
+    ('users.delete', 'Delete Users', 'users'),
+    ('users.update', 'Update Users', 'users'),
+    ('users.view', 'View Users', 'users')
+) AS v (code, name, module)
+WHERE NOT EXISTS (SELECT 1 FROM permissions p WHERE p.permission_code = v.code);
+
+INSERT INTO role_permissions (role_id, permission_id, granted_at, is_active)
+SELECT r.roles_id, p.permissions_id, CURRENT_TIMESTAMP, true
+FROM users_roles r
+JOIN permissions p ON p.permission_code LIKE '%.%'
+WHERE r.roles_code = 'ADMIN'
+  AND NOT EXISTS (
+      SELECT 1 FROM role_permissions rp
+      WHERE rp.role_id = r.roles_id AND rp.permission_id = p.permissions_id
+  );
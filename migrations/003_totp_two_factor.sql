@@ -0,0 +1,32 @@
+-- TOTP two-factor authentication.
+--
+-- totp_secret holds the base32 secret (pending until totp_enabled is set by
+-- the confirm step). totp_last_used_step prevents a code from being replayed.
+-- Recovery codes are single use and stored as SHA-256 hashes.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS totp_secret         VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS totp_enabled        BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_confirmed_at   TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS totp_last_used_step BIGINT;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    recovery_code_id BIGSERIAL PRIMARY KEY,
+    user_id          INTEGER     NOT NULL REFERENCES users_application (user_apps_id) ON DELETE CASCADE,
+    code_hash        VARCHAR(64) NOT NULL,
+    used_at          TIMESTAMP,
+    created_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id
+    ON user_recovery_codes (user_id);
+
+-- Sessions opened for users who must enrol first carry a restricted scope
+ALTER TABLE user_sessions
+    ADD COLUMN IF NOT EXISTS token_scope VARCHAR(32);
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT 'security.mfa_required_roles', '', 'string',
+       'Comma separated role codes whose members must use two-factor authentication',
+       false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM system_settings WHERE setting_key = 'security.mfa_required_roles');
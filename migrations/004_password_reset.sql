@@ -0,0 +1,26 @@
+-- Self-service password reset.
+--
+-- password_reset_tokens.token now stores the SHA-256 hash of the token sent by
+-- email; the plain token is never persisted.
+
+ALTER TABLE password_reset_tokens
+    ALTER COLUMN token TYPE VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_token
+    ON password_reset_tokens (token);
+
+INSERT INTO email_templates (template_code, template_name, subject, body_html, body_text, variables, is_active, created_at, updated_at)
+SELECT 'PASSWORD_RESET',
+       'Password Reset',
+       'Reset your password',
+       '<p>Hello {{first_name}},</p>'
+           || '<p>We received a request to reset the password for <strong>{{username}}</strong>.</p>'
+           || '<p><a href="{{reset_link}}">Reset your password</a></p>'
+           || '<p>The link expires in {{expires_in_minutes}} minutes. If you did not request this, you can ignore this email.</p>',
+       'Hello {{first_name}},' || E'\n\n'
+           || 'We received a request to reset the password for {{username}}.' || E'\n'
+           || 'Open this link to choose a new password: {{reset_link}}' || E'\n\n'
+           || 'The link expires in {{expires_in_minutes}} minutes. If you did not request this, you can ignore this email.',
+       '["first_name", "username", "reset_link", "reset_token", "expires_in_minutes"]',
+       true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM email_templates WHERE template_code = 'PASSWORD_RESET');
@@ -0,0 +1,13 @@
+-- Password history reuse prevention.
+--
+-- password.history_depth is the number of previous passwords (besides the
+-- current one) that may not be reused. 0 only blocks the current password.
+
+CREATE INDEX IF NOT EXISTS idx_user_password_history_user_created
+    ON user_password_history (user_id, created_at DESC);
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT 'password.history_depth', '5', 'integer',
+       'Number of previous passwords that cannot be reused',
+       false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM system_settings WHERE setting_key = 'password.history_depth');
@@ -0,0 +1,19 @@
+-- Password policy settings read by the password policy service.
+--
+-- password.max_age_days = 0 disables expiry. The banned password list itself
+-- is a local file (BANNED_PASSWORDS_FILE); password.check_banned_list toggles it.
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, true, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('password.min_length',        '8',     'integer', 'Minimum password length'),
+    ('password.max_length',        '100',   'integer', 'Maximum password length'),
+    ('password.require_uppercase', 'true',  'boolean', 'Passwords must contain an uppercase letter'),
+    ('password.require_lowercase', 'true',  'boolean', 'Passwords must contain a lowercase letter'),
+    ('password.require_digit',     'true',  'boolean', 'Passwords must contain a digit'),
+    ('password.require_symbol',    'false', 'boolean', 'Passwords must contain a symbol'),
+    ('password.disallow_user_info', 'true', 'boolean', 'Passwords must not contain the username, name or email'),
+    ('password.max_age_days',      '0',     'integer', 'Days before a password expires (0 = never)'),
+    ('password.check_banned_list', 'true',  'boolean', 'Reject passwords found in the banned password list')
+) AS v(setting_key, setting_value, setting_type, description)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
@@ -0,0 +1,18 @@
+-- Account lockout handled by the Go lockout service.
+--
+-- lockout_count is the number of consecutive automatic locks; each lock lasts
+-- security.lockout_backoff_multiplier times longer than the previous one.
+-- Administrator locks without a duration set locked_until to 9999-12-31.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS lockout_count INTEGER NOT NULL DEFAULT 0;
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('security.lockout_max_attempts',         '5',    'integer', 'Failed logins before the account is locked'),
+    ('security.lockout_duration_minutes',     '15',   'integer', 'Duration of the first automatic lock'),
+    ('security.lockout_backoff_multiplier',   '2',    'number',  'Factor applied to each consecutive lock duration'),
+    ('security.lockout_max_duration_minutes', '1440', 'integer', 'Upper bound for automatic lock duration')
+) AS v(setting_key, setting_value, setting_type, description)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
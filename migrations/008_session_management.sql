@@ -0,0 +1,13 @@
+-- Self-service session management.
+--
+-- Sessions are validated in Go against user_sessions (session_token,
+-- is_active, expires_at); last_seen_at is refreshed at most once a minute.
+
+ALTER TABLE user_sessions
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_session_token
+    ON user_sessions (session_token);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_active
+    ON user_sessions (user_id) WHERE is_active = true;
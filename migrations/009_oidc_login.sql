@@ -0,0 +1,31 @@
+-- OpenID Connect sign-in.
+--
+-- oidc_login_states holds the state (hashed), nonce and PKCE verifier between
+-- the redirect to the provider and the callback; rows are single use.
+-- user_identities links an external subject to a local account. Accounts are
+-- linked automatically on first sign-in when the provider reports a verified
+-- email that matches users_application.email.
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash    VARCHAR(64)  PRIMARY KEY,
+    provider      VARCHAR(50)  NOT NULL,
+    nonce         VARCHAR(100) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    remember_me   BOOLEAN      NOT NULL DEFAULT false,
+    expires_at    TIMESTAMP    NOT NULL,
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    identity_id   SERIAL PRIMARY KEY,
+    user_id       INTEGER      NOT NULL REFERENCES users_application (user_apps_id) ON DELETE CASCADE,
+    provider      VARCHAR(50)  NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    email         VARCHAR(255),
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id
+    ON user_identities (user_id);
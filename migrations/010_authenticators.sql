@@ -0,0 +1,12 @@
+-- Pluggable password authenticators.
+--
+-- auth_provider names the authenticator that checks the account's password:
+-- 'local' for users_application.password_hash, otherwise the name of an LDAP
+-- provider from LDAP_PROVIDERS. Accounts created on first directory login get
+-- the provider name and a random password hash.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS auth_provider VARCHAR(50) NOT NULL DEFAULT 'local';
+
+CREATE INDEX IF NOT EXISTS idx_users_application_auth_provider
+    ON users_application (auth_provider, username);
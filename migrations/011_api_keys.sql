@@ -0,0 +1,56 @@
+-- Service accounts and API keys for machine-to-machine access.
+--
+-- A service account is a users_application row with is_service_account set:
+-- it cannot sign in with a password or an identity provider and authenticates
+-- only with API keys sent in the X-API-Key header. Its roles decide what it
+-- may do, exactly as for people; a key's scopes can narrow that further to a
+-- list of permission codes.
+--
+-- Only the SHA-256 of a key is stored. key_prefix keeps the first characters
+-- in clear so administrators can tell keys apart.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS is_service_account BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    api_key_id   SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users_application (user_apps_id) ON DELETE CASCADE,
+    name         VARCHAR(100) NOT NULL,
+    key_prefix   VARCHAR(20) NOT NULL,
+    key_hash     VARCHAR(64) NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    expires_at   TIMESTAMP,
+    last_used_at TIMESTAMP,
+    last_used_ip INET,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by   VARCHAR(50),
+    revoked_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id, created_at DESC);
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT v.code, v.name, v.name, v.module, true, 'system', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('api_keys.view', 'View API Keys', 'api_keys'),
+    ('api_keys.create', 'Create API Keys', 'api_keys'),
+    ('api_keys.update', 'Update API Keys', 'api_keys'),
+    ('api_keys.delete', 'Revoke API Keys', 'api_keys')
+) AS v (code, name, module)
+WHERE NOT EXISTS (SELECT 1 FROM permissions p WHERE p.permission_code = v.code);
+
+INSERT INTO role_permissions (role_id, permission_id, granted_at, is_active)
+SELECT r.roles_id, p.permissions_id, CURRENT_TIMESTAMP, true
+FROM users_roles r
+JOIN permissions p ON p.module_name = 'api_keys'
+WHERE r.roles_code = 'ADMIN'
+  AND NOT EXISTS (
+      SELECT 1 FROM role_permissions rp
+      WHERE rp.role_id = r.roles_id AND rp.permission_id = p.permissions_id
+  );
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT 'security.api_key_max_lifetime_days', '365', 'integer',
+       'Maximum and default lifetime of API keys in days (0 = keys may not expire)', false, true,
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM system_settings WHERE setting_key = 'security.api_key_max_lifetime_days');
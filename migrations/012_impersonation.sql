@@ -0,0 +1,47 @@
+-- Admin impersonation ("log in as").
+--
+-- An impersonation session belongs to the impersonated user and records the
+-- administrator in impersonator_id; the same id is carried in the access
+-- token. Activity written during the session stores the real actor in
+-- users_activity_logs.impersonator_id. The trigger fills it in from the
+-- session for rows written by database procedures that do not know about
+-- impersonation.
+--
+-- users.impersonate is not granted to any role by this migration; assign it
+-- explicitly to support roles.
+
+ALTER TABLE user_sessions
+    ADD COLUMN IF NOT EXISTS impersonator_id INTEGER REFERENCES users_application (user_apps_id);
+
+ALTER TABLE users_activity_logs
+    ADD COLUMN IF NOT EXISTS impersonator_id INTEGER REFERENCES users_application (user_apps_id);
+
+CREATE INDEX IF NOT EXISTS idx_users_activity_logs_impersonator
+    ON users_activity_logs (impersonator_id, created_at DESC)
+    WHERE impersonator_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION security.fill_activity_impersonator() RETURNS trigger AS $$
+BEGIN
+    IF NEW.impersonator_id IS NULL AND NEW.session_id IS NOT NULL THEN
+        SELECT impersonator_id INTO NEW.impersonator_id
+        FROM user_sessions WHERE session_id = NEW.session_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_users_activity_logs_impersonator ON users_activity_logs;
+CREATE TRIGGER trg_users_activity_logs_impersonator
+    BEFORE INSERT ON users_activity_logs
+    FOR EACH ROW EXECUTE FUNCTION security.fill_activity_impersonator();
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT 'users.impersonate', 'Impersonate Users', 'Sign in as another user for support', 'users', true, 'system',
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permission_code = 'users.impersonate');
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT 'security.impersonation_duration_minutes', '30', 'integer',
+       'Lifetime of an impersonation session in minutes', false, true,
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM system_settings WHERE setting_key = 'security.impersonation_duration_minutes');
@@ -0,0 +1,24 @@
+-- Login rate limiting.
+--
+-- Attempts on /auth/login are limited per client IP, per username and per
+-- IP and username pair within a sliding window. A limit of 0 disables that
+-- dimension. rate_limit_hits backs the limiter when RATE_LIMIT_STORE=postgres;
+-- with the default in-memory store it stays empty.
+
+CREATE TABLE IF NOT EXISTS rate_limit_hits (
+    rate_key VARCHAR(255) NOT NULL,
+    hit_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_hits_key ON rate_limit_hits (rate_key, hit_at DESC);
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('security.login_rate_limit_enabled',         'true', 'boolean', 'Throttle login attempts'),
+    ('security.login_rate_limit_per_ip',          '20',   'integer', 'Login attempts allowed per client IP within the window'),
+    ('security.login_rate_limit_per_username',    '10',   'integer', 'Login attempts allowed per username within the window'),
+    ('security.login_rate_limit_per_ip_username', '5',    'integer', 'Login attempts allowed per IP and username within the window'),
+    ('security.login_rate_limit_window_seconds',  '300',  'integer', 'Length of the login rate limit window in seconds')
+) AS v(setting_key, setting_value, setting_type, description)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
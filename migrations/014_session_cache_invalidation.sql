@@ -0,0 +1,51 @@
+-- Cross-instance invalidation of the in-process session cache.
+--
+-- Every API instance LISTENs on session_invalidation and drops cached
+-- validations when a session is revoked, logged out, refreshed or removed
+-- ('session:<session_id>') or when a user is deactivated, renamed or removed
+-- ('user:<user_id>'). Triggers send the notifications, so changes made by
+-- stored procedures or by hand are covered as well. NOTIFY is delivered on
+-- commit.
+
+CREATE OR REPLACE FUNCTION security.notify_session_invalidation() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('session_invalidation', 'session:' || OLD.session_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_user_sessions_invalidate ON user_sessions;
+CREATE TRIGGER trg_user_sessions_invalidate
+    AFTER UPDATE OF is_active, session_token, expires_at OR DELETE ON user_sessions
+    FOR EACH ROW EXECUTE FUNCTION security.notify_session_invalidation();
+
+CREATE OR REPLACE FUNCTION security.notify_user_invalidation() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE'
+       OR NEW.is_active IS DISTINCT FROM OLD.is_active
+       OR NEW.username IS DISTINCT FROM OLD.username THEN
+        PERFORM pg_notify('session_invalidation', 'user:' || OLD.user_apps_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_users_application_invalidate ON users_application;
+CREATE TRIGGER trg_users_application_invalidate
+    AFTER UPDATE OF is_active, username OR DELETE ON users_application
+    FOR EACH ROW EXECUTE FUNCTION security.notify_user_invalidation();
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT 'metrics.view', 'View Metrics', 'View runtime metrics such as session cache counters', 'metrics', true, 'system',
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permission_code = 'metrics.view');
+
+INSERT INTO role_permissions (role_id, permission_id, granted_at, is_active)
+SELECT r.roles_id, p.permissions_id, CURRENT_TIMESTAMP, true
+FROM users_roles r
+JOIN permissions p ON p.permission_code = 'metrics.view'
+WHERE r.roles_code = 'ADMIN'
+  AND NOT EXISTS (
+      SELECT 1 FROM role_permissions rp
+      WHERE rp.role_id = r.roles_id AND rp.permission_id = p.permissions_id
+  );
@@ -0,0 +1,19 @@
+-- Password hashing algorithm.
+--
+-- New password hashes are written with password.hash_algorithm ('bcrypt' or
+-- 'argon2id') and the matching cost settings. Stored hashes in another format,
+-- including bcrypt hashes created by pgcrypto and older pgcrypto MD5/DES
+-- hashes, still verify and are replaced with the configured format on the
+-- user's next successful sign-in, so no password reset is needed when the
+-- settings change.
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('password.hash_algorithm',     'bcrypt', 'string',  'Algorithm for new password hashes: bcrypt or argon2id'),
+    ('password.bcrypt_cost',        '10',     'integer', 'bcrypt cost factor for new password hashes'),
+    ('password.argon2_memory_kib',  '65536',  'integer', 'argon2id memory in KiB for new password hashes'),
+    ('password.argon2_iterations',  '3',      'integer', 'argon2id iterations for new password hashes'),
+    ('password.argon2_parallelism', '2',      'integer', 'argon2id parallelism for new password hashes')
+) AS v(setting_key, setting_value, setting_type, description)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
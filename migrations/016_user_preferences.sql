@@ -0,0 +1,10 @@
+-- Per-user client preferences.
+--
+-- The document is opaque to the server: the SPA stores UI settings such as
+-- theme, language or table layouts here and reads them back from /auth/me.
+
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id     INTEGER     PRIMARY KEY REFERENCES users_application (user_apps_id) ON DELETE CASCADE,
+    preferences JSONB       NOT NULL DEFAULT '{}'::jsonb,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,13 @@
+-- Self-service profile editing and avatar upload.
+--
+-- Avatars are stored through the configured file storage as a 256px image and
+-- a 64px thumbnail. avatar_storage_key is the common key prefix of both files
+-- so they can be removed when the avatar is replaced.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS avatar_thumbnail_url VARCHAR(500),
+    ADD COLUMN IF NOT EXISTS avatar_storage_key   VARCHAR(255);
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT 'profile.avatar_max_size_kb', '2048', 'integer', 'Largest accepted avatar upload in KiB', true, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM system_settings WHERE setting_key = 'profile.avatar_max_size_kb');
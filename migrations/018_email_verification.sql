@@ -0,0 +1,71 @@
+-- Email verification and confirmed email changes.
+--
+-- email_verified_at is set once the user opens a verification link. A change
+-- of address is held in pending_email until the link sent to the new address
+-- is opened. Tokens are stored as SHA-256 hashes, like password reset tokens.
+--
+-- Existing accounts are treated as verified so that turning on
+-- security.require_verified_email does not lock out the current user base.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS pending_email     VARCHAR(100);
+
+UPDATE users_application
+SET email_verified_at = COALESCE(created_at, CURRENT_TIMESTAMP)
+WHERE email_verified_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users_application (user_apps_id) ON DELETE CASCADE,
+    email      VARCHAR(100) NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_token
+    ON email_verification_tokens (token);
+
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user
+    ON email_verification_tokens (user_id)
+    WHERE used_at IS NULL;
+
+INSERT INTO email_templates (template_code, template_name, subject, body_html, body_text, variables, is_active, created_at, updated_at)
+SELECT 'EMAIL_VERIFICATION',
+       'Email Verification',
+       'Verify your email address',
+       '<p>Hello {{first_name}},</p>'
+           || '<p>Please confirm that <strong>{{email}}</strong> is the email address of <strong>{{username}}</strong>.</p>'
+           || '<p><a href="{{verification_link}}">Verify your email address</a></p>'
+           || '<p>The link expires in {{expires_in_hours}} hours. If you did not expect this, you can ignore this email.</p>',
+       'Hello {{first_name}},' || E'\n\n'
+           || 'Please confirm that {{email}} is the email address of {{username}}.' || E'\n'
+           || 'Open this link to verify it: {{verification_link}}' || E'\n\n'
+           || 'The link expires in {{expires_in_hours}} hours. If you did not expect this, you can ignore this email.',
+       '["first_name", "username", "email", "verification_link", "expires_in_hours"]',
+       true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM email_templates WHERE template_code = 'EMAIL_VERIFICATION');
+
+INSERT INTO email_templates (template_code, template_name, subject, body_html, body_text, variables, is_active, created_at, updated_at)
+SELECT 'EMAIL_CHANGE_REQUESTED',
+       'Email Change Requested',
+       'Your email address is being changed',
+       '<p>Hello {{first_name}},</p>'
+           || '<p>A change of the email address of <strong>{{username}}</strong> to <strong>{{new_email}}</strong> was requested.</p>'
+           || '<p>This address stays in use until the new one is confirmed. If you did not request this, change your password and contact an administrator.</p>',
+       'Hello {{first_name}},' || E'\n\n'
+           || 'A change of the email address of {{username}} to {{new_email}} was requested.' || E'\n\n'
+           || 'This address stays in use until the new one is confirmed. If you did not request this, change your password and contact an administrator.',
+       '["first_name", "username", "new_email"]',
+       true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM email_templates WHERE template_code = 'EMAIL_CHANGE_REQUESTED');
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('security.require_verified_email',       'false', 'boolean', 'Users must verify their email address before using the application'),
+    ('security.email_verification_ttl_hours', '48',    'integer', 'Hours an email verification link stays valid')
+) AS v(setting_key, setting_value, setting_type, description)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
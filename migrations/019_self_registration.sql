@@ -0,0 +1,72 @@
+-- Self-registration with administrator approval.
+--
+-- POST /auth/register creates an inactive account in the status named by
+-- registration.pending_status_code and notifies every user holding
+-- users.approve. Approving moves the account to
+-- registration.approved_status_code, activates it and grants the comma
+-- separated role codes in registration.default_roles; rejecting moves it to
+-- registration.rejected_status_code. Registration is off until
+-- registration.enabled is set to true.
+
+INSERT INTO users_application_status (status_code, status_name, description, is_active, created_by)
+SELECT v.code, v.name, v.description, true, 'system'
+FROM (VALUES
+    ('PENDING',  'Pending Approval', 'Self-registered account waiting for approval'),
+    ('ACTIVE',   'Active',           'Active account'),
+    ('REJECTED', 'Rejected',         'Self-registration was rejected')
+) AS v (code, name, description)
+WHERE NOT EXISTS (SELECT 1 FROM users_application_status s WHERE s.status_code = v.code);
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT 'users.approve', 'Approve Registrations', 'Approve or reject self-registered accounts', 'users', true, 'system',
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permission_code = 'users.approve');
+
+INSERT INTO role_permissions (role_id, permission_id, granted_at, is_active)
+SELECT r.roles_id, p.permissions_id, CURRENT_TIMESTAMP, true
+FROM users_roles r
+JOIN permissions p ON p.permission_code = 'users.approve'
+WHERE r.roles_code = 'ADMIN'
+  AND NOT EXISTS (
+      SELECT 1 FROM role_permissions rp
+      WHERE rp.role_id = r.roles_id AND rp.permission_id = p.permissions_id
+  );
+
+INSERT INTO email_templates (template_code, template_name, subject, body_html, body_text, variables, is_active, created_at, updated_at)
+SELECT 'REGISTRATION_APPROVED',
+       'Registration Approved',
+       'Your account has been approved',
+       '<p>Hello {{first_name}},</p>'
+           || '<p>Your account request for <strong>{{username}}</strong> has been approved.</p>'
+           || '<p><a href="{{login_link}}">Sign in</a></p>',
+       'Hello {{first_name}},' || E'\n\n'
+           || 'Your account request for {{username}} has been approved.' || E'\n'
+           || 'Sign in here: {{login_link}}',
+       '["first_name", "username", "login_link"]',
+       true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM email_templates WHERE template_code = 'REGISTRATION_APPROVED');
+
+INSERT INTO email_templates (template_code, template_name, subject, body_html, body_text, variables, is_active, created_at, updated_at)
+SELECT 'REGISTRATION_REJECTED',
+       'Registration Rejected',
+       'Your account request was not approved',
+       '<p>Hello {{first_name}},</p>'
+           || '<p>Your account request for <strong>{{username}}</strong> was not approved.</p>'
+           || '<p>{{reason}}</p>',
+       'Hello {{first_name}},' || E'\n\n'
+           || 'Your account request for {{username}} was not approved.' || E'\n\n'
+           || '{{reason}}',
+       '["first_name", "username", "reason"]',
+       true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM email_templates WHERE template_code = 'REGISTRATION_REJECTED');
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, v.is_public, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('registration.enabled',              'false',    'boolean', 'Allow people to request an account through POST /auth/register', true),
+    ('registration.pending_status_code',  'PENDING',  'string',  'Status code of self-registered accounts waiting for approval',    false),
+    ('registration.approved_status_code', 'ACTIVE',   'string',  'Status code of approved self-registered accounts',                false),
+    ('registration.rejected_status_code', 'REJECTED', 'string',  'Status code of rejected self-registered accounts',                false),
+    ('registration.default_roles',        '',         'string',  'Comma separated role codes granted when a registration is approved', false)
+) AS v(setting_key, setting_value, setting_type, description, is_public)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
@@ -0,0 +1,26 @@
+-- Password expiry and forced password change.
+--
+-- A local account whose password is older than password.max_age_days, or
+-- whose must_change_password flag is set, signs in with a session limited to
+-- changing the password. Setting a new password clears the flag. Owners are
+-- notified password.expiry_warning_days before their password expires.
+--
+-- Accounts without password_changed_at start their first period now instead
+-- of being treated as expired once max_age_days is set.
+
+ALTER TABLE users_application
+    ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users_application
+SET password_changed_at = CURRENT_TIMESTAMP
+WHERE password_changed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_type
+    ON notifications (user_id, notification_type, created_at DESC);
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('password.expiry_warning_days', '7', 'integer', 'Days before expiry that users are warned about their password')
+) AS v(setting_key, setting_value, setting_type, description)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
@@ -0,0 +1,27 @@
+-- User export to CSV, Excel and PDF.
+--
+-- GET /users/export/csv|excel|pdf require users.export. PDF exports are
+-- rendered in memory and refuse more than export.pdf_max_rows users; CSV and
+-- Excel exports are streamed and not limited.
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT 'users.export', 'Export Users', 'Export the user list to CSV, Excel or PDF', 'users', true, 'system',
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permission_code = 'users.export');
+
+INSERT INTO role_permissions (role_id, permission_id, granted_at, is_active)
+SELECT r.roles_id, p.permissions_id, CURRENT_TIMESTAMP, true
+FROM users_roles r
+JOIN permissions p ON p.permission_code = 'users.export'
+WHERE r.roles_code = 'ADMIN'
+  AND NOT EXISTS (
+      SELECT 1 FROM role_permissions rp
+      WHERE rp.role_id = r.roles_id AND rp.permission_id = p.permissions_id
+  );
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, v.is_public, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('export.pdf_max_rows', '2000', 'integer', 'Largest number of users a PDF export may contain', false)
+) AS v(setting_key, setting_value, setting_type, description, is_public)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
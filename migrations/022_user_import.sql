@@ -0,0 +1,81 @@
+-- Bulk user import from CSV or Excel files.
+--
+-- POST /users/import validates a file and stores its rows in user_import_rows
+-- under a PREVIEW job. Confirming the job creates the VALID rows in the
+-- background, marking each CREATED or FAILED, and mails every new user a
+-- USER_INVITATION. Invitations are password reset tokens that live for
+-- USER_INVITATION_TTL, so the link opens the reset password page.
+--
+-- Rows without a status code get import.default_status_code; a file may hold
+-- at most import.max_rows users.
+
+CREATE TABLE IF NOT EXISTS user_import_jobs (
+    id               SERIAL PRIMARY KEY,
+    file_name        VARCHAR(255) NOT NULL,
+    status           VARCHAR(20) NOT NULL,
+    total_rows       INTEGER NOT NULL DEFAULT 0,
+    valid_rows       INTEGER NOT NULL DEFAULT 0,
+    invalid_rows     INTEGER NOT NULL DEFAULT 0,
+    created_rows     INTEGER NOT NULL DEFAULT 0,
+    failed_rows      INTEGER NOT NULL DEFAULT 0,
+    send_invitations BOOLEAN NOT NULL DEFAULT true,
+    error            TEXT,
+    created_by       VARCHAR(50) NOT NULL,
+    created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    confirmed_by     VARCHAR(50),
+    confirmed_at     TIMESTAMP,
+    finished_at      TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_import_rows (
+    id         SERIAL PRIMARY KEY,
+    job_id     INTEGER NOT NULL REFERENCES user_import_jobs (id) ON DELETE CASCADE,
+    row_number INTEGER NOT NULL,
+    status     VARCHAR(20) NOT NULL,
+    data       JSONB NOT NULL,
+    errors     JSONB,
+    user_id    INTEGER REFERENCES users_application (user_apps_id) ON DELETE SET NULL,
+    message    TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_import_rows_job
+    ON user_import_rows (job_id, row_number);
+
+INSERT INTO permissions (permission_code, permission_name, description, module_name, is_active, created_by, created_at, updated_at)
+SELECT 'users.import', 'Import Users', 'Create users in bulk from CSV or Excel files', 'users', true, 'system',
+       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permission_code = 'users.import');
+
+INSERT INTO role_permissions (role_id, permission_id, granted_at, is_active)
+SELECT r.roles_id, p.permissions_id, CURRENT_TIMESTAMP, true
+FROM users_roles r
+JOIN permissions p ON p.permission_code = 'users.import'
+WHERE r.roles_code = 'ADMIN'
+  AND NOT EXISTS (
+      SELECT 1 FROM role_permissions rp
+      WHERE rp.role_id = r.roles_id AND rp.permission_id = p.permissions_id
+  );
+
+INSERT INTO email_templates (template_code, template_name, subject, body_html, body_text, variables, is_active, created_at, updated_at)
+SELECT 'USER_INVITATION',
+       'User Invitation',
+       'Your account is ready',
+       '<p>Hello {{first_name}},</p>'
+           || '<p>An account with the username <strong>{{username}}</strong> has been created for you.</p>'
+           || '<p><a href="{{reset_link}}">Choose your password</a></p>'
+           || '<p>The link expires in {{expires_in_hours}} hours. After that, use "Forgot password" on the sign-in page.</p>',
+       'Hello {{first_name}},' || E'\n\n'
+           || 'An account with the username {{username}} has been created for you.' || E'\n'
+           || 'Open this link to choose your password: {{reset_link}}' || E'\n\n'
+           || 'The link expires in {{expires_in_hours}} hours. After that, use "Forgot password" on the sign-in page.',
+       '["first_name", "username", "reset_link", "reset_token", "expires_in_hours"]',
+       true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM email_templates WHERE template_code = 'USER_INVITATION');
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT v.setting_key, v.setting_value, v.setting_type, v.description, v.is_public, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM (VALUES
+    ('import.max_rows',            '1000',   'integer', 'Largest number of users a single import file may contain', false),
+    ('import.default_status_code', 'ACTIVE', 'string',  'Status code of imported users whose row has none',          false)
+) AS v(setting_key, setting_value, setting_type, description, is_public)
+WHERE NOT EXISTS (SELECT 1 FROM system_settings s WHERE s.setting_key = v.setting_key);
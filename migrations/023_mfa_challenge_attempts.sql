@@ -0,0 +1,25 @@
+-- Attempt limit for two-factor login challenges.
+--
+-- Every challenge token handed out by /auth/login is recorded under its jti.
+-- Each code presented with it is an attempt, right or wrong; the challenge is
+-- refused once it has been used or after security.mfa_challenge_max_attempts
+-- attempts, and the user has to sign in with the password again. Wrong codes
+-- also count towards the account lockout.
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    challenge_id VARCHAR(64) PRIMARY KEY,
+    user_id      INTEGER     NOT NULL REFERENCES users_application (user_apps_id) ON DELETE CASCADE,
+    attempts     INTEGER     NOT NULL DEFAULT 0,
+    expires_at   TIMESTAMP   NOT NULL,
+    consumed_at  TIMESTAMP,
+    created_at   TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user_id
+    ON mfa_challenges (user_id);
+
+INSERT INTO system_settings (setting_key, setting_value, setting_type, description, is_public, is_active, created_at, updated_at)
+SELECT 'security.mfa_challenge_max_attempts', '5', 'integer',
+       'Codes that may be tried with one two-factor login challenge',
+       false, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM system_settings WHERE setting_key = 'security.mfa_challenge_max_attempts');
@@ -0,0 +1,342 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"v01_system_backend/middleware"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type accessLevel int
+
+const (
+	// accessAuthenticated routes require a valid bearer token and session.
+	accessAuthenticated accessLevel = iota
+	// accessPublic routes are reachable by anonymous callers.
+	accessPublic
+	// accessSession routes require an interactive session: they manage the
+	// caller's own credentials or sessions, or issue API keys, and are refused
+	// to API keys.
+	accessSession
+)
+
+// routeAccess classifies every route the server exposes, keyed by
+// "METHOD path". Authentication is applied to all /api/v1 routes by default;
+// only the entries marked accessPublic skip it, and API keys are refused on
+// the ones marked accessSession. SetupRoutes refuses to start
+// when a registered route is missing here, so new routes must be classified
+// explicitly.
+var routeAccess = map[string]accessLevel{
+	// Public
+	"GET /ping":                                accessPublic,
+	"GET /.well-known/jwks.json":               accessPublic,
+	"GET /uploads/*":                           accessPublic,
+	"GET /api/v1/health":                       accessPublic,
+	"POST /api/v1/auth/login":                  accessPublic,
+	"POST /api/v1/auth/refresh":                accessPublic,
+	"POST /api/v1/auth/2fa/verify":             accessPublic,
+	"POST /api/v1/auth/forgot-password":        accessPublic,
+	"POST /api/v1/auth/reset-password":         accessPublic,
+	"POST /api/v1/auth/verify-email":           accessPublic,
+	"POST /api/v1/auth/register":               accessPublic,
+	"GET /api/v1/auth/password-policy":         accessPublic,
+	"GET /api/v1/auth/oidc/providers":          accessPublic,
+	"GET /api/v1/auth/oidc/:provider/login":    accessPublic,
+	"GET /api/v1/auth/oidc/:provider/callback": accessPublic,
+	"GET /api/v1/systems-settings/public":      accessPublic,
+
+	// Auth
+	"POST /api/v1/auth/logout":                 accessSession,
+	"GET /api/v1/auth/me":                      accessAuthenticated,
+	"PUT /api/v1/auth/me/preferences":          accessAuthenticated,
+	"GET /api/v1/auth/sessions":                accessSession,
+	"DELETE /api/v1/auth/sessions/:id":         accessSession,
+	"POST /api/v1/auth/sessions/revoke-others": accessSession,
+	"POST /api/v1/auth/impersonate/:user_id":   accessSession,
+	"POST /api/v1/auth/verify-email/resend":    accessSession,
+
+	// Two-factor authentication
+	"POST /api/v1/auth/2fa/enroll":         accessSession,
+	"POST /api/v1/auth/2fa/confirm":        accessSession,
+	"POST /api/v1/auth/2fa/disable":        accessSession,
+	"POST /api/v1/auth/2fa/recovery-codes": accessSession,
+
+	// Users
+	"POST /api/v1/users":                        accessAuthenticated,
+	"GET /api/v1/users":                         accessAuthenticated,
+	"GET /api/v1/users/:id":                     accessAuthenticated,
+	"PUT /api/v1/users/:id":                     accessAuthenticated,
+	"DELETE /api/v1/users/:id":                  accessAuthenticated,
+	"PUT /api/v1/users/bulk-update":             accessAuthenticated,
+	"DELETE /api/v1/users/bulk-delete":          accessAuthenticated,
+	"GET /api/v1/users/export/csv":              accessAuthenticated,
+	"GET /api/v1/users/export/excel":            accessAuthenticated,
+	"GET /api/v1/users/export/pdf":              accessAuthenticated,
+	"POST /api/v1/users/import":                 accessAuthenticated,
+	"GET /api/v1/users/import/:id":              accessAuthenticated,
+	"POST /api/v1/users/import/:id/confirm":     accessAuthenticated,
+	"GET /api/v1/users/import/:id/report":       accessAuthenticated,
+	"GET /api/v1/users/status/:status_id":       accessAuthenticated,
+	"GET /api/v1/users/search":                  accessAuthenticated,
+	"POST /api/v1/users/:id/reset-password":     accessAuthenticated,
+	"PUT /api/v1/users/me/password":             accessSession,
+	"PATCH /api/v1/users/me":                    accessAuthenticated,
+	"POST /api/v1/users/me/avatar":              accessAuthenticated,
+	"POST /api/v1/users/me/email":               accessSession,
+	"PUT /api/v1/users/:id/change-password":     accessAuthenticated,
+	"POST /api/v1/users/:id/lock":               accessAuthenticated,
+	"POST /api/v1/users/:id/unlock":             accessAuthenticated,
+	"POST /api/v1/users/:id/approve":            accessAuthenticated,
+	"POST /api/v1/users/:id/reject":             accessAuthenticated,
+	"GET /api/v1/users/:id/api-keys":            accessSession,
+	"POST /api/v1/users/:id/api-keys":           accessSession,
+	"PUT /api/v1/users/:id/api-keys/:key_id":    accessSession,
+	"DELETE /api/v1/users/:id/api-keys/:key_id": accessSession,
+	"GET /api/v1/users-roles":                   accessAuthenticated,
+	"GET /api/v1/users-sessions":                accessAuthenticated,
+	"GET /api/v1/users-activity-logs":           accessAuthenticated,
+	"GET /api/v1/users-password-history":        accessAuthenticated,
+	"GET /api/v1/password-reset-tokens":         accessAuthenticated,
+	"GET /api/v1/notifications":                 accessAuthenticated,
+	"GET /api/v1/roles-permissions":             accessAuthenticated,
+
+	// Departments
+	"POST /api/v1/departments":          accessAuthenticated,
+	"GET /api/v1/departments":           accessAuthenticated,
+	"GET /api/v1/departments/hierarchy": accessAuthenticated,
+	"GET /api/v1/departments/search":    accessAuthenticated,
+	"GET /api/v1/departments/:id":       accessAuthenticated,
+	"PUT /api/v1/departments/:id":       accessAuthenticated,
+	"DELETE /api/v1/departments/:id":    accessAuthenticated,
+	"GET /api/v1/departments/:id/users": accessAuthenticated,
+
+	// Statuses
+	"POST /api/v1/statuses":       accessAuthenticated,
+	"GET /api/v1/statuses":        accessAuthenticated,
+	"GET /api/v1/statuses/:id":    accessAuthenticated,
+	"PUT /api/v1/statuses/:id":    accessAuthenticated,
+	"DELETE /api/v1/statuses/:id": accessAuthenticated,
+	"GET /api/v1/statuses/search": accessAuthenticated,
+
+	// Roles
+	"POST /api/v1/roles":           accessAuthenticated,
+	"GET /api/v1/roles":            accessAuthenticated,
+	"GET /api/v1/roles/:id":        accessAuthenticated,
+	"PUT /api/v1/roles/:id":        accessAuthenticated,
+	"DELETE /api/v1/roles/:id":     accessAuthenticated,
+	"GET /api/v1/roles/options":    accessAuthenticated,
+	"GET /api/v1/roles/check-code": accessAuthenticated,
+
+	// Permissions
+	"POST /api/v1/permissions":           accessAuthenticated,
+	"GET /api/v1/permissions":            accessAuthenticated,
+	"GET /api/v1/permissions/:id":        accessAuthenticated,
+	"PUT /api/v1/permissions/:id":        accessAuthenticated,
+	"DELETE /api/v1/permissions/:id":     accessAuthenticated,
+	"GET /api/v1/permissions/options":    accessAuthenticated,
+	"GET /api/v1/permissions/modules":    accessAuthenticated,
+	"GET /api/v1/permissions/check-code": accessAuthenticated,
+	"GET /api/v1/permissions/search":     accessAuthenticated,
+
+	// Email templates
+	"GET /api/v1/email-templates":        accessAuthenticated,
+	"GET /api/v1/email-templates/:id":    accessAuthenticated,
+	"POST /api/v1/email-templates":       accessAuthenticated,
+	"PUT /api/v1/email-templates/:id":    accessAuthenticated,
+	"DELETE /api/v1/email-templates/:id": accessAuthenticated,
+
+	// Menus
+	"GET /api/v1/menus/user":                   accessAuthenticated,
+	"GET /api/v1/menus/user/root":              accessAuthenticated,
+	"GET /api/v1/menus/:id/breadcrumb":         accessAuthenticated,
+	"GET /api/v1/menus/:parent_id/children":    accessAuthenticated,
+	"GET /api/v1/menus/:parent_id/descendants": accessAuthenticated,
+
+	// Role menus
+	"GET /api/v1/roles-menus":                   accessAuthenticated,
+	"GET /api/v1/roles-menus/:id":               accessAuthenticated,
+	"POST /api/v1/roles-menus":                  accessAuthenticated,
+	"PUT /api/v1/roles-menus/:id":               accessAuthenticated,
+	"DELETE /api/v1/roles-menus/:id":            accessAuthenticated,
+	"POST /api/v1/roles-menus/bulk-update":      accessAuthenticated,
+	"POST /api/v1/roles-menus/copy-permissions": accessAuthenticated,
+	"GET /api/v1/roles-menus/users-roles":       accessAuthenticated,
+	"GET /api/v1/roles-menus/menus":             accessAuthenticated,
+
+	// Metrics
+	"GET /api/v1/metrics/session-cache": accessAuthenticated,
+
+	// System settings
+	"GET /api/v1/systems-settings":        accessAuthenticated,
+	"GET /api/v1/systems-settings/:id":    accessAuthenticated,
+	"POST /api/v1/systems-settings":       accessAuthenticated,
+	"PUT /api/v1/systems-settings/:id":    accessAuthenticated,
+	"DELETE /api/v1/systems-settings/:id": accessAuthenticated,
+}
+
+// scopeRoutes lists the only routes a token with a restricted scope may reach.
+// Tokens without a scope are not limited; scopes missing here reach nothing.
+var scopeRoutes = map[string]map[string]bool{
+	services.ScopeMFAEnrollment: {
+		"POST /api/v1/auth/2fa/enroll":  true,
+		"POST /api/v1/auth/2fa/confirm": true,
+		"POST /api/v1/auth/logout":      true,
+		"GET /api/v1/auth/me":           true,
+	},
+	services.ScopePasswordChange: {
+		"PUT /api/v1/users/me/password": true,
+		"POST /api/v1/auth/logout":      true,
+		"GET /api/v1/auth/me":           true,
+	},
+	services.ScopeEmailVerification: {
+		"POST /api/v1/auth/verify-email/resend": true,
+		"POST /api/v1/users/me/email":           true,
+		"POST /api/v1/auth/logout":              true,
+		"GET /api/v1/auth/me":                   true,
+	},
+}
+
+// impersonationBlockedRoutes are refused to impersonation sessions: an
+// administrator signed in as a user must not change that user's credentials,
+// email address, second factor or other sessions, or start another
+// impersonation.
+var impersonationBlockedRoutes = map[string]bool{
+	"POST /api/v1/auth/2fa/enroll":              true,
+	"POST /api/v1/auth/2fa/confirm":             true,
+	"POST /api/v1/auth/2fa/disable":             true,
+	"POST /api/v1/auth/2fa/recovery-codes":      true,
+	"DELETE /api/v1/auth/sessions/:id":          true,
+	"POST /api/v1/auth/sessions/revoke-others":  true,
+	"POST /api/v1/auth/impersonate/:user_id":    true,
+	"PUT /api/v1/users/me/password":             true,
+	"POST /api/v1/users/me/email":               true,
+	"PUT /api/v1/users/:id":                     true,
+	"PUT /api/v1/users/:id/change-password":     true,
+	"POST /api/v1/users/:id/reset-password":     true,
+	"POST /api/v1/users/:id/api-keys":           true,
+	"PUT /api/v1/users/:id/api-keys/:key_id":    true,
+	"DELETE /api/v1/users/:id/api-keys/:key_id": true,
+}
+
+func routeKey(method, path string) string {
+	return method + " " + path
+}
+
+func isPublicRoute(method, path string) bool {
+	level, ok := routeAccess[routeKey(method, path)]
+	return ok && level == accessPublic
+}
+
+func isSessionRoute(method, path string) bool {
+	level, ok := routeAccess[routeKey(method, path)]
+	return ok && level == accessSession
+}
+
+// requireAuthUnlessPublic applies the given authentication middleware to every
+// route except the ones classified as public, then enforces token scopes.
+func requireAuthUnlessPublic(requireAuth echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		protected := requireAuth(requireSession(restrictImpersonation(restrictTokenScope(next))))
+		return func(c echo.Context) error {
+			if isPublicRoute(c.Request().Method, c.Path()) {
+				return next(c)
+			}
+			return protected(c)
+		}
+	}
+}
+
+// restrictTokenScope rejects restricted tokens on routes outside their scope.
+func restrictTokenScope(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		scope, _ := c.Get("token_scope").(string)
+		if scope == "" || scopeRoutes[scope][routeKey(c.Request().Method, c.Path())] {
+			return next(c)
+		}
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error":   "Forbidden",
+			"reason":  "restricted_token",
+			"scope":   scope,
+			"message": "This token cannot access this resource",
+		})
+	}
+}
+
+// requireSession rejects API keys on routes classified as accessSession.
+func requireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		method, _ := c.Get("auth_method").(string)
+		if method != middleware.AuthMethodAPIKey || !isSessionRoute(c.Request().Method, c.Path()) {
+			return next(c)
+		}
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error":   "Forbidden",
+			"reason":  "session_required",
+			"message": "This resource is not available to API keys",
+		})
+	}
+}
+
+// restrictImpersonation rejects impersonation sessions on
+// impersonationBlockedRoutes.
+func restrictImpersonation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		impersonatorID, _ := c.Get("impersonator_id").(int)
+		if impersonatorID == 0 || !impersonationBlockedRoutes[routeKey(c.Request().Method, c.Path())] {
+			return next(c)
+		}
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error":   "Forbidden",
+			"reason":  "impersonation",
+			"message": "This action is not available while impersonating a user",
+		})
+	}
+}
+
+// validateRouteAccess reports routes that are registered but not classified
+// in routeAccess, and classifications that no longer match a route.
+func validateRouteAccess(registered []*echo.Route) error {
+	seen := make(map[string]bool)
+	var unclassified []string
+	for _, route := range registered {
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		key := routeKey(route.Method, route.Path)
+		seen[key] = true
+		if _, ok := routeAccess[key]; !ok {
+			unclassified = append(unclassified, key)
+		}
+	}
+
+	var stale []string
+	for key := range routeAccess {
+		if !seen[key] {
+			stale = append(stale, key)
+		}
+	}
+	for key := range impersonationBlockedRoutes {
+		if !seen[key] {
+			stale = append(stale, key)
+		}
+	}
+
+	if len(unclassified) == 0 && len(stale) == 0 {
+		return nil
+	}
+
+	sort.Strings(unclassified)
+	sort.Strings(stale)
+	var problems []string
+	if len(unclassified) > 0 {
+		problems = append(problems, "unclassified routes: "+strings.Join(unclassified, ", "))
+	}
+	if len(stale) > 0 {
+		problems = append(problems, "classified routes not registered: "+strings.Join(stale, ", "))
+	}
+	return fmt.Errorf("route access table out of date: %s", strings.Join(problems, "; "))
+}
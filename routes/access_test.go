@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"testing"
+	"v01_system_backend/config"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+// TestRouteAccessMatchesRoutes fails when a route is added without a
+// routeAccess entry, or when an entry of routeAccess or
+// impersonationBlockedRoutes no longer matches a route.
+func TestRouteAccessMatchesRoutes(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Background jobs and the session cache listener stay off
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{
+		AppEnv:          "development",
+		RateLimitStore:  "memory",
+		StorageBackend:  "local",
+		StorageLocalDir: t.TempDir(),
+	}
+	defer func() { config.AppConfig = previous }()
+
+	e := echo.New()
+	registerRoutes(e, db)
+
+	if err := validateRouteAccess(e.Routes()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,21 +3,57 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 	"v01_system_backend/services"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupAuthRoutes(api *echo.Group, db *sql.DB) {
+func SetupAuthRoutes(api *echo.Group, db *sql.DB, authService *services.AuthService, authz *middleware.PermissionMiddleware, rateLimiter *services.LoginRateLimiter) {
 	// Initialize services
-	authService := services.NewAuthService(db)
+	mfaService := services.NewMFAService(db, services.NewSettingsService(db))
 
 	// Initialize controllers
-	loginController := controller.NewLoginController(authService)
+	loginController := controller.NewLoginController(authService, rateLimiter, services.NewProfileService(db))
+	twoFactorController := controller.NewTwoFactorController(authService, mfaService, rateLimiter)
+	passwordResetController := controller.NewPasswordResetController(newPasswordResetService(db), rateLimiter)
+	passwordPolicyController := controller.NewPasswordPolicyController(newPasswordPolicyService(db))
+	sessionController := controller.NewSessionController(services.NewSessionService(db))
+	emailVerificationController := controller.NewEmailVerificationController(newEmailVerificationService(db), newPasswordService(db))
+	registrationController := controller.NewRegistrationController(newRegistrationService(db), rateLimiter)
 
 	auth := api.Group("/auth")
 	auth.POST("/login", loginController.Login)
 	auth.POST("/logout", loginController.Logout)
 	auth.GET("/me", loginController.GetCurrentUser)
+	auth.PUT("/me/preferences", loginController.UpdatePreferences)
 	auth.POST("/refresh", loginController.RefreshToken)
+
+	// Support staff sign in as another user
+	auth.POST("/impersonate/:user_id", loginController.Impersonate, authz.RequirePermission(services.PermissionImpersonate))
+
+	// Self-service password reset
+	auth.POST("/forgot-password", passwordResetController.ForgotPassword)
+	auth.POST("/reset-password", passwordResetController.ResetPassword)
+	auth.GET("/password-policy", passwordPolicyController.GetPasswordPolicy)
+
+	// Self-registration, approved through /users/:id/approve
+	auth.POST("/register", registrationController.Register)
+
+	// Email verification
+	auth.POST("/verify-email", emailVerificationController.VerifyEmail)
+	auth.POST("/verify-email/resend", emailVerificationController.ResendVerification)
+
+	// Own sessions
+	auth.GET("/sessions", sessionController.ListSessions)
+	auth.DELETE("/sessions/:id", sessionController.RevokeSession)
+	auth.POST("/sessions/revoke-others", sessionController.RevokeOtherSessions)
+
+	// Two-factor authentication
+	twoFactor := auth.Group("/2fa")
+	twoFactor.POST("/verify", twoFactorController.Verify)
+	twoFactor.POST("/enroll", twoFactorController.Enroll)
+	twoFactor.POST("/confirm", twoFactorController.Confirm)
+	twoFactor.POST("/disable", twoFactorController.Disable)
+	twoFactor.POST("/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
 }
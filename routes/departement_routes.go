@@ -3,6 +3,7 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 
@@ -10,7 +11,7 @@ import (
 	"v01_system_backend/services"
 )
 
-func SetupDepartmentRoutes(api *echo.Group, db *sql.DB) {
+func SetupDepartmentRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	// Initialize layers
 	departmentRepo := repositories.NewDepartmentRepository(db)
 	departmentService := services.NewDepartmentService(departmentRepo)
@@ -18,12 +19,12 @@ func SetupDepartmentRoutes(api *echo.Group, db *sql.DB) {
 
 	// Department CRUD routes
 	departments := api.Group("/departments")
-	departments.POST("", departmentController.CreateDepartment)
-	departments.GET("", departmentController.GetAllDepartments)
-	departments.GET("/hierarchy", departmentController.GetDepartmentHierarchy)
-	departments.GET("/search", departmentController.SearchDepartments)
-	departments.GET("/:id", departmentController.GetDepartment)
-	departments.PUT("/:id", departmentController.UpdateDepartment)
-	departments.DELETE("/:id", departmentController.DeleteDepartment)
-	departments.GET("/:id/users", departmentController.GetUsersByDepartment)
+	departments.POST("", departmentController.CreateDepartment, authz.RequirePermission("departments.create"))
+	departments.GET("", departmentController.GetAllDepartments, authz.RequirePermission("departments.view"))
+	departments.GET("/hierarchy", departmentController.GetDepartmentHierarchy, authz.RequirePermission("departments.view"))
+	departments.GET("/search", departmentController.SearchDepartments, authz.RequirePermission("departments.view"))
+	departments.GET("/:id", departmentController.GetDepartment, authz.RequirePermission("departments.view"))
+	departments.PUT("/:id", departmentController.UpdateDepartment, authz.RequirePermission("departments.update"))
+	departments.DELETE("/:id", departmentController.DeleteDepartment, authz.RequirePermission("departments.delete"))
+	departments.GET("/:id/users", departmentController.GetUsersByDepartment, authz.RequirePermission("departments.view"))
 }
@@ -4,17 +4,18 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupEmailTemplatesRoutes(api *echo.Group, db *sql.DB) {
+func SetupEmailTemplatesRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	emailController := controller.NewEmailTemplatesController(db)
 	emailTemplates := api.Group("/email-templates")
 
-	emailTemplates.GET("", emailController.GetAllEmailTemplates)
-	emailTemplates.GET("/:id", emailController.GetEmailTemplateByID)
-	emailTemplates.POST("", emailController.CreateEmailTemplate)
-	emailTemplates.PUT("/:id", emailController.UpdateEmailTemplate)
-	emailTemplates.DELETE("/:id", emailController.DeleteEmailTemplate)
+	emailTemplates.GET("", emailController.GetAllEmailTemplates, authz.RequirePermission("email_templates.view"))
+	emailTemplates.GET("/:id", emailController.GetEmailTemplateByID, authz.RequirePermission("email_templates.view"))
+	emailTemplates.POST("", emailController.CreateEmailTemplate, authz.RequirePermission("email_templates.create"))
+	emailTemplates.PUT("/:id", emailController.UpdateEmailTemplate, authz.RequirePermission("email_templates.update"))
+	emailTemplates.DELETE("/:id", emailController.DeleteEmailTemplate, authz.RequirePermission("email_templates.delete"))
 }
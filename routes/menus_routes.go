@@ -3,18 +3,19 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupMenusRoutes(api *echo.Group, db *sql.DB) {
+func SetupMenusRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewMenusController(db)
 	routes := api.Group("/menus")
 
 	// User-specific menu routes using procedures
-	routes.GET("/user", Controllers.GetUserMenus)                               // GET /api/menus/user?user_id=1
-	routes.GET("/user/root", Controllers.GetRootMenusForUser)                   // GET /api/menus/user/root?user_id=1
-	routes.GET("/:id/breadcrumb", Controllers.GetMenuBreadcrumb)                // GET /api/menus/1/breadcrumb?user_id=1
-	routes.GET("/:parent_id/children", Controllers.GetChildMenusForUser)        // GET /api/menus/1/children?user_id=1
-	routes.GET("/:parent_id/descendants", Controllers.GetAllDescendantsForUser) // GET /api/menus/1/descendants?user_id=1
+	routes.GET("/user", Controllers.GetUserMenus, authz.RequirePermission("menus.view"))                               // GET /api/menus/user?user_id=1
+	routes.GET("/user/root", Controllers.GetRootMenusForUser, authz.RequirePermission("menus.view"))                   // GET /api/menus/user/root?user_id=1
+	routes.GET("/:id/breadcrumb", Controllers.GetMenuBreadcrumb, authz.RequirePermission("menus.view"))                // GET /api/menus/1/breadcrumb?user_id=1
+	routes.GET("/:parent_id/children", Controllers.GetChildMenusForUser, authz.RequirePermission("menus.view"))        // GET /api/menus/1/children?user_id=1
+	routes.GET("/:parent_id/descendants", Controllers.GetAllDescendantsForUser, authz.RequirePermission("menus.view")) // GET /api/menus/1/descendants?user_id=1
 }
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func SetupMetricsRoutes(api *echo.Group, authService *services.AuthService, authz *middleware.PermissionMiddleware) {
+	metricsController := controller.NewMetricsController(authService)
+
+	metrics := api.Group("/metrics")
+	metrics.GET("/session-cache", metricsController.GetSessionCacheMetrics, authz.RequirePermission("metrics.view"))
+}
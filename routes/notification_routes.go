@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupNotficationRoutes(api *echo.Group, db *sql.DB) {
+func SetupNotficationRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewNotificationsController(db)
 	Routes := api.Group("/notifications")
-	Routes.GET("", Controllers.GetAllNotifications)
+	Routes.GET("", Controllers.GetAllNotifications, authz.RequirePermission("notifications.view"))
 }
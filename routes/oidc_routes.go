@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"database/sql"
+	"v01_system_backend/config"
+	controller "v01_system_backend/controllers"
+	"v01_system_backend/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func SetupOIDCRoutes(api *echo.Group, db *sql.DB, authService *services.AuthService) {
+	providers := make([]services.OIDCProviderOptions, 0, len(config.AppConfig.OIDCProviders))
+	for _, provider := range config.AppConfig.OIDCProviders {
+		providers = append(providers, services.OIDCProviderOptions{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		})
+	}
+
+	oidcService := services.NewOIDCService(db, authService, providers)
+	oidcController := controller.NewOIDCController(oidcService, config.AppConfig.OIDCPostLoginRedirect)
+
+	oidc := api.Group("/auth/oidc")
+	oidc.GET("/providers", oidcController.Providers)
+	oidc.GET("/:provider/login", oidcController.Login)
+	oidc.GET("/:provider/callback", oidcController.Callback)
+}
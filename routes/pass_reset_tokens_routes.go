@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupPasswordResetTokensRoutes(api *echo.Group, db *sql.DB) {
+func SetupPasswordResetTokensRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewPasswordResetTokensController(db)
 	Routes := api.Group("/password-reset-tokens")
-	Routes.GET("", Controllers.GetAllPasswordResetTokens)
+	Routes.GET("", Controllers.GetAllPasswordResetTokens, authz.RequirePermission("password_reset_tokens.view"))
 }
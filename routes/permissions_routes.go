@@ -3,25 +3,26 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupPermissionRoutes(api *echo.Group, db *sql.DB) {
+func SetupPermissionRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	permissionController := controller.NewPermissionController(db)
 
 	permissions := api.Group("/permissions")
 
 	// Permission CRUD routes
-	permissions.POST("", permissionController.CreatePermission)       // Create permission
-	permissions.GET("", permissionController.GetAllPermissions)       // Get all permissions with pagination and filters
-	permissions.GET("/:id", permissionController.GetPermission)       // Get permission by ID
-	permissions.PUT("/:id", permissionController.UpdatePermission)    // Update permission
-	permissions.DELETE("/:id", permissionController.DeletePermission) // Delete permission (soft delete)
+	permissions.POST("", permissionController.CreatePermission, authz.RequirePermission("permissions.create"))       // Create permission
+	permissions.GET("", permissionController.GetAllPermissions, authz.RequirePermission("permissions.view"))         // Get all permissions with pagination and filters
+	permissions.GET("/:id", permissionController.GetPermission, authz.RequirePermission("permissions.view"))         // Get permission by ID
+	permissions.PUT("/:id", permissionController.UpdatePermission, authz.RequirePermission("permissions.update"))    // Update permission
+	permissions.DELETE("/:id", permissionController.DeletePermission, authz.RequirePermission("permissions.delete")) // Delete permission (soft delete)
 
 	// Additional permission routes
-	permissions.GET("/options", permissionController.GetPermissionOptions)     // Get permission options for dropdowns
-	permissions.GET("/modules", permissionController.GetModules)               // Get available modules
-	permissions.GET("/check-code", permissionController.CheckCodeAvailability) // Check if permission code is available
-	permissions.GET("/search", permissionController.SearchPermissions)         // Search permissions
+	permissions.GET("/options", permissionController.GetPermissionOptions, authz.RequirePermission("permissions.view"))     // Get permission options for dropdowns
+	permissions.GET("/modules", permissionController.GetModules, authz.RequirePermission("permissions.view"))               // Get available modules
+	permissions.GET("/check-code", permissionController.CheckCodeAvailability, authz.RequirePermission("permissions.view")) // Check if permission code is available
+	permissions.GET("/search", permissionController.SearchPermissions, authz.RequirePermission("permissions.view"))         // Search permissions
 }
@@ -3,26 +3,27 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupRolesMenusRoutes(api *echo.Group, db *sql.DB) {
+func SetupRolesMenusRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewRoleMenusController(db)
 	Routes := api.Group("/roles-menus")
 
 	// CRUD routes
-	Routes.GET("", Controllers.GetAllRoleMenus)       // GET /api/roles-menus
-	Routes.GET("/:id", Controllers.GetRoleMenuByID)   // GET /api/roles-menus/:id
-	Routes.POST("", Controllers.CreateRoleMenu)       // POST /api/roles-menus
-	Routes.PUT("/:id", Controllers.UpdateRoleMenu)    // PUT /api/roles-menus/:id
-	Routes.DELETE("/:id", Controllers.DeleteRoleMenu) // DELETE /api/roles-menus/:id
+	Routes.GET("", Controllers.GetAllRoleMenus, authz.RequirePermission("role_menus.view"))         // GET /api/roles-menus
+	Routes.GET("/:id", Controllers.GetRoleMenuByID, authz.RequirePermission("role_menus.view"))     // GET /api/roles-menus/:id
+	Routes.POST("", Controllers.CreateRoleMenu, authz.RequirePermission("role_menus.create"))       // POST /api/roles-menus
+	Routes.PUT("/:id", Controllers.UpdateRoleMenu, authz.RequirePermission("role_menus.update"))    // PUT /api/roles-menus/:id
+	Routes.DELETE("/:id", Controllers.DeleteRoleMenu, authz.RequirePermission("role_menus.delete")) // DELETE /api/roles-menus/:id
 
 	// Additional utility routes
-	Routes.POST("/bulk-update", Controllers.BulkUpdatePermissions) // POST /api/roles-menus/bulk-update
-	Routes.POST("/copy-permissions", Controllers.CopyPermissions)  // POST /api/roles-menus/copy-permissions
+	Routes.POST("/bulk-update", Controllers.BulkUpdatePermissions, authz.RequirePermission("role_menus.update")) // POST /api/roles-menus/bulk-update
+	Routes.POST("/copy-permissions", Controllers.CopyPermissions, authz.RequirePermission("role_menus.update"))  // POST /api/roles-menus/copy-permissions
 
 	// Helper routes for dropdowns (can also be separate endpoints)
-	Routes.GET("/users-roles", Controllers.GetAllRoles) // GET /api/users-roles
-	Routes.GET("/menus", Controllers.GetAllMenus)       // GET /api/menus
+	Routes.GET("/users-roles", Controllers.GetAllRoles, authz.RequirePermission("role_menus.view")) // GET /api/users-roles
+	Routes.GET("/menus", Controllers.GetAllMenus, authz.RequirePermission("role_menus.view"))       // GET /api/menus
 }
@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupRolesPermissionsRoutes(api *echo.Group, db *sql.DB) {
+func SetupRolesPermissionsRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewRolePermissionsController(db)
 	Routes := api.Group("/roles-permissions")
-	Routes.GET("", Controllers.GetAllRolePermissions)
+	Routes.GET("", Controllers.GetAllRolePermissions, authz.RequirePermission("role_permissions.view"))
 }
@@ -3,23 +3,24 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupRoleRoutes(api *echo.Group, db *sql.DB) {
+func SetupRoleRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	roleController := controller.NewRoleController(db)
 
 	roles := api.Group("/roles")
 
 	// Role CRUD routes
-	roles.POST("", roleController.CreateRole)       // Create role
-	roles.GET("", roleController.GetAllRoles)       // Get all roles with pagination and filters
-	roles.GET("/:id", roleController.GetRole)       // Get role by ID
-	roles.PUT("/:id", roleController.UpdateRole)    // Update role
-	roles.DELETE("/:id", roleController.DeleteRole) // Delete role (soft delete)
+	roles.POST("", roleController.CreateRole, authz.RequirePermission("roles.create"))       // Create role
+	roles.GET("", roleController.GetAllRoles, authz.RequirePermission("roles.view"))         // Get all roles with pagination and filters
+	roles.GET("/:id", roleController.GetRole, authz.RequirePermission("roles.view"))         // Get role by ID
+	roles.PUT("/:id", roleController.UpdateRole, authz.RequirePermission("roles.update"))    // Update role
+	roles.DELETE("/:id", roleController.DeleteRole, authz.RequirePermission("roles.delete")) // Delete role (soft delete)
 
 	// Additional role routes
-	roles.GET("/options", roleController.GetRoleOptions)           // Get role options
-	roles.GET("/check-code", roleController.CheckCodeAvailability) // Check if role code is available
+	roles.GET("/options", roleController.GetRoleOptions, authz.RequirePermission("roles.view"))           // Get role options
+	roles.GET("/check-code", roleController.CheckCodeAvailability, authz.RequirePermission("roles.view")) // Check if role code is available
 }
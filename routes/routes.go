@@ -1,33 +1,92 @@
 package routes
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"v01_system_backend/config"
+	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
+	"v01_system_backend/services"
 
 	"github.com/labstack/echo/v4"
 )
 
 func SetupRoutes(e *echo.Echo, db *sql.DB) {
+	registerRoutes(e, db)
+
+	if err := validateRouteAccess(e.Routes()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// registerRoutes builds the services and registers every route of the API.
+func registerRoutes(e *echo.Echo, db *sql.DB) {
+	// Shared auth services
+	keys, err := services.LoadKeySet(services.KeySetOptions{
+		SigningKeyFile:       config.AppConfig.JWTSigningKeyFile,
+		VerificationKeyFiles: config.AppConfig.JWTVerificationKeyFiles,
+		AllowEphemeral:       config.AppConfig.IsDevelopment(),
+	})
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	authenticators, err := newAuthenticatorChain(db)
+	if err != nil {
+		log.Fatalf("Failed to configure authenticators: %v", err)
+	}
+	sessionCache := services.NewSessionCache(services.SessionCacheOptions{
+		TTL:        config.AppConfig.SessionCacheTTL,
+		MaxEntries: config.AppConfig.SessionCacheMaxEntries,
+	})
+	sessionCache.Listen(config.DatabaseDSN())
+	authService := services.NewAuthService(db, services.AuthOptions{
+		AccessTokenTTL:            config.AppConfig.AccessTokenTTL,
+		RefreshTokenTTL:           config.AppConfig.RefreshTokenTTL,
+		RememberMeRefreshTokenTTL: config.AppConfig.RememberMeRefreshTokenTTL,
+		Keys:                      keys,
+		Issuer:                    config.AppConfig.JWTIssuer,
+		Audience:                  config.AppConfig.JWTAudience,
+		Authenticators:            authenticators,
+		SessionCache:              sessionCache,
+	})
+	storage, err := newFileStorage()
+	if err != nil {
+		log.Fatalf("Failed to configure file storage: %v", err)
+	}
+	services.NewPasswordExpiryService(db, services.NewSettingsService(db)).RunWarnings(config.AppConfig.PasswordExpiryCheckInterval)
+	rateLimiter := newLoginRateLimiter(db)
+	authMiddleware := middleware.NewAuthMiddleware(authService, newAPIKeyService(db))
+	authz := middleware.NewPermissionMiddleware(services.NewAuthorizationService(db))
+
 	// API version 1
 	api := e.Group("/api/v1")
 
+	// Every route requires authentication unless listed as public in routeAccess
+	api.Use(requireAuthUnlessPublic(authMiddleware.RequireAuth))
+
 	// Setup user routes
-	SetupUserRoutes(api, db)
-	SetupDepartmentRoutes(api, db)
-	SetupStatusRoutes(api, db)
-	SetupRoleRoutes(api, db)
-	SetupPermissionRoutes(api, db)
-	SetupEmailTemplatesRoutes(api, db)
-	SetupMenusRoutes(api, db)
-	SetupNotficationRoutes(api, db)
-	SetupPasswordResetTokensRoutes(api, db)
-	SetupRolesMenusRoutes(api, db)
-	SetupRolesPermissionsRoutes(api, db)
-	SetupSystemsSettingsRoutes(api, db)
-	SetupUsersSessionsRoutes(api, db)
-	SetupUsersActivityLogsRoutes(api, db)
-	SetupUsersPasswordHistoryRoutes(api, db)
-	SetupUsersRolesRoutes(api, db)
-	SetupAuthRoutes(api, db)
+	SetupUserRoutes(api, db, authz, storage, rateLimiter)
+	SetupDepartmentRoutes(api, db, authz)
+	SetupStatusRoutes(api, db, authz)
+	SetupRoleRoutes(api, db, authz)
+	SetupPermissionRoutes(api, db, authz)
+	SetupEmailTemplatesRoutes(api, db, authz)
+	SetupMenusRoutes(api, db, authz)
+	SetupNotficationRoutes(api, db, authz)
+	SetupPasswordResetTokensRoutes(api, db, authz)
+	SetupRolesMenusRoutes(api, db, authz)
+	SetupRolesPermissionsRoutes(api, db, authz)
+	SetupSystemsSettingsRoutes(api, db, authz)
+	SetupUsersSessionsRoutes(api, db, authz)
+	SetupUsersActivityLogsRoutes(api, db, authz)
+	SetupUsersPasswordHistoryRoutes(api, db, authz)
+	SetupUsersRolesRoutes(api, db, authz)
+	SetupAuthRoutes(api, db, authService, authz, rateLimiter)
+	SetupOIDCRoutes(api, db, authService)
+	SetupMetricsRoutes(api, authService, authz)
 
 	// Health check
 	api.GET("/health", func(c echo.Context) error {
@@ -36,4 +95,131 @@ func SetupRoutes(e *echo.Echo, db *sql.DB) {
 			"message": "Server is running",
 		})
 	})
+
+	// Public keys for verifying access tokens
+	e.GET("/.well-known/jwks.json", controller.NewJWKSController(authService).GetJWKS)
+
+	// Uploaded files such as avatars
+	e.GET("/uploads/*", controller.NewFilesController(storage).ServeFile)
+
+	// Basic ping test
+	e.GET("/ping", func(c echo.Context) error {
+		return c.JSON(200, map[string]string{
+			"message": "pong",
+		})
+	})
+}
+
+func newEmailService(db *sql.DB) *services.EmailService {
+	return services.NewEmailService(db, services.SMTPOptions{
+		Host:     config.AppConfig.SMTPHost,
+		Port:     config.AppConfig.SMTPPort,
+		Username: config.AppConfig.SMTPUsername,
+		Password: config.AppConfig.SMTPPassword,
+		From:     config.AppConfig.SMTPFrom,
+	})
+}
+
+func newPasswordResetService(db *sql.DB) *services.PasswordResetService {
+	return services.NewPasswordResetService(db, newEmailService(db), newPasswordService(db), services.PasswordResetOptions{
+		AppBaseURL:    config.AppConfig.AppBaseURL,
+		TokenTTL:      config.AppConfig.PasswordResetTokenTTL,
+		InvitationTTL: config.AppConfig.UserInvitationTTL,
+	})
+}
+
+func newEmailVerificationService(db *sql.DB) *services.EmailVerificationService {
+	return services.NewEmailVerificationService(db, newEmailService(db), services.NewSettingsService(db), services.NewActivityLogService(db), config.AppConfig.AppBaseURL)
+}
+
+func newRegistrationService(db *sql.DB) *services.RegistrationService {
+	return services.NewRegistrationService(db, newPasswordService(db), newEmailVerificationService(db), newEmailService(db),
+		services.NewSettingsService(db), services.NewActivityLogService(db), config.AppConfig.AppBaseURL)
+}
+
+func newPasswordPolicyService(db *sql.DB) *services.PasswordPolicyService {
+	return services.NewPasswordPolicyService(services.NewSettingsService(db), config.AppConfig.BannedPasswordsFile)
+}
+
+func newPasswordService(db *sql.DB) *services.PasswordService {
+	return services.NewPasswordService(db, newPasswordPolicyService(db), newPasswordHasher(db))
+}
+
+func newPasswordHasher(db *sql.DB) *services.PasswordHasher {
+	return services.NewPasswordHasher(db, services.NewSettingsService(db))
+}
+
+func newLockoutService(db *sql.DB) *services.LockoutService {
+	return services.NewLockoutService(db, services.NewSettingsService(db), services.NewActivityLogService(db))
+}
+
+func newLoginRateLimiter(db *sql.DB) *services.LoginRateLimiter {
+	var store services.RateLimitStore
+	switch config.AppConfig.RateLimitStore {
+	case "memory":
+		store = services.NewMemoryRateLimitStore()
+	case "postgres":
+		store = services.NewPostgresRateLimitStore(db)
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q", config.AppConfig.RateLimitStore)
+	}
+	return services.NewLoginRateLimiter(store, services.NewSettingsService(db), services.NewActivityLogService(db))
+}
+
+func newAvatarService(db *sql.DB, storage services.FileStorage) *services.AvatarService {
+	return services.NewAvatarService(db, storage, services.NewSettingsService(db), config.AppConfig.StoragePublicURL)
+}
+
+func newFileStorage() (services.FileStorage, error) {
+	switch config.AppConfig.StorageBackend {
+	case "local":
+		return services.NewLocalStorage(config.AppConfig.StorageLocalDir)
+	case "s3":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return services.NewS3Storage(ctx, services.S3StorageOptions{
+			Endpoint:  config.AppConfig.S3Endpoint,
+			Region:    config.AppConfig.S3Region,
+			Bucket:    config.AppConfig.S3Bucket,
+			AccessKey: config.AppConfig.S3AccessKey,
+			SecretKey: config.AppConfig.S3SecretKey,
+			UseSSL:    config.AppConfig.S3UseSSL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", config.AppConfig.StorageBackend)
+	}
+}
+
+func newAPIKeyService(db *sql.DB) *services.APIKeyService {
+	return services.NewAPIKeyService(db, services.NewSettingsService(db))
+}
+
+// newAuthenticatorChain builds the password authenticators: the local database
+// plus every configured LDAP provider.
+func newAuthenticatorChain(db *sql.DB) (*services.AuthenticatorChain, error) {
+	chain := services.NewAuthenticatorChain(services.NewLocalAuthenticator(db, newPasswordHasher(db)))
+	for _, provider := range config.AppConfig.LDAPProviders {
+		authenticator := services.NewLDAPAuthenticator(services.LDAPOptions{
+			Name:               provider.Name,
+			URL:                provider.URL,
+			StartTLS:           provider.StartTLS,
+			BindDN:             provider.BindDN,
+			BindPassword:       provider.BindPassword,
+			BaseDN:             provider.BaseDN,
+			UserFilter:         provider.UserFilter,
+			EmailAttribute:     provider.EmailAttribute,
+			FirstNameAttribute: provider.FirstNameAttribute,
+			LastNameAttribute:  provider.LastNameAttribute,
+			GroupAttribute:     provider.GroupAttribute,
+			GroupRoles:         provider.GroupRoles,
+			Provisioning: services.ProvisioningOptions{
+				JIT:             provider.JITProvisioning,
+				DefaultStatusID: provider.DefaultStatusID,
+			},
+		})
+		if err := chain.Register(authenticator, provider.Domains); err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
 }
@@ -3,21 +3,22 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupStatusRoutes(api *echo.Group, db *sql.DB) {
+func SetupStatusRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	statusController := controller.NewStatusController(db)
 
 	// Status CRUD routes
 	statuses := api.Group("/statuses")
-	statuses.POST("", statusController.CreateStatus)       // Create status
-	statuses.GET("", statusController.GetAllStatuses)      // Get all statuses with pagination and filters
-	statuses.GET("/:id", statusController.GetStatus)       // Get status by ID
-	statuses.PUT("/:id", statusController.UpdateStatus)    // Update status
-	statuses.DELETE("/:id", statusController.DeleteStatus) // Delete status (soft delete)
+	statuses.POST("", statusController.CreateStatus, authz.RequirePermission("statuses.create"))       // Create status
+	statuses.GET("", statusController.GetAllStatuses, authz.RequirePermission("statuses.view"))        // Get all statuses with pagination and filters
+	statuses.GET("/:id", statusController.GetStatus, authz.RequirePermission("statuses.view"))         // Get status by ID
+	statuses.PUT("/:id", statusController.UpdateStatus, authz.RequirePermission("statuses.update"))    // Update status
+	statuses.DELETE("/:id", statusController.DeleteStatus, authz.RequirePermission("statuses.delete")) // Delete status (soft delete)
 
 	// Additional status routes
-	statuses.GET("/search", statusController.SearchStatuses) // Search statuses
+	statuses.GET("/search", statusController.SearchStatuses, authz.RequirePermission("statuses.view")) // Search statuses
 }
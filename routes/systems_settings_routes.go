@@ -3,20 +3,21 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupSystemsSettingsRoutes(api *echo.Group, db *sql.DB) {
+func SetupSystemsSettingsRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	controller := controller.NewSystemSettingsController(db)
 	routes := api.Group("/systems-settings")
 
 	// CRUD Routes
-	routes.GET("", controller.GetAllSystemSettings)       // GET /api/systems-settings
-	routes.GET("/:id", controller.GetSystemSettingByID)   // GET /api/systems-settings/:id
-	routes.POST("", controller.CreateSystemSetting)       // POST /api/systems-settings
-	routes.PUT("/:id", controller.UpdateSystemSetting)    // PUT /api/systems-settings/:id
-	routes.DELETE("/:id", controller.DeleteSystemSetting) // DELETE /api/systems-settings/:id
+	routes.GET("", controller.GetAllSystemSettings, authz.RequirePermission("system_settings.view"))         // GET /api/systems-settings
+	routes.GET("/:id", controller.GetSystemSettingByID, authz.RequirePermission("system_settings.view"))     // GET /api/systems-settings/:id
+	routes.POST("", controller.CreateSystemSetting, authz.RequirePermission("system_settings.create"))       // POST /api/systems-settings
+	routes.PUT("/:id", controller.UpdateSystemSetting, authz.RequirePermission("system_settings.update"))    // PUT /api/systems-settings/:id
+	routes.DELETE("/:id", controller.DeleteSystemSetting, authz.RequirePermission("system_settings.delete")) // DELETE /api/systems-settings/:id
 
 	// Public settings route (for frontend consumption)
 	routes.GET("/public", controller.GetPublicSettings) // GET /api/systems-settings/public
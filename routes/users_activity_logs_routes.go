@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupUsersActivityLogsRoutes(api *echo.Group, db *sql.DB) {
+func SetupUsersActivityLogsRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewUsersActivityLogsController(db)
 	Routes := api.Group("/users-activity-logs")
-	Routes.GET("", Controllers.GetAllUsersActivityLogs)
+	Routes.GET("", Controllers.GetAllUsersActivityLogs, authz.RequirePermission("activity_logs.view"))
 }
@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupUsersPasswordHistoryRoutes(api *echo.Group, db *sql.DB) {
+func SetupUsersPasswordHistoryRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewUserPasswordHistoryController(db)
 	Routes := api.Group("/users-password-history")
-	Routes.GET("", Controllers.GetAllUserPasswordHistory)
+	Routes.GET("", Controllers.GetAllUserPasswordHistory, authz.RequirePermission("password_history.view"))
 }
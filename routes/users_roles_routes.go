@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupUsersRolesRoutes(api *echo.Group, db *sql.DB) {
+func SetupUsersRolesRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewUserRoleController(db)
 	Routes := api.Group("/users-roles")
-	Routes.GET("", Controllers.GetAllUserRoles)
+	Routes.GET("", Controllers.GetAllUserRoles, authz.RequirePermission("user_roles.view"))
 }
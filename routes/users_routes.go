@@ -2,53 +2,106 @@ package routes
 
 import (
 	"database/sql"
+	"log"
+	"strings"
 	"time"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
+	"v01_system_backend/services"
 
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
 )
 
-func SetupUserRoutes(api *echo.Group, db *sql.DB) {
-	userController := controller.NewUserController(db)
+// menuUsers is the menu whose role_menus flags also guard the user routes.
+const menuUsers = "USERS"
+
+func SetupUserRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware, storage services.FileStorage, rateLimiter *services.LoginRateLimiter) {
+	userController := controller.NewUserController(db, newPasswordService(db), newLockoutService(db), newEmailVerificationService(db), services.NewUserBulkService(db, services.NewActivityLogService(db)))
+	passwordResetController := controller.NewPasswordResetController(newPasswordResetService(db), rateLimiter)
+	apiKeysController := controller.NewAPIKeysController(newAPIKeyService(db))
+	profileController := controller.NewProfileController(services.NewProfileService(db), newAvatarService(db, storage))
+	emailVerificationController := controller.NewEmailVerificationController(newEmailVerificationService(db), newPasswordService(db))
+	registrationController := controller.NewRegistrationController(newRegistrationService(db), rateLimiter)
+	userExportController := controller.NewUserExportController(services.NewUserExportService(db, services.NewSettingsService(db), services.NewActivityLogService(db)))
+	userImportService := services.NewUserImportService(db, newPasswordService(db), newPasswordResetService(db), services.NewSettingsService(db), services.NewActivityLogService(db))
+	userImportController := controller.NewUserImportController(userImportService)
+
+	// Imports that were running when the server stopped will not resume
+	if count, err := userImportService.FailInterruptedJobs(); err != nil {
+		log.Printf("Failed to clean up user imports: %v", err)
+	} else if count > 0 {
+		log.Printf("Marked %d interrupted user imports as failed", count)
+	}
 
 	// Add request logging middleware
-	api.Use(middleware.Logger())
+	api.Use(echomiddleware.Logger())
 
-	// Add request timeout middleware
-	api.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+	// Add request timeout middleware. Exports are skipped because the timeout
+	// handler buffers the whole response, which defeats streaming.
+	api.Use(echomiddleware.TimeoutWithConfig(echomiddleware.TimeoutConfig{
+		Skipper: func(c echo.Context) bool {
+			return strings.HasPrefix(c.Path(), "/api/v1/users/export/")
+		},
 		Timeout: 30 * time.Second,
 	}))
 
 	// User CRUD routes
 	users := api.Group("/users")
-	users.POST("", userController.CreateUser)       // Create user
-	users.GET("", userController.GetAllUsers)       // Get all users with pagination & filtering
-	users.GET("/:id", userController.GetUser)       // Get user by ID
-	users.PUT("/:id", userController.UpdateUser)    // Update user
-	users.DELETE("/:id", userController.DeleteUser) // Delete user (soft delete)
+	users.POST("", userController.CreateUser, authz.RequirePermission("users.create"), authz.RequireMenuAction(menuUsers, "can_create"))       // Create user
+	users.GET("", userController.GetAllUsers, authz.RequirePermission("users.view"), authz.RequireMenuAction(menuUsers, "can_view"))           // Get all users with pagination & filtering
+	users.GET("/:id", userController.GetUser, authz.RequirePermission("users.view"), authz.RequireMenuAction(menuUsers, "can_view"))           // Get user by ID
+	users.PUT("/:id", userController.UpdateUser, authz.RequirePermission("users.update"), authz.RequireMenuAction(menuUsers, "can_modify"))    // Update user
+	users.DELETE("/:id", userController.DeleteUser, authz.RequirePermission("users.delete"), authz.RequireMenuAction(menuUsers, "can_delete")) // Delete user (soft delete)
+
+	// Bulk operations
+	users.PUT("/bulk-update", userController.BulkUpdateUsers, authz.RequirePermission("users.update"), authz.RequireMenuAction(menuUsers, "can_modify"))    // Bulk update users
+	users.DELETE("/bulk-delete", userController.BulkDeleteUsers, authz.RequirePermission("users.delete"), authz.RequireMenuAction(menuUsers, "can_delete")) // Bulk delete users
+
+	// Export routes
+	export := users.Group("/export")
+	export.GET("/csv", userExportController.ExportUsersCSV, authz.RequirePermission("users.export"), authz.RequireMenuAction(menuUsers, "can_download"))     // Export to CSV
+	export.GET("/excel", userExportController.ExportUsersExcel, authz.RequirePermission("users.export"), authz.RequireMenuAction(menuUsers, "can_download")) // Export to Excel
+	export.GET("/pdf", userExportController.ExportUsersPDF, authz.RequirePermission("users.export"), authz.RequireMenuAction(menuUsers, "can_download"))     // Export to PDF
+
+	// Import routes
+	imports := users.Group("/import")
+	imports.POST("", userImportController.ImportUsers, authz.RequirePermission("users.import"), authz.RequireMenuAction(menuUsers, "can_upload"))                    // Upload file for preview
+	imports.GET("/:id", userImportController.GetImport, authz.RequirePermission("users.import"), authz.RequireMenuAction(menuUsers, "can_upload"))                   // Get import progress
+	imports.POST("/:id/confirm", userImportController.ConfirmImport, authz.RequirePermission("users.import"), authz.RequireMenuAction(menuUsers, "can_upload"))      // Confirm and start import
+	imports.GET("/:id/report", userImportController.DownloadImportReport, authz.RequirePermission("users.import"), authz.RequireMenuAction(menuUsers, "can_upload")) // Download result report
 
 	// Additional user routes
-	users.GET("/status/:status_id", userController.GetUsersByStatus) // Get users by status
-	users.GET("/search", userController.SearchUsers)                 // Search users
+	users.GET("/status/:status_id", userController.GetUsersByStatus, authz.RequirePermission("users.view"), authz.RequireMenuAction(menuUsers, "can_view")) // Get users by status
+	users.GET("/search", userController.SearchUsers, authz.RequirePermission("users.view"), authz.RequireMenuAction(menuUsers, "can_view"))                 // Search users
 
-	// // Utility routes
-	// users.GET("/check-username", userController.CheckUsernameAvailability) // Check username availability
-	// users.GET("/check-email", userController.CheckEmailAvailability)       // Check email availability
+	// Own profile
+	users.PATCH("/me", profileController.UpdateOwnProfile)           // Edit own names and phone
+	users.POST("/me/avatar", profileController.UploadAvatar)         // Upload own avatar
+	users.POST("/me/email", emailVerificationController.ChangeEmail) // Change own email after verification
+
+	// Password management
+	users.PUT("/me/password", userController.ChangeOwnPassword)                                                                                                         // Change own password
+	users.PUT("/:id/change-password", userController.ChangePassword, authz.RequirePermission("users.update"), authz.RequireMenuAction(menuUsers, "can_modify"))         // Change user password
+	users.POST("/:id/reset-password", passwordResetController.SendResetLink, authz.RequirePermission("users.update"), authz.RequireMenuAction(menuUsers, "can_modify")) // Send reset link
+
+	// Self-registration approval
+	users.POST("/:id/approve", registrationController.ApproveRegistration, authz.RequirePermission(services.PermissionApproveRegistrations)) // Approve registration
+	users.POST("/:id/reject", registrationController.RejectRegistration, authz.RequirePermission(services.PermissionApproveRegistrations))   // Reject registration
 
-	// // Bulk operations
-	// users.PUT("/bulk-update", userController.BulkUpdateUsers)    // Bulk update users
-	// users.DELETE("/bulk-delete", userController.BulkDeleteUsers) // Bulk delete users
+	// Account lockout
+	users.POST("/:id/lock", userController.LockUser, authz.RequirePermission("users.update"), authz.RequireMenuAction(menuUsers, "can_modify"))     // Lock user account
+	users.POST("/:id/unlock", userController.UnlockUser, authz.RequirePermission("users.update"), authz.RequireMenuAction(menuUsers, "can_modify")) // Unlock user account
 
-	// // Password management
-	// users.PUT("/:id/change-password", userController.ChangePassword) // Change password
-	// users.POST("/:id/reset-password", userController.ResetPassword)  // Reset password
+	// Service account API keys
+	users.GET("/:id/api-keys", apiKeysController.ListAPIKeys, authz.RequirePermission("api_keys.view"))               // List API keys
+	users.POST("/:id/api-keys", apiKeysController.CreateAPIKey, authz.RequirePermission("api_keys.create"))           // Create API key
+	users.PUT("/:id/api-keys/:key_id", apiKeysController.UpdateAPIKey, authz.RequirePermission("api_keys.update"))    // Update API key
+	users.DELETE("/:id/api-keys/:key_id", apiKeysController.RevokeAPIKey, authz.RequirePermission("api_keys.delete")) // Revoke API key
 
-	// // Export routes
-	// export := users.Group("/export")
-	// export.GET("/csv", userController.ExportUsersCSV)     // Export to CSV
-	// export.GET("/excel", userController.ExportUsersExcel) // Export to Excel
-	// export.GET("/pdf", userController.ExportUsersPDF)     // Export to PDF
+	// // Utility routes
+	// users.GET("/check-username", userController.CheckUsernameAvailability) // Check username availability
+	// users.GET("/check-email", userController.CheckEmailAvailability)       // Check email availability
 
 	// // Auth routes
 	// auth := api.Group("/auth")
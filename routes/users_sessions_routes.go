@@ -3,12 +3,13 @@ package routes
 import (
 	"database/sql"
 	controller "v01_system_backend/controllers"
+	"v01_system_backend/middleware"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetupUsersSessionsRoutes(api *echo.Group, db *sql.DB) {
+func SetupUsersSessionsRoutes(api *echo.Group, db *sql.DB, authz *middleware.PermissionMiddleware) {
 	Controllers := controller.NewUserSessionsController(db)
 	Routes := api.Group("/users-sessions")
-	Routes.GET("", Controllers.GetAllUserSessions)
+	Routes.GET("", Controllers.GetAllUserSessions, authz.RequirePermission("user_sessions.view"))
 }
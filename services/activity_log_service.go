@@ -0,0 +1,102 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// ActivityActor identifies who performed an action and from where. Zero
+// values are stored as NULL.
+type ActivityActor struct {
+	UserID    int
+	Username  string
+	SessionID int
+	IPAddress string
+	UserAgent string
+	// ImpersonatorID is the administrator acting as UserID, if any.
+	ImpersonatorID int
+}
+
+// ActivityLogEntry is a single row for users_activity_logs.
+type ActivityLogEntry struct {
+	Actor          ActivityActor
+	Action         string
+	TargetType     string
+	TargetID       int
+	MenuName       string
+	Description    string
+	RequestData    interface{}
+	ResponseStatus int
+}
+
+// ActivityLogService writes audit entries to users_activity_logs.
+type ActivityLogService struct {
+	db *sql.DB
+}
+
+func NewActivityLogService(db *sql.DB) *ActivityLogService {
+	return &ActivityLogService{db: db}
+}
+
+func (s *ActivityLogService) Log(entry ActivityLogEntry) error {
+	return writeActivityLog(s.db, entry)
+}
+
+// LogTx writes entry in tx, so that it is only kept together with the change
+// it audits.
+func (s *ActivityLogService) LogTx(tx *sql.Tx, entry ActivityLogEntry) error {
+	return writeActivityLog(tx, entry)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func writeActivityLog(db execer, entry ActivityLogEntry) error {
+	var requestData []byte
+	if entry.RequestData != nil {
+		data, err := json.Marshal(entry.RequestData)
+		if err != nil {
+			return fmt.Errorf("failed to encode request data: %w", err)
+		}
+		requestData = data
+	}
+
+	query := `
+		INSERT INTO users_activity_logs
+			(user_id, username, session_id, action, target_type, target_id, menu_name,
+			 description, ip_address, user_agent, request_data, response_status,
+			 impersonator_id, created_at)
+		VALUES (NULLIF($1, 0), NULLIF($2, ''), NULLIF($3, 0), $4, NULLIF($5, ''), NULLIF($6, 0),
+			NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, '')::inet, NULLIF($10, ''), $11::jsonb,
+			NULLIF($12, 0), NULLIF($13, 0), CURRENT_TIMESTAMP)
+	`
+
+	_, err := db.Exec(query,
+		entry.Actor.UserID, entry.Actor.Username, entry.Actor.SessionID,
+		entry.Action, entry.TargetType, entry.TargetID, entry.MenuName,
+		entry.Description, entry.Actor.IPAddress, entry.Actor.UserAgent,
+		nullableJSON(requestData), entry.ResponseStatus, entry.Actor.ImpersonatorID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write activity log: %w", err)
+	}
+	return nil
+}
+
+// Record writes entry and only logs a failure. Use it where the audit trail
+// must not change the outcome of the action being audited.
+func (s *ActivityLogService) Record(entry ActivityLogEntry) {
+	if err := s.Log(entry); err != nil {
+		log.Printf("Activity log %s not written: %v", entry.Action, err)
+	}
+}
+
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix starts every key so leaked keys are easy to recognise in logs
+// and by secret scanners.
+const apiKeyPrefix = "spa_"
+
+// apiKeyDisplayLength is how much of a key is stored in clear for listings.
+const apiKeyDisplayLength = 12
+
+// apiKeyTouchInterval is the resolution of api_keys.last_used_at.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrAPIKeyNotFound    = errors.New("API key not found")
+	ErrInvalidAPIKey     = errors.New("invalid, expired or revoked API key")
+	ErrNotServiceAccount = errors.New("API keys can only be issued to service accounts")
+	ErrAPIKeyLifetime    = errors.New("API key lifetime exceeds the allowed maximum")
+	// ErrAPIKeyPermissions is returned when the service account holds a
+	// permission its key's issuer lacks.
+	ErrAPIKeyPermissions = errors.New("API keys can only be issued for service accounts whose permissions you hold")
+)
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0"`
+}
+
+type UpdateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKey describes a key without its secret.
+type APIKey struct {
+	ID         int        `json:"api_key_id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CreatedBy  *string    `json:"created_by"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// CreatedAPIKey is returned once, when the key is created. The secret is not
+// stored and cannot be shown again.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyPrincipal is the caller behind a valid API key.
+type APIKeyPrincipal struct {
+	KeyID    int
+	UserID   int
+	Username string
+	// Scopes limits the key to these permission codes; empty means the key
+	// carries every permission of its service account.
+	Scopes []string
+}
+
+// APIKeyService manages API keys of service accounts. Keys are stored as
+// SHA-256 hashes; only a short prefix is kept in clear for identification.
+type APIKeyService struct {
+	db       *sql.DB
+	settings *SettingsService
+}
+
+func NewAPIKeyService(db *sql.DB, settings *SettingsService) *APIKeyService {
+	return &APIKeyService{db: db, settings: settings}
+}
+
+const apiKeyColumns = `
+	api_key_id, user_id, name, key_prefix, scopes, expires_at,
+	last_used_at, host(last_used_ip), created_at, created_by, revoked_at
+`
+
+func scanAPIKey(scanner interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes pq.StringArray
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := scanner.Scan(&key.ID, &key.UserID, &key.Name, &key.KeyPrefix, &scopes, &expiresAt,
+		&lastUsedAt, &key.LastUsedIP, &key.CreatedAt, &key.CreatedBy, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = []string(scopes)
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+// List returns every key of the user, including revoked ones.
+func (s *APIKeyService) List(userID int) ([]APIKey, error) {
+	rows, err := s.db.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Create issues a key for a service account on behalf of callerID, who must
+// hold every permission of that account. ExpiresInDays defaults to and is
+// capped by the security.api_key_max_lifetime_days setting (0 = unlimited).
+func (s *APIKeyService) Create(callerID, userID int, req CreateAPIKeyRequest, createdBy string) (*CreatedAPIKey, error) {
+	var isServiceAccount bool
+	err := s.db.QueryRow(`SELECT is_service_account FROM users_application WHERE user_apps_id = $1`, userID).Scan(&isServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	if !isServiceAccount {
+		return nil, ErrNotServiceAccount
+	}
+
+	// A key carries the permissions of its service account, so issuing one
+	// for an account allowed more than the caller would escalate the caller
+	covered, err := NewAuthorizationService(s.db).HasPermissionsOf(callerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !covered {
+		return nil, ErrAPIKeyPermissions
+	}
+
+	maxDays := s.settings.GetInt("security.api_key_max_lifetime_days", 365)
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = maxDays
+	}
+	if maxDays > 0 && days > maxDays {
+		return nil, ErrAPIKeyLifetime
+	}
+
+	var expiresAt *time.Time
+	if days > 0 {
+		expiry := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		expiresAt = &expiry
+	}
+
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	plain := apiKeyPrefix + secret
+
+	row := s.db.QueryRow(`
+		INSERT INTO api_keys (user_id, name, key_prefix, key_hash, scopes, expires_at, created_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, NULLIF($7, ''))
+		RETURNING `+apiKeyColumns,
+		userID, strings.TrimSpace(req.Name), plain[:apiKeyDisplayLength], hashToken(plain),
+		pq.Array(normalizeScopes(req.Scopes)), expiresAt, createdBy,
+	)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+	return &CreatedAPIKey{APIKey: *key, Key: plain}, nil
+}
+
+// Update renames a key or changes its scopes. Revoked keys cannot be changed.
+func (s *APIKeyService) Update(userID, keyID int, req UpdateAPIKeyRequest) (*APIKey, error) {
+	row := s.db.QueryRow(`
+		UPDATE api_keys SET name = $1, scopes = $2
+		WHERE api_key_id = $3 AND user_id = $4 AND revoked_at IS NULL
+		RETURNING `+apiKeyColumns,
+		strings.TrimSpace(req.Name), pq.Array(normalizeScopes(req.Scopes)), keyID, userID,
+	)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to update API key: %w", err)
+	}
+	return key, nil
+}
+
+// Revoke disables a key immediately.
+func (s *APIKeyService) Revoke(userID, keyID int) error {
+	result, err := s.db.Exec(`
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE api_key_id = $1 AND user_id = $2 AND revoked_at IS NULL`, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented key to its service account and records
+// its use.
+func (s *APIKeyService) Authenticate(plain, ipAddress string) (*APIKeyPrincipal, error) {
+	if !strings.HasPrefix(plain, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var principal APIKeyPrincipal
+	var scopes pq.StringArray
+	var expiresAt, revokedAt, lockedUntil sql.NullTime
+	var userActive, isServiceAccount bool
+	err := s.db.QueryRow(`
+		SELECT k.api_key_id, k.user_id, u.username, k.scopes, k.expires_at, k.revoked_at,
+		       u.is_active, u.is_service_account, u.locked_until
+		FROM api_keys k
+		JOIN users_application u ON u.user_apps_id = k.user_id
+		WHERE k.key_hash = $1`, hashToken(plain),
+	).Scan(&principal.KeyID, &principal.UserID, &principal.Username, &scopes, &expiresAt, &revokedAt,
+		&userActive, &isServiceAccount, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("failed to validate API key: %w", err)
+	}
+
+	now := time.Now()
+	if revokedAt.Valid || (expiresAt.Valid && !expiresAt.Time.After(now)) ||
+		!userActive || !isServiceAccount || (lockedUntil.Valid && lockedUntil.Time.After(now)) {
+		return nil, ErrInvalidAPIKey
+	}
+	principal.Scopes = []string(scopes)
+
+	s.touch(principal.KeyID, ipAddress)
+	return &principal, nil
+}
+
+// touch records the last use of a key at most once per apiKeyTouchInterval.
+func (s *APIKeyService) touch(keyID int, ipAddress string) {
+	_, err := s.db.Exec(`
+		UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = NULLIF($2, '')::inet
+		WHERE api_key_id = $1
+		  AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - $3 * INTERVAL '1 second'
+		       OR last_used_ip IS DISTINCT FROM NULLIF($2, '')::inet)`,
+		keyID, ipAddress, int(apiKeyTouchInterval.Seconds()))
+	if err != nil {
+		log.Printf("Failed to update last use of API key %d: %v", keyID, err)
+	}
+}
+
+// normalizeScopes trims, lowercases and de-duplicates permission codes.
+func normalizeScopes(scopes []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope != "" && !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized
+}
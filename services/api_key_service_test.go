@@ -0,0 +1,31 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateAPIKeyRequiresThePermissionsOfTheServiceAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	keys := NewAPIKeyService(db, NewSettingsService(db))
+
+	mock.ExpectQuery(`SELECT is_service_account FROM users_application`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"is_service_account"}).AddRow(true))
+	mock.ExpectQuery(`WITH granted AS`).WithArgs(3, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"covered"}).AddRow(false))
+
+	_, err = keys.Create(3, 9, CreateAPIKeyRequest{Name: "ci"}, "alice")
+	if !errors.Is(err, ErrAPIKeyPermissions) {
+		t.Fatalf("Create error = %v, want ErrAPIKeyPermissions", err)
+	}
+	// No key may be stored
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,17 +1,69 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token scopes. An empty scope grants regular access; any other scope limits
+// the token to the routes allowed for it.
+const (
+	// ScopeMFAChallenge marks the short-lived token returned by Login when the
+	// user still has to present a TOTP or recovery code. It has no session.
+	ScopeMFAChallenge = "mfa_challenge"
+	// ScopeMFAEnrollment marks sessions of users whose role requires 2FA but
+	// who have not enrolled yet.
+	ScopeMFAEnrollment = "mfa_enrollment"
+)
+
+const mfaChallengeTTL = 5 * time.Minute
+
+// sessionTouchInterval is the resolution of user_sessions.last_seen_at.
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrInvalidChallenge    = errors.New("invalid or expired two-factor challenge")
+)
+
 type AuthService struct {
-	db *sql.DB
+	db       *sql.DB
+	options  AuthOptions
+	mfa      *MFAService
+	lockout  *LockoutService
+	activity *ActivityLogService
+	settings *SettingsService
+	expiry   *PasswordExpiryService
+	hasher   *PasswordHasher
+}
+
+// AuthOptions controls the lifetime of issued tokens and the keys used to
+// sign them.
+type AuthOptions struct {
+	AccessTokenTTL            time.Duration
+	RefreshTokenTTL           time.Duration
+	RememberMeRefreshTokenTTL time.Duration
+	Keys                      *KeySet
+	// Issuer is the iss of every token. Audience is the aud of full access
+	// tokens; tokens limited to a scope get Audience + ":" + scope, so that
+	// other services checking the audience reject them.
+	Issuer   string
+	Audience string
+	// Authenticators verifies passwords; nil means local accounts only.
+	Authenticators *AuthenticatorChain
+	// SessionCache caches session validations; nil disables caching.
+	SessionCache *SessionCache
 }
 
 type LoginRequest struct {
@@ -20,11 +72,28 @@ type LoginRequest struct {
 	RememberMe bool   `json:"remember_me"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type MFAVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
 type LoginResponse struct {
-	Success bool            `json:"success"`
-	Message string          `json:"message"`
-	Token   string          `json:"token,omitempty"`
-	User    json.RawMessage `json:"user,omitempty"`
+	Success                   bool            `json:"success"`
+	Message                   string          `json:"message"`
+	Token                     string          `json:"token,omitempty"`
+	RefreshToken              string          `json:"refresh_token,omitempty"`
+	ExpiresIn                 int             `json:"expires_in,omitempty"`
+	MFARequired               bool            `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired     bool            `json:"mfa_enrollment_required,omitempty"`
+	EmailVerificationRequired bool            `json:"email_verification_required,omitempty"`
+	PasswordChangeRequired    bool            `json:"password_change_required,omitempty"`
+	ChallengeToken            string          `json:"challenge_token,omitempty"`
+	User                      json.RawMessage `json:"user,omitempty"`
 }
 
 type SessionValidation struct {
@@ -35,31 +104,72 @@ type SessionValidation struct {
 }
 
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	SessionID  int    `json:"sid,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	RememberMe bool   `json:"remember_me,omitempty"`
+	// ImpersonatorID is the administrator signed in as UserID, if any.
+	ImpersonatorID int `json:"impersonator_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-
-func init() {
-	if len(jwtSecret) == 0 {
-		jwtSecret = []byte("your-secret-key-change-in-production")
-	}
+// issuedTokens is the access/refresh pair handed out by login and refresh.
+type issuedTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
 }
 
-func NewAuthService(db *sql.DB) *AuthService {
-	return &AuthService{db: db}
+func NewAuthService(db *sql.DB, options AuthOptions) *AuthService {
+	if options.AccessTokenTTL <= 0 {
+		options.AccessTokenTTL = 15 * time.Minute
+	}
+	if options.RefreshTokenTTL <= 0 {
+		options.RefreshTokenTTL = 24 * time.Hour
+	}
+	if options.RememberMeRefreshTokenTTL <= 0 {
+		options.RememberMeRefreshTokenTTL = 30 * 24 * time.Hour
+	}
+	if options.Issuer == "" {
+		options.Issuer = "spa-system"
+	}
+	if options.Audience == "" {
+		options.Audience = "spa-system-api"
+	}
+	// Callers that bring no keys are development setups
+	if options.Keys == nil {
+		keys, err := LoadKeySet(KeySetOptions{AllowEphemeral: true})
+		if err != nil {
+			log.Fatalf("Failed to create signing key: %v", err)
+		}
+		options.Keys = keys
+	}
+	settings := NewSettingsService(db)
+	hasher := NewPasswordHasher(db, settings)
+	if options.Authenticators == nil {
+		options.Authenticators = NewAuthenticatorChain(NewLocalAuthenticator(db, hasher))
+	}
+	activity := NewActivityLogService(db)
+	return &AuthService{
+		db:       db,
+		options:  options,
+		mfa:      NewMFAService(db, settings),
+		lockout:  NewLockoutService(db, settings, activity),
+		activity: activity,
+		settings: settings,
+		expiry:   NewPasswordExpiryService(db, settings),
+		hasher:   hasher,
+	}
 }
 
 // =============================
 // LOGIN
 // =============================
 func (s *AuthService) Login(req LoginRequest, ipAddress, userAgent string) (*LoginResponse, error) {
-	// Call stored procedure login_user yang sudah handle pgcrypto password check
-	result, err := s.callLoginProcedure(req.Username, req.Password, ipAddress, userAgent)
+	result, err := s.authenticatePassword(req.Username, req.Password, ipAddress, userAgent)
 	if err != nil {
-		return nil, fmt.Errorf("login procedure error: %v", err)
+		return nil, fmt.Errorf("login error: %v", err)
 	}
 
 	if !result.Success {
@@ -69,32 +179,241 @@ func (s *AuthService) Login(req LoginRequest, ipAddress, userAgent string) (*Log
 		}, nil
 	}
 
-	// Generate JWT token
-	expirationTime := time.Now().Add(24 * time.Hour)
-	if req.RememberMe {
-		expirationTime = time.Now().Add(7 * 24 * time.Hour)
+	return s.completeLogin(result, req.RememberMe, ipAddress, userAgent)
+}
+
+// completeLogin finishes a login once the user has been authenticated, by
+// password or by an external identity provider: it either hands out a 2FA
+// challenge or opens a session.
+func (s *AuthService) completeLogin(result *loginResult, rememberMe bool, ipAddress, userAgent string) (*LoginResponse, error) {
+	mfaEnabled, err := s.mfa.IsEnabled(result.UserID)
+	if err != nil {
+		return nil, err
 	}
 
-	claims := &Claims{
-		UserID:   result.UserID,
-		Username: result.Username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	// Second step required: hand out a challenge instead of a session
+	if mfaEnabled {
+		challenge, err := s.signChallengeToken(result.UserID, result.Username, rememberMe)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResponse{
+			Success:        true,
+			Message:        "Two-factor authentication required",
+			MFARequired:    true,
+			ChallengeToken: challenge,
+		}, nil
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	scope, err := s.restrictedScope(result.UserID, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %v", err)
+		return nil, err
+	}
+
+	tokens, err := s.createSession(result.UserID, result.Username, scope, 0, s.refreshTTL(rememberMe), ipAddress, userAgent)
+	if err != nil {
+		return nil, err
 	}
 
 	return &LoginResponse{
-		Success: true,
-		Message: result.Message,
-		Token:   tokenString,
-		User:    result.UserInfo,
+		Success:                   true,
+		Message:                   result.Message,
+		Token:                     tokens.AccessToken,
+		RefreshToken:              tokens.RefreshToken,
+		ExpiresIn:                 tokens.ExpiresIn,
+		MFAEnrollmentRequired:     scope == ScopeMFAEnrollment,
+		EmailVerificationRequired: scope == ScopeEmailVerification,
+		PasswordChangeRequired:    scope == ScopePasswordChange,
+		User:                      result.UserInfo,
+	}, nil
+}
+
+// restrictedScope returns the scope a new session of the user is limited to,
+// or "" for a full session. One restriction applies at a time, in this order:
+// an unverified address, a password that must be changed, a role that
+// requires 2FA without enrolment. The user logs in again after resolving one.
+func (s *AuthService) restrictedScope(userID int, mfaEnabled bool) (string, error) {
+	emailPending, err := s.emailVerificationPending(userID)
+	if err != nil {
+		return "", err
+	}
+	if emailPending {
+		return ScopeEmailVerification, nil
+	}
+
+	passwordChange, err := s.expiry.ChangeRequired(userID)
+	if err != nil {
+		return "", err
+	}
+	if passwordChange {
+		return ScopePasswordChange, nil
+	}
+
+	if !mfaEnabled {
+		mfaRequired, err := s.mfa.IsRequired(userID)
+		if err != nil {
+			return "", err
+		}
+		if mfaRequired {
+			return ScopeMFAEnrollment, nil
+		}
+	}
+	return "", nil
+}
+
+// =============================
+// TWO-FACTOR CHALLENGE
+// =============================
+
+// VerifyMFAChallenge completes a two-step login: it checks the challenge token
+// issued by Login together with a TOTP or recovery code and opens the session.
+// A challenge takes a limited number of codes and only one session; wrong
+// codes also count towards the account lockout.
+func (s *AuthService) VerifyMFAChallenge(req MFAVerifyRequest, ipAddress, userAgent string) (*LoginResponse, error) {
+	claims, err := s.ValidateToken(req.ChallengeToken)
+	if err != nil || claims.Scope != ScopeMFAChallenge || claims.ID == "" {
+		return nil, ErrInvalidChallenge
+	}
+
+	// Every code presented counts against the challenge, right or wrong
+	maxAttempts := s.settings.GetInt("security.mfa_challenge_max_attempts", 5)
+	result, err := s.db.Exec(`
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE challenge_id = $1 AND user_id = $2 AND consumed_at IS NULL
+		  AND attempts < $3 AND expires_at > $4`,
+		claims.ID, claims.UserID, maxAttempts, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to record two-factor attempt: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrInvalidChallenge
+	}
+
+	actor := ActivityActor{UserID: claims.UserID, Username: claims.Username, IPAddress: ipAddress, UserAgent: userAgent}
+	if err := s.mfa.verifyAttempt(actor, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, ErrMFAInvalidCode) || errors.Is(err, ErrAccountLocked) {
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Two-factor code rejected"})
+		}
+		return nil, err
+	}
+
+	if _, err := s.db.Exec(`UPDATE mfa_challenges SET consumed_at = CURRENT_TIMESTAMP WHERE challenge_id = $1`, claims.ID); err != nil {
+		return nil, fmt.Errorf("failed to consume two-factor challenge: %v", err)
+	}
+	if err := s.lockout.RecordSuccess(claims.UserID); err != nil {
+		return nil, err
+	}
+
+	scope, err := s.restrictedScope(claims.UserID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.createSession(claims.UserID, claims.Username, scope, 0, s.refreshTTL(claims.RememberMe), ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Success:                   true,
+		Message:                   "Login successful",
+		Token:                     tokens.AccessToken,
+		RefreshToken:              tokens.RefreshToken,
+		ExpiresIn:                 tokens.ExpiresIn,
+		EmailVerificationRequired: scope == ScopeEmailVerification,
+		PasswordChangeRequired:    scope == ScopePasswordChange,
+	}, nil
+}
+
+// =============================
+// REFRESH TOKEN
+// =============================
+
+// RefreshToken rotates the refresh token of a session and issues a new access
+// token. Presenting a refresh token that was already rotated is treated as
+// theft: the whole session and every refresh token issued for it are revoked.
+func (s *AuthService) RefreshToken(refreshToken, ipAddress, userAgent string) (*LoginResponse, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenID       int64
+		sessionID     int
+		userID        int
+		username      string
+		expiresAt     time.Time
+		rotatedAt     sql.NullTime
+		revokedAt     sql.NullTime
+		sessionActive bool
+		sessionExpiry time.Time
+		scope         string
+		impersonator  int
+	)
+	query := `
+		SELECT rt.refresh_token_id, rt.session_id, rt.user_id, ua.username,
+		       rt.expires_at, rt.rotated_at, rt.revoked_at, us.is_active, us.expires_at,
+		       COALESCE(us.token_scope, ''), COALESCE(us.impersonator_id, 0)
+		FROM user_refresh_tokens rt
+		JOIN user_sessions us ON us.session_id = rt.session_id
+		JOIN users_application ua ON ua.user_apps_id = rt.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, us
+	`
+	err = tx.QueryRow(query, hashToken(refreshToken)).Scan(
+		&tokenID, &sessionID, &userID, &username,
+		&expiresAt, &rotatedAt, &revokedAt, &sessionActive, &sessionExpiry, &scope, &impersonator,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to load refresh token: %v", err)
+	}
+
+	if rotatedAt.Valid || revokedAt.Valid {
+		if err := revokeSessionTx(tx, sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %v", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	if !sessionActive || now.After(expiresAt) || now.After(sessionExpiry) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec(`UPDATE user_refresh_tokens SET rotated_at = $1 WHERE refresh_token_id = $2`, now, tokenID); err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+
+	// The refresh window of a session is fixed at login; rotation does not extend it.
+	tokens, err := s.issueTokensTx(tx, sessionID, userID, username, scope, impersonator, sessionExpiry, &tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE user_sessions SET ip_address = $1::inet, user_agent = $2
+		WHERE session_id = $3`, ipAddress, userAgent, sessionID); err != nil {
+		return nil, fmt.Errorf("failed to update session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return &LoginResponse{
+		Success:      true,
+		Message:      "Token refreshed successfully",
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
 	}, nil
 }
 
@@ -102,12 +421,9 @@ func (s *AuthService) Login(req LoginRequest, ipAddress, userAgent string) (*Log
 // VALIDATE TOKEN
 // =============================
 func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, s.options.Keys.Keyfunc,
+		jwt.WithValidMethods(s.options.Keys.ValidMethods()),
+		jwt.WithIssuer(s.options.Issuer))
 
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %v", err)
@@ -118,26 +434,101 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	// Each scope has its own audience; a token carrying another one was not
+	// issued for the scope it claims
+	if len(claims.Audience) != 1 || claims.Audience[0] != s.audience(claims.Scope) {
+		return nil, fmt.Errorf("invalid token audience")
+	}
+
 	return claims, nil
 }
 
+// SessionCacheStats returns the session cache counters.
+func (s *AuthService) SessionCacheStats() SessionCacheStats {
+	return s.options.SessionCache.Stats()
+}
+
+// JWKS returns the public keys that verify tokens issued by this service.
+func (s *AuthService) JWKS() JWKS {
+	return s.options.Keys.JWKS()
+}
+
 // =============================
 // VALIDATE SESSION
 // =============================
+// ValidateSession checks that the access token is still the current token of
+// an active, unexpired session. Revoked sessions fail on the next request:
+// cached validations are invalidated by the database when a session or user
+// changes.
 func (s *AuthService) ValidateSession(sessionToken string) (*SessionValidation, error) {
-	query := `SELECT * FROM security.validate_session($1)`
+	if hit, ok := s.options.SessionCache.Get(sessionToken); ok {
+		if hit.TouchDue {
+			s.touchSession(hit.SessionID)
+		}
+		validation := hit.Validation
+		return &validation, nil
+	}
 
-	var result SessionValidation
-	row := s.db.QueryRow(query, sessionToken)
+	generation := s.options.SessionCache.Generation()
+	query := `
+		SELECT us.session_id, us.user_id, u.username, us.is_active, us.expires_at, u.is_active,
+		       COALESCE(imp.is_active, true), COALESCE(us.impersonator_id, 0)
+		FROM user_sessions us
+		JOIN users_application u ON u.user_apps_id = us.user_id
+		LEFT JOIN users_application imp ON imp.user_apps_id = us.impersonator_id
+		WHERE us.session_token = $1
+	`
 
-	err := row.Scan(&result.Valid, &result.UserID, &result.Username, &result.Message)
+	var result SessionValidation
+	var sessionID, impersonatorID int
+	var sessionActive, userActive, impersonatorActive bool
+	var expiresAt time.Time
+	err := s.db.QueryRow(query, sessionToken).Scan(
+		&sessionID, &result.UserID, &result.Username, &sessionActive, &expiresAt, &userActive,
+		&impersonatorActive, &impersonatorID,
+	)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			result.Message = "Session not found"
+			return &result, nil
+		}
 		return nil, fmt.Errorf("failed to validate session: %v", err)
 	}
 
+	switch {
+	case !sessionActive:
+		result.Message = "Session has been revoked"
+	case !expiresAt.After(time.Now()):
+		result.Message = "Session has expired"
+	case !userActive:
+		result.Message = "Account is inactive"
+	case !impersonatorActive:
+		result.Message = "Impersonating account is inactive"
+	default:
+		result.Valid = true
+		result.Message = "Session is valid"
+	}
+
+	if result.Valid {
+		s.touchSession(sessionID)
+		s.options.SessionCache.Put(sessionToken, generation, result, sessionID, impersonatorID, expiresAt)
+	}
 	return &result, nil
 }
 
+// touchSession records activity on the session, at most once per
+// sessionTouchInterval to keep writes off the hot path.
+func (s *AuthService) touchSession(sessionID int) {
+	_, err := s.db.Exec(`
+		UPDATE user_sessions SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE session_id = $1
+		  AND (last_seen_at IS NULL OR last_seen_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 second')`,
+		sessionID, int(sessionTouchInterval.Seconds()))
+	if err != nil {
+		log.Printf("Failed to update last_seen_at for session %d: %v", sessionID, err)
+	}
+}
+
 // =============================
 // LOGOUT
 // =============================
@@ -154,13 +545,228 @@ func (s *AuthService) Logout(sessionToken, ipAddress, userAgent string) error {
 		return fmt.Errorf("logout failed: %s", message)
 	}
 
+	// Refresh tokens of the session must not outlive it
+	_, err = s.db.Exec(`
+		UPDATE user_refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE revoked_at IS NULL
+		  AND session_id IN (SELECT session_id FROM user_sessions WHERE session_token = $1)`, sessionToken)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+
+	s.recordImpersonationEnd(sessionToken, ipAddress, userAgent)
+	return nil
+}
+
+// =============================
+// SESSION & TOKEN ISSUING
+// =============================
+
+// createSession opens a new user_sessions row and issues its first token pair.
+// The session id is embedded in the access token as the sid claim.
+// impersonatorID is 0 except for sessions opened by Impersonate.
+func (s *AuthService) createSession(userID int, username, scope string, impersonatorID int, refreshTTL time.Duration, ipAddress, userAgent string) (*issuedTokens, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	tokens, err := s.createSessionTx(tx, userID, username, scope, impersonatorID, refreshTTL, ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// createSessionTx is createSession within a caller's transaction.
+func (s *AuthService) createSessionTx(tx *sql.Tx, userID int, username, scope string, impersonatorID int, refreshTTL time.Duration, ipAddress, userAgent string) (*issuedTokens, error) {
+	placeholder, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionExpiry := time.Now().Add(refreshTTL)
+	var sessionID int
+	err = tx.QueryRow(`
+		INSERT INTO user_sessions (user_id, session_token, ip_address, user_agent, login_at, expires_at, is_active, token_scope, impersonator_id)
+		VALUES ($1, $2, $3::inet, $4, CURRENT_TIMESTAMP, $5, true, NULLIF($6, ''), NULLIF($7, 0))
+		RETURNING session_id`,
+		userID, "pending:"+placeholder, ipAddress, userAgent, sessionExpiry, scope, impersonatorID,
+	).Scan(&sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return s.issueTokensTx(tx, sessionID, userID, username, scope, impersonatorID, sessionExpiry, nil)
+}
+
+// issueTokensTx signs a new access token for the session, stores it as the
+// current session token and records a new hashed refresh token.
+func (s *AuthService) issueTokensTx(tx *sql.Tx, sessionID, userID int, username, scope string, impersonatorID int, sessionExpiry time.Time, parentID *int64) (*issuedTokens, error) {
+	accessToken, err := s.signToken(&Claims{
+		UserID:         userID,
+		Username:       username,
+		SessionID:      sessionID,
+		Scope:          scope,
+		ImpersonatorID: impersonatorID,
+	}, s.options.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE user_sessions SET session_token = $1 WHERE session_id = $2`, accessToken, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store session token: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_refresh_tokens (session_id, user_id, token_hash, parent_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		sessionID, userID, hashToken(refreshToken), parentID, sessionExpiry,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	return &issuedTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.options.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// signChallengeToken issues the session-less token used between the password
+// step and the TOTP step of a login. The challenge is recorded under its jti
+// so that VerifyMFAChallenge can count the codes tried with it.
+func (s *AuthService) signChallengeToken(userID int, username string, rememberMe bool) (string, error) {
+	challengeID, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{
+		UserID:           userID,
+		Username:         username,
+		Scope:            ScopeMFAChallenge,
+		RememberMe:       rememberMe,
+		RegisteredClaims: jwt.RegisteredClaims{ID: challengeID},
+	}
+	token, err := s.signToken(claims, mfaChallengeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM mfa_challenges WHERE user_id = $1 AND expires_at <= $2`, userID, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to clean up two-factor challenges: %v", err)
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO mfa_challenges (challenge_id, user_id, expires_at)
+		VALUES ($1, $2, $3)`, challengeID, userID, claims.ExpiresAt.Time)
+	if err != nil {
+		return "", fmt.Errorf("failed to store two-factor challenge: %v", err)
+	}
+	return token, nil
+}
+
+// signToken signs claims with the current key, setting the issuer, the
+// audience of the token's scope and the timestamps. Other registered claims,
+// such as the jti, are kept.
+func (s *AuthService) signToken(claims *Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.Issuer = s.options.Issuer
+	claims.Audience = jwt.ClaimStrings{s.audience(claims.Scope)}
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+
+	tokenString, err := s.options.Keys.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return tokenString, nil
+}
+
+// audience returns the aud claim of tokens with the given scope.
+func (s *AuthService) audience(scope string) string {
+	if scope == "" {
+		return s.options.Audience
+	}
+	return s.options.Audience + ":" + scope
+}
+
+func (s *AuthService) refreshTTL(rememberMe bool) time.Duration {
+	if rememberMe {
+		return s.options.RememberMeRefreshTokenTTL
+	}
+	return s.options.RefreshTokenTTL
+}
+
+// revokeSessionTx ends a session and invalidates every refresh token issued for it.
+func revokeSessionTx(tx *sql.Tx, sessionID int) error {
+	_, err := tx.Exec(`
+		UPDATE user_sessions SET is_active = false, logout_at = COALESCE(logout_at, CURRENT_TIMESTAMP)
+		WHERE session_id = $1`, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE session_id = $1 AND revoked_at IS NULL`, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
 	return nil
 }
 
+// revokeUserSessionsTx ends every active session of a user except
+// exceptSessionID (0 revokes all) and invalidates their refresh tokens.
+func revokeUserSessionsTx(tx *sql.Tx, userID, exceptSessionID int) error {
+	_, err := tx.Exec(`
+		UPDATE user_refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND session_id <> $2 AND revoked_at IS NULL`, userID, exceptSessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_sessions SET is_active = false, logout_at = COALESCE(logout_at, CURRENT_TIMESTAMP)
+		WHERE user_id = $1 AND session_id <> $2 AND is_active = true`, userID, exceptSessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	return nil
+}
+
+// generateOpaqueToken returns 32 random bytes encoded as URL-safe base64.
+func generateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex SHA-256 of an opaque token; only hashes are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // =============================
-// DB CALL - LOGIN PROCEDURE
+// PASSWORD LOGIN
 // =============================
-type procedureLoginResult struct {
+type loginResult struct {
 	Success  bool            `json:"success"`
 	Message  string          `json:"message"`
 	UserID   int             `json:"user_id"`
@@ -168,30 +774,182 @@ type procedureLoginResult struct {
 	UserInfo json.RawMessage `json:"user_info"`
 }
 
-func (s *AuthService) callLoginProcedure(username, password, ipAddress, userAgent string) (*procedureLoginResult, error) {
+const (
+	ActivityLogin       = "LOGIN"
+	ActivityLoginFailed = "LOGIN_FAILED"
+)
+
+// loginAccount is the users_application state checked before a password is
+// verified.
+type loginAccount struct {
+	UserID       int
+	Username     string
+	AuthProvider string
+	IsActive     bool
+	IsService    bool
+	LockedUntil  sql.NullTime
+}
+
+// findLoginAccount looks up the account for a login. When the authenticator
+// was chosen by domain, its accounts are also matched by the bare login name.
+func (s *AuthService) findLoginAccount(username, loginName, provider string) (*loginAccount, error) {
 	query := `
-		SELECT success, message, user_id, user_info
-		FROM security.login_user($1, $2, $3::inet, $4)
+		SELECT user_apps_id, username, COALESCE(auth_provider, ''), is_active, is_service_account, locked_until
+		FROM users_application
 	`
 
-	var result procedureLoginResult
-	err := s.db.QueryRow(query, username, password, ipAddress, userAgent).Scan(
-		&result.Success,
-		&result.Message,
-		&result.UserID,
-		&result.UserInfo,
+	var account loginAccount
+	err := s.db.QueryRow(query+` WHERE username = $1`, username).Scan(
+		&account.UserID, &account.Username, &account.AuthProvider, &account.IsActive, &account.IsService, &account.LockedUntil,
 	)
+	if err == sql.ErrNoRows && loginName != username {
+		err = s.db.QueryRow(query+` WHERE username = $1 AND auth_provider = $2`, loginName, provider).Scan(
+			&account.UserID, &account.Username, &account.AuthProvider, &account.IsActive, &account.IsService, &account.LockedUntil,
+		)
+	}
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
+	return &account, nil
+}
+
+// authenticatePassword checks the username and password with the
+// authenticator chosen for the account (or, for unknown users, by domain),
+// applies the lockout policy and provisions directory users on first login.
+func (s *AuthService) authenticatePassword(username, password, ipAddress, userAgent string) (*loginResult, error) {
+	actor := ActivityActor{Username: username, IPAddress: ipAddress, UserAgent: userAgent}
+	invalid := &loginResult{Success: false, Message: "Invalid username or password"}
+
+	authenticator, loginName := s.options.Authenticators.ForUsername(username)
+	account, err := s.findLoginAccount(username, loginName, authenticator.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if account != nil {
+		actor.UserID = account.UserID
+
+		recorded, ok := s.options.Authenticators.ByName(account.AuthProvider)
+		if !ok {
+			log.Printf("Account %s uses unknown authenticator %q", account.Username, account.AuthProvider)
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Sign-in method not configured"})
+			return &loginResult{Success: false, Message: "Sign-in method for this account is not available"}, nil
+		}
+		if recorded != authenticator {
+			authenticator, loginName = recorded, account.Username
+		}
+
+		// Service accounts only authenticate with API keys
+		if account.IsService {
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Service account sign-in attempt"})
+			return invalid, nil
+		}
+
+		if !account.IsActive {
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Account is inactive"})
+			return &loginResult{Success: false, Message: "Account is inactive"}, nil
+		}
+
+		if account.LockedUntil.Valid && account.LockedUntil.Time.After(time.Now()) {
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Account is locked"})
+			return &loginResult{Success: false, Message: "Account is temporarily locked. Please try again later."}, nil
+		}
+	}
+
+	identity, err := authenticator.Authenticate(loginName, password)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+
+		if account == nil {
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Unknown username"})
+			return invalid, nil
+		}
+
+		s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Invalid password"})
+		locked, err := s.lockout.RecordFailure(account.UserID, actor)
+		if err != nil {
+			return nil, err
+		}
+		if locked != nil {
+			return &loginResult{Success: false, Message: "Too many failed attempts. Account is temporarily locked."}, nil
+		}
+		return invalid, nil
+	}
+
+	var userID int
+	provisioner, external := authenticator.(ProvisioningAuthenticator)
+	switch {
+	case account != nil:
+		userID = account.UserID
+	case external && provisioner.Provisioning().JIT:
+		userID, err = s.provisionUser(authenticator.Name(), identity, provisioner.Provisioning())
+		if errors.Is(err, errProvisioningConflict) {
+			log.Printf("Login of %s rejected: %v", username, err)
+			s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: err.Error()})
+			return &loginResult{Success: false, Message: "Your account could not be created. Please contact an administrator."}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		actor.UserID = userID
+	default:
+		s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "No local account for directory user"})
+		return invalid, nil
+	}
 
-	// Extract username from JSON user_info
-	var userInfoMap map[string]interface{}
-	if err := json.Unmarshal(result.UserInfo, &userInfoMap); err == nil {
-		if uname, ok := userInfoMap["username"].(string); ok {
-			result.Username = uname
+	if external {
+		if err := s.syncManagedRoles(userID, identity.Roles, provisioner.Provisioning().ManagedRoles); err != nil {
+			return nil, err
+		}
+	}
+
+	// With 2FA the sign-in only succeeds once the code is verified; clearing
+	// the failures here would let each password login erase wrong codes
+	mfaEnabled, err := s.mfa.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !mfaEnabled {
+		if err := s.lockout.RecordSuccess(userID); err != nil {
+			return nil, err
 		}
 	}
 
+	result, err := s.loadLoginResult(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLogin, Description: "Password verified by " + authenticator.Name()})
+	return result, nil
+}
+
+// loadLoginResult stamps last_login_at and returns the user info included in
+// the login response.
+func (s *AuthService) loadLoginResult(userID int) (*loginResult, error) {
+	result := loginResult{Success: true, Message: "Login successful", UserID: userID}
+	err := s.db.QueryRow(`
+		UPDATE users_application SET last_login_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $1
+		RETURNING username, json_build_object(
+			'user_id', user_apps_id,
+			'username', username,
+			'email', email,
+			'first_name', first_name,
+			'last_name', last_name,
+			'department_id', department_id,
+			'employee_id', employee_id,
+			'phone', phone,
+			'avatar_url', avatar_url,
+			'last_login_at', last_login_at
+		)`, userID).Scan(&result.Username, &result.UserInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user info: %v", err)
+	}
 	return &result, nil
 }
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var refreshTokenColumns = []string{
+	"refresh_token_id", "session_id", "user_id", "username",
+	"expires_at", "rotated_at", "revoked_at", "is_active", "expires_at",
+	"token_scope", "impersonator_id",
+}
+
+func newTestAuthService(t *testing.T) (*AuthService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	keys, err := LoadKeySet(KeySetOptions{AllowEphemeral: true})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	return NewAuthService(db, AuthOptions{Keys: keys}), mock
+}
+
+// expectRefreshTokenRow expects the locking lookup of a refresh token.
+func expectRefreshTokenRow(mock sqlmock.Sqlmock, token string, rotatedAt interface{}) {
+	expiry := time.Now().Add(time.Hour)
+	mock.ExpectQuery(`SELECT rt.refresh_token_id`).
+		WithArgs(hashToken(token)).
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns).
+			AddRow(int64(7), 3, 42, "alice", expiry, rotatedAt, nil, true, expiry, "", 0))
+}
+
+// expectSessionRevoked expects revokeSessionTx and the commit that keeps it.
+func expectSessionRevoked(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`UPDATE user_sessions SET is_active = false`).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_refresh_tokens SET revoked_at`).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	s, mock := newTestAuthService(t)
+
+	mock.ExpectBegin()
+	expectRefreshTokenRow(mock, "stolen", time.Now().Add(-time.Minute))
+	expectSessionRevoked(mock)
+
+	_, err := s.RefreshToken("stolen", "203.0.113.5", "test")
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RefreshToken error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenRotatedTokenCannotBeUsedTwice(t *testing.T) {
+	s, mock := newTestAuthService(t)
+
+	// First use: the token is rotated and a new pair is issued
+	mock.ExpectBegin()
+	expectRefreshTokenRow(mock, "original", nil)
+	mock.ExpectExec(`UPDATE user_refresh_tokens SET rotated_at`).
+		WithArgs(sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET session_token`).
+		WithArgs(sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_refresh_tokens`).
+		WithArgs(3, 42, sqlmock.AnyArg(), int64(7), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET ip_address`).
+		WithArgs("203.0.113.5", "test", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Second use: the lookup now finds it rotated
+	mock.ExpectBegin()
+	expectRefreshTokenRow(mock, "original", time.Now())
+	expectSessionRevoked(mock)
+
+	response, err := s.RefreshToken("original", "203.0.113.5", "test")
+	if err != nil {
+		t.Fatalf("first RefreshToken: %v", err)
+	}
+	if response.RefreshToken == "" || response.RefreshToken == "original" {
+		t.Fatalf("first RefreshToken returned refresh token %q, want a new one", response.RefreshToken)
+	}
+	if _, err := s.ValidateToken(response.Token); err != nil {
+		t.Fatalf("issued access token does not validate: %v", err)
+	}
+
+	if _, err := s.RefreshToken("original", "203.0.113.5", "test"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("second RefreshToken error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenUnknown(t *testing.T) {
+	s, mock := newTestAuthService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT rt.refresh_token_id`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	if _, err := s.RefreshToken("unknown", "203.0.113.5", "test"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("RefreshToken error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyMFAChallengeRefusedAfterMaxAttempts(t *testing.T) {
+	s, mock := newTestAuthService(t)
+
+	mock.ExpectExec(`DELETE FROM mfa_challenges`).WithArgs(42, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO mfa_challenges`).WithArgs(sqlmock.AnyArg(), 42, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	challenge, err := s.signChallengeToken(42, "alice", false)
+	if err != nil {
+		t.Fatalf("signChallengeToken: %v", err)
+	}
+
+	// The attempt counter is already at the limit: no code is checked
+	mock.ExpectQuery(`SELECT setting_value FROM system_settings`).
+		WithArgs("security.mfa_challenge_max_attempts").
+		WillReturnRows(sqlmock.NewRows([]string{"setting_value"}).AddRow("3"))
+	mock.ExpectExec(`UPDATE mfa_challenges SET attempts = attempts \+ 1`).
+		WithArgs(sqlmock.AnyArg(), 42, 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err = s.VerifyMFAChallenge(MFAVerifyRequest{ChallengeToken: challenge, Code: "123456"}, "203.0.113.5", "test")
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("VerifyMFAChallenge error = %v, want %v", err, ErrInvalidChallenge)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateTokenChecksIssuerAndAudience(t *testing.T) {
+	s, _ := newTestAuthService(t)
+
+	token, err := s.signToken(&Claims{UserID: 42, Username: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatalf("signToken: %v", err)
+	}
+	if _, err := s.ValidateToken(token); err != nil {
+		t.Fatalf("ValidateToken of own token: %v", err)
+	}
+
+	other := NewAuthService(s.db, AuthOptions{Keys: s.options.Keys, Issuer: "someone-else"})
+	if _, err := other.ValidateToken(token); err == nil {
+		t.Error("token accepted by a service with another issuer")
+	}
+
+	// A challenge token relabelled as a full token keeps its scoped audience
+	claims := &Claims{UserID: 42, Username: "alice", Scope: ScopeMFAChallenge}
+	if _, err := s.signToken(claims, time.Minute); err != nil {
+		t.Fatalf("signToken: %v", err)
+	}
+	if claims.Audience[0] != "spa-system-api:"+ScopeMFAChallenge {
+		t.Fatalf("challenge audience = %v", claims.Audience)
+	}
+	claims.Scope = ""
+	forged, err := s.options.Keys.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := s.ValidateToken(forged); err == nil {
+		t.Error("token accepted with the audience of another scope")
+	}
+}
+
+func TestLoadKeySetRequiresSigningKey(t *testing.T) {
+	if _, err := LoadKeySet(KeySetOptions{}); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("LoadKeySet without a key file error = %v, want %v", err, ErrNoSigningKey)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LocalAuthenticatorName is the users_application.auth_provider value of
+// accounts whose password is stored in the database.
+const LocalAuthenticatorName = "local"
+
+// ErrInvalidCredentials is returned by an Authenticator when the username or
+// password is wrong. Any other error means the check could not be made.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// AuthenticatedIdentity is what an Authenticator knows about the user after a
+// successful check. Directory authenticators fill in the profile and the role
+// codes mapped from group membership.
+type AuthenticatedIdentity struct {
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string
+	Roles     []string
+}
+
+// Authenticator verifies a username and password against one credential store.
+type Authenticator interface {
+	Name() string
+	Authenticate(username, password string) (*AuthenticatedIdentity, error)
+}
+
+// ProvisioningOptions controls how accounts of an external authenticator are
+// created and kept in sync on login.
+type ProvisioningOptions struct {
+	// JIT creates a users_application row on the first successful login.
+	JIT             bool
+	DefaultStatusID int
+	// ManagedRoles are the role codes the authenticator owns: they are granted
+	// or revoked in user_roles to match AuthenticatedIdentity.Roles. Other
+	// roles of the user are left alone.
+	ManagedRoles []string
+}
+
+// ProvisioningAuthenticator is implemented by external authenticators whose
+// users may be provisioned just in time.
+type ProvisioningAuthenticator interface {
+	Authenticator
+	Provisioning() ProvisioningOptions
+}
+
+// AuthenticatorChain picks the authenticator for a login. An existing account
+// uses the authenticator recorded in its auth_provider column; otherwise the
+// domain part of the username ("user@domain" or "DOMAIN\user") selects one,
+// and the local database is the fallback.
+type AuthenticatorChain struct {
+	local    Authenticator
+	byName   map[string]Authenticator
+	byDomain map[string]Authenticator
+}
+
+func NewAuthenticatorChain(local Authenticator) *AuthenticatorChain {
+	chain := &AuthenticatorChain{
+		local:    local,
+		byName:   map[string]Authenticator{},
+		byDomain: map[string]Authenticator{},
+	}
+	chain.byName[local.Name()] = local
+	return chain
+}
+
+// Register adds an authenticator that is chosen for usernames in domains.
+func (c *AuthenticatorChain) Register(authenticator Authenticator, domains []string) error {
+	name := authenticator.Name()
+	if _, exists := c.byName[name]; exists {
+		return fmt.Errorf("authenticator %q registered twice", name)
+	}
+	c.byName[name] = authenticator
+
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if other, exists := c.byDomain[domain]; exists {
+			return fmt.Errorf("domain %q is mapped to both %q and %q", domain, other.Name(), name)
+		}
+		c.byDomain[domain] = authenticator
+	}
+	return nil
+}
+
+// ByName returns the authenticator recorded for an account.
+func (c *AuthenticatorChain) ByName(name string) (Authenticator, bool) {
+	if name == "" {
+		return c.local, true
+	}
+	authenticator, ok := c.byName[name]
+	return authenticator, ok
+}
+
+// ForUsername returns the authenticator for a username without an account and
+// the name to present to it, with the domain part removed when it matched.
+func (c *AuthenticatorChain) ForUsername(username string) (Authenticator, string) {
+	name, domain := splitLoginDomain(username)
+	if domain != "" {
+		if authenticator, ok := c.byDomain[strings.ToLower(domain)]; ok {
+			return authenticator, name
+		}
+	}
+	return c.local, username
+}
+
+// splitLoginDomain splits "user@domain" and "DOMAIN\user".
+func splitLoginDomain(username string) (string, string) {
+	if domain, name, ok := strings.Cut(username, `\`); ok && domain != "" && name != "" {
+		return name, domain
+	}
+	if at := strings.LastIndex(username, "@"); at > 0 && at < len(username)-1 {
+		return username[:at], username[at+1:]
+	}
+	return username, ""
+}
+
+// LocalAuthenticator checks passwords stored in users_application through the
+// PasswordHasher, upgrading hashes that are not in the configured format.
+type LocalAuthenticator struct {
+	db     *sql.DB
+	hasher *PasswordHasher
+}
+
+func NewLocalAuthenticator(db *sql.DB, hasher *PasswordHasher) *LocalAuthenticator {
+	return &LocalAuthenticator{db: db, hasher: hasher}
+}
+
+func (a *LocalAuthenticator) Name() string {
+	return LocalAuthenticatorName
+}
+
+func (a *LocalAuthenticator) Authenticate(username, password string) (*AuthenticatedIdentity, error) {
+	var userID int
+	var hash sql.NullString
+	err := a.db.QueryRow(`
+		SELECT user_apps_id, password_hash
+		FROM users_application WHERE username = $1`, username).Scan(&userID, &hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Unknown usernames must not answer faster than wrong passwords
+			a.hasher.VerifyDummy(password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to verify password: %v", err)
+	}
+
+	matches, err := a.hasher.Verify(password, hash.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify password: %v", err)
+	}
+	if !matches {
+		return nil, ErrInvalidCredentials
+	}
+	a.hasher.Rehash(userID, hash.String, password)
+	return &AuthenticatedIdentity{Username: username}, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// menuActionColumns maps the supported menu actions to their role_menus column.
+var menuActionColumns = map[string]string{
+	"can_view":     "can_view",
+	"can_create":   "can_create",
+	"can_modify":   "can_modify",
+	"can_delete":   "can_delete",
+	"can_upload":   "can_upload",
+	"can_download": "can_download",
+}
+
+// AuthorizationService resolves what a user may do through the roles assigned
+// in user_roles, the permission codes in role_permissions and the per-menu
+// flags in role_menus.
+type AuthorizationService struct {
+	db *sql.DB
+}
+
+func NewAuthorizationService(db *sql.DB) *AuthorizationService {
+	return &AuthorizationService{db: db}
+}
+
+// HasPermission reports whether any active role of the user grants the permission code.
+func (s *AuthorizationService) HasPermission(userID int, permissionCode string) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM user_roles ur
+			JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+			JOIN role_permissions rp ON rp.role_id = ur.role_id AND rp.is_active = true
+			JOIN permissions p ON p.permissions_id = rp.permission_id AND p.is_active = true
+			WHERE ur.user_id = $1 AND ur.is_active = true AND p.permission_code = $2
+		)
+	`
+
+	var allowed bool
+	if err := s.db.QueryRow(query, userID, permissionCode).Scan(&allowed); err != nil {
+		return false, fmt.Errorf("failed to check permission: %w", err)
+	}
+	return allowed, nil
+}
+
+// HasMenuAction reports whether any active role of the user has the given
+// can_* flag set on the menu identified by menuCode. A menu code that is not
+// configured in menus does not restrict anything.
+func (s *AuthorizationService) HasMenuAction(userID int, menuCode, action string) (bool, error) {
+	column, ok := menuActionColumns[action]
+	if !ok {
+		return false, fmt.Errorf("unknown menu action: %s", action)
+	}
+
+	query := `
+		SELECT NOT EXISTS(SELECT 1 FROM menus WHERE menu_code = $2 AND is_active = true)
+		    OR EXISTS(
+			SELECT 1
+			FROM user_roles ur
+			JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+			JOIN role_menus rm ON rm.role_id = ur.role_id
+			JOIN menus m ON m.menus_id = rm.menu_id AND m.is_active = true
+			WHERE ur.user_id = $1 AND ur.is_active = true AND m.menu_code = $2 AND rm.` + column + ` = true
+		)
+	`
+
+	var allowed bool
+	if err := s.db.QueryRow(query, userID, menuCode).Scan(&allowed); err != nil {
+		return false, fmt.Errorf("failed to check menu action: %w", err)
+	}
+	return allowed, nil
+}
+
+// HasPermissionsOf reports whether every permission granted by the active
+// roles of otherUserID is also granted to userID.
+func (s *AuthorizationService) HasPermissionsOf(userID, otherUserID int) (bool, error) {
+	query := `
+		WITH granted AS (
+			SELECT ur.user_id, p.permissions_id
+			FROM user_roles ur
+			JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+			JOIN role_permissions rp ON rp.role_id = ur.role_id AND rp.is_active = true
+			JOIN permissions p ON p.permissions_id = rp.permission_id AND p.is_active = true
+			WHERE ur.is_active = true AND ur.user_id IN ($1, $2)
+		)
+		SELECT NOT EXISTS(
+			SELECT permissions_id FROM granted WHERE user_id = $2
+			EXCEPT
+			SELECT permissions_id FROM granted WHERE user_id = $1
+		)
+	`
+
+	var covered bool
+	if err := s.db.QueryRow(query, userID, otherUserID).Scan(&covered); err != nil {
+		return false, fmt.Errorf("failed to compare permissions: %w", err)
+	}
+	return covered, nil
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	avatarSize          = 256
+	avatarThumbnailSize = 64
+	// avatarMaxDimension keeps small files that decode to huge images out
+	avatarMaxDimension = 4096
+)
+
+// avatarContentTypes are the accepted uploads, detected from the file content
+// rather than the client supplied header.
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var (
+	ErrAvatarTooLarge   = errors.New("avatar file is too large")
+	ErrAvatarType       = errors.New("avatar must be a JPEG, PNG, GIF or WebP image")
+	ErrAvatarDimensions = fmt.Errorf("avatar must be at most %dx%d pixels", avatarMaxDimension, avatarMaxDimension)
+	ErrAvatarUnreadable = errors.New("avatar image could not be read")
+)
+
+// Avatar holds the URLs of a stored avatar.
+type Avatar struct {
+	URL          string `json:"avatar_url"`
+	ThumbnailURL string `json:"avatar_thumbnail_url"`
+}
+
+// AvatarService stores profile pictures. Every upload is cropped to a square
+// and stored as a 256px image and a 64px thumbnail under a new key, so clients
+// never see a cached previous picture.
+type AvatarService struct {
+	db        *sql.DB
+	storage   FileStorage
+	settings  *SettingsService
+	publicURL string
+}
+
+// NewAvatarService takes the public URL under which storage keys are served,
+// e.g. "http://localhost:8080/uploads".
+func NewAvatarService(db *sql.DB, storage FileStorage, settings *SettingsService, publicURL string) *AvatarService {
+	return &AvatarService{
+		db:        db,
+		storage:   storage,
+		settings:  settings,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}
+}
+
+// MaxSize is the largest accepted upload in bytes.
+func (s *AvatarService) MaxSize() int64 {
+	return int64(s.settings.GetInt("profile.avatar_max_size_kb", 2048)) * 1024
+}
+
+// Upload validates the image in r, stores it with its thumbnail and points the
+// user's avatar_url at it. The previous files are removed afterwards.
+func (s *AvatarService) Upload(ctx context.Context, userID int, r io.Reader, updatedBy string) (*Avatar, error) {
+	maxSize := s.MaxSize()
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, ErrAvatarUnreadable
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrAvatarTooLarge
+	}
+	if !avatarContentTypes[http.DetectContentType(data)] {
+		return nil, ErrAvatarType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrAvatarUnreadable
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > avatarMaxDimension || config.Height > avatarMaxDimension {
+		return nil, ErrAvatarDimensions
+	}
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrAvatarUnreadable
+	}
+
+	picture, err := encodeSquarePNG(source, avatarSize)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := encodeSquarePNG(source, avatarThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate avatar key: %w", err)
+	}
+	storageKey := fmt.Sprintf("avatars/%d/%s", userID, hex.EncodeToString(suffix))
+
+	if err := s.storage.Put(ctx, storageKey+".png", "image/png", picture); err != nil {
+		return nil, err
+	}
+	if err := s.storage.Put(ctx, storageKey+"_thumb.png", "image/png", thumbnail); err != nil {
+		s.deleteFiles(ctx, storageKey)
+		return nil, err
+	}
+
+	avatar := &Avatar{
+		URL:          s.publicURL + "/" + storageKey + ".png",
+		ThumbnailURL: s.publicURL + "/" + storageKey + "_thumb.png",
+	}
+
+	previousKey, err := s.setAvatar(userID, avatar, storageKey, updatedBy)
+	if err != nil {
+		s.deleteFiles(ctx, storageKey)
+		return nil, err
+	}
+
+	if previousKey.Valid && previousKey.String != "" {
+		s.deleteFiles(ctx, previousKey.String)
+	}
+	return avatar, nil
+}
+
+// setAvatar stores the avatar URLs and returns the storage key of the avatar
+// it replaces.
+func (s *AvatarService) setAvatar(userID int, avatar *Avatar, storageKey, updatedBy string) (sql.NullString, error) {
+	var previousKey sql.NullString
+	tx, err := s.db.Begin()
+	if err != nil {
+		return previousKey, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		SELECT avatar_storage_key FROM users_application
+		WHERE user_apps_id = $1 FOR UPDATE`, userID).Scan(&previousKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return previousKey, sql.ErrNoRows
+		}
+		return previousKey, fmt.Errorf("failed to load avatar: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users_application
+		SET avatar_url = $1, avatar_thumbnail_url = $2, avatar_storage_key = $3,
+		    updated_by = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $5`, avatar.URL, avatar.ThumbnailURL, storageKey, updatedBy, userID)
+	if err != nil {
+		return previousKey, fmt.Errorf("failed to update avatar: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return previousKey, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return previousKey, nil
+}
+
+// deleteFiles removes both files of an avatar. Leftovers only cost storage, so
+// failures are logged.
+func (s *AvatarService) deleteFiles(ctx context.Context, storageKey string) {
+	for _, key := range []string{storageKey + ".png", storageKey + "_thumb.png"} {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete avatar file %s: %v", key, err)
+		}
+	}
+}
+
+// encodeSquarePNG crops the centre square of source and scales it to size.
+func encodeSquarePNG(source image.Image, size int) ([]byte, error) {
+	bounds := source.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+
+	target := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(target, target.Bounds(), source, crop, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, target); err != nil {
+		return nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	return buf.Bytes(), nil
+}
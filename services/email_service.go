@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SMTPOptions configures outgoing mail. With an empty Host, emails are only
+// written to the log, which is convenient for local development.
+type SMTPOptions struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// RenderedEmail is an email_templates row with its variables substituted.
+type RenderedEmail struct {
+	Subject  string
+	BodyHTML string
+	BodyText string
+}
+
+// EmailService renders email_templates rows and delivers them over SMTP.
+type EmailService struct {
+	db      *sql.DB
+	options SMTPOptions
+}
+
+var templateVariablePattern = regexp.MustCompile(`{{\s*([a-zA-Z0-9_]+)\s*}}`)
+
+func NewEmailService(db *sql.DB, options SMTPOptions) *EmailService {
+	return &EmailService{db: db, options: options}
+}
+
+// Render loads the active template with the given code and replaces
+// {{variable}} placeholders. Values are HTML-escaped in the HTML body.
+func (s *EmailService) Render(templateCode string, variables map[string]string) (*RenderedEmail, error) {
+	query := `
+		SELECT subject, COALESCE(body_html, ''), COALESCE(body_text, '')
+		FROM email_templates
+		WHERE template_code = $1 AND is_active = true
+	`
+
+	var subject, bodyHTML, bodyText string
+	err := s.db.QueryRow(query, templateCode).Scan(&subject, &bodyHTML, &bodyText)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email template %s not found", templateCode)
+		}
+		return nil, fmt.Errorf("failed to load email template %s: %w", templateCode, err)
+	}
+
+	return &RenderedEmail{
+		Subject:  substituteVariables(subject, variables, false),
+		BodyHTML: substituteVariables(bodyHTML, variables, true),
+		BodyText: substituteVariables(bodyText, variables, false),
+	}, nil
+}
+
+// SendTemplate renders the template and sends it to a single recipient.
+func (s *EmailService) SendTemplate(to, templateCode string, variables map[string]string) error {
+	email, err := s.Render(templateCode, variables)
+	if err != nil {
+		return err
+	}
+	return s.Send(to, email)
+}
+
+// Send delivers a rendered email as multipart/alternative.
+func (s *EmailService) Send(to string, email *RenderedEmail) error {
+	if s.options.Host == "" {
+		log.Printf("SMTP not configured, email to %s not sent: %s\n%s", to, email.Subject, email.BodyText)
+		return nil
+	}
+
+	message, err := buildMessage(s.options.From, to, email)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.options.Username != "" {
+		auth = smtp.PlainAuth("", s.options.Username, s.options.Password, s.options.Host)
+	}
+
+	addr := s.options.Host + ":" + s.options.Port
+	if err := smtp.SendMail(addr, auth, s.options.From, []string{to}, message); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", to, err)
+	}
+	return nil
+}
+
+func substituteVariables(content string, variables map[string]string, escapeHTML bool) string {
+	return templateVariablePattern.ReplaceAllStringFunc(content, func(match string) string {
+		name := templateVariablePattern.FindStringSubmatch(match)[1]
+		value, ok := variables[name]
+		if !ok {
+			return match
+		}
+		if escapeHTML {
+			return html.EscapeString(value)
+		}
+		return value
+	})
+}
+
+func buildMessage(from, to string, email *RenderedEmail) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", email.BodyText},
+		{"text/html; charset=UTF-8", email.BodyHTML},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build email: %w", err)
+	}
+
+	var message bytes.Buffer
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("UTF-8", email.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + writer.Boundary(),
+	}
+	message.WriteString(strings.Join(headers, "\r\n"))
+	message.WriteString("\r\n\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScopeEmailVerification marks sessions of users who must verify their email
+// address before doing anything else, when security.require_verified_email is
+// on.
+const ScopeEmailVerification = "email_verification"
+
+const (
+	emailVerificationTemplate  = "EMAIL_VERIFICATION"
+	emailChangeRequestTemplate = "EMAIL_CHANGE_REQUESTED"
+)
+
+const (
+	ActivityEmailVerified        = "EMAIL_VERIFIED"
+	ActivityEmailChangeRequested = "EMAIL_CHANGE_REQUESTED"
+	ActivityEmailChanged         = "EMAIL_CHANGED"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+	ErrEmailUnchanged           = errors.New("new email address is the current one")
+	ErrEmailTaken               = errors.New("email address is already in use")
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" validate:"required,email,max=100"`
+	CurrentPassword string `json:"current_password" validate:"required"`
+}
+
+// VerifiedEmail is the outcome of a consumed verification token.
+type VerifiedEmail struct {
+	Email   string `json:"email"`
+	Changed bool   `json:"changed"`
+}
+
+// EmailVerificationService proves ownership of email addresses with single
+// use links. A change of address is held in pending_email until the link sent
+// to the new address is opened; the old address stays in use meanwhile.
+type EmailVerificationService struct {
+	db         *sql.DB
+	email      *EmailService
+	settings   *SettingsService
+	activity   *ActivityLogService
+	appBaseURL string
+}
+
+func NewEmailVerificationService(db *sql.DB, email *EmailService, settings *SettingsService, activity *ActivityLogService, appBaseURL string) *EmailVerificationService {
+	return &EmailVerificationService{
+		db:         db,
+		email:      email,
+		settings:   settings,
+		activity:   activity,
+		appBaseURL: strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+func (s *EmailVerificationService) tokenTTL() time.Duration {
+	return time.Duration(s.settings.GetInt("security.email_verification_ttl_hours", 48)) * time.Hour
+}
+
+// SendVerification mails a verification link for the user's current address.
+func (s *EmailVerificationService) SendVerification(userID int) error {
+	var username, firstName, email string
+	var verifiedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT username, first_name, email, email_verified_at
+		FROM users_application WHERE user_apps_id = $1`, userID,
+	).Scan(&username, &firstName, &email, &verifiedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("failed to look up account: %w", err)
+	}
+	if verifiedAt.Valid {
+		return ErrEmailAlreadyVerified
+	}
+
+	return s.issueToken(userID, username, firstName, email)
+}
+
+// RequestEmailChange records newEmail as pending for userID, sends a
+// verification link to it and tells the current address that a change was
+// requested. actor is the user or administrator asking for the change.
+func (s *EmailVerificationService) RequestEmailChange(actor ActivityActor, userID int, newEmail string) error {
+	newEmail = strings.TrimSpace(newEmail)
+
+	var username, firstName, currentEmail string
+	err := s.db.QueryRow(`
+		SELECT username, first_name, email
+		FROM users_application WHERE user_apps_id = $1`, userID,
+	).Scan(&username, &firstName, &currentEmail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("failed to look up account: %w", err)
+	}
+	if strings.EqualFold(currentEmail, newEmail) {
+		return ErrEmailUnchanged
+	}
+
+	taken, err := s.emailTaken(s.db, userID, newEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	if _, err := s.db.Exec(`UPDATE users_application SET pending_email = $1 WHERE user_apps_id = $2`, newEmail, userID); err != nil {
+		return fmt.Errorf("failed to store pending email: %w", err)
+	}
+	if err := s.issueToken(userID, username, firstName, newEmail); err != nil {
+		return err
+	}
+
+	s.send(userID, currentEmail, emailChangeRequestTemplate, map[string]string{
+		"username":   username,
+		"first_name": firstName,
+		"new_email":  newEmail,
+	})
+	s.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityEmailChangeRequested,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: "Email change to " + newEmail + " requested",
+	})
+	return nil
+}
+
+func (s *EmailVerificationService) issueToken(userID int, username, firstName, email string) error {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	ttl := s.tokenTTL()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Only the newest link for this address stays valid. A link sent to
+	// another address still verifies it while it is the current or pending
+	// one, so a pending change does not invalidate the current address
+	_, err = tx.Exec(`
+		UPDATE email_verification_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND LOWER(email) = LOWER($2) AND used_at IS NULL`, userID, email)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate previous tokens: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO email_verification_tokens (user_id, email, token, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`,
+		userID, email, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.send(userID, email, emailVerificationTemplate, map[string]string{
+		"username":          username,
+		"first_name":        firstName,
+		"email":             email,
+		"verification_link": s.appBaseURL + "/verify-email?token=" + url.QueryEscape(token),
+		"expires_in_hours":  strconv.Itoa(int(ttl.Hours())),
+	})
+	return nil
+}
+
+// send mails in the background; a slow SMTP server must not hold the request.
+func (s *EmailVerificationService) send(userID int, to, template string, variables map[string]string) {
+	go func() {
+		if err := s.email.SendTemplate(to, template, variables); err != nil {
+			log.Printf("%s email for user %d failed: %v", template, userID, err)
+		}
+	}()
+}
+
+// Verify consumes a token. A token for the current address marks it
+// verified; a token for the pending address makes it the account's address.
+func (s *EmailVerificationService) Verify(token, ipAddress, userAgent string) (*VerifiedEmail, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	var tokenEmail, username, currentEmail string
+	var pendingEmail sql.NullString
+	err = tx.QueryRow(`
+		SELECT t.id, t.user_id, t.email, u.username, u.email, u.pending_email
+		FROM email_verification_tokens t
+		JOIN users_application u ON u.user_apps_id = t.user_id
+		WHERE t.token = $1 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE`, hashToken(token),
+	).Scan(&tokenID, &userID, &tokenEmail, &username, &currentEmail, &pendingEmail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, fmt.Errorf("failed to load verification token: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE email_verification_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID); err != nil {
+		return nil, fmt.Errorf("failed to consume verification token: %w", err)
+	}
+
+	result := &VerifiedEmail{Email: tokenEmail}
+	action := ActivityEmailVerified
+	switch {
+	case strings.EqualFold(tokenEmail, currentEmail):
+		_, err = tx.Exec(`
+			UPDATE users_application SET email_verified_at = CURRENT_TIMESTAMP
+			WHERE user_apps_id = $1`, userID)
+	case pendingEmail.Valid && strings.EqualFold(tokenEmail, pendingEmail.String):
+		taken, takenErr := s.emailTaken(tx, userID, tokenEmail)
+		if takenErr != nil {
+			return nil, takenErr
+		}
+		if taken {
+			return nil, ErrEmailTaken
+		}
+		_, err = tx.Exec(`
+			UPDATE users_application
+			SET email = $1, pending_email = NULL, email_verified_at = CURRENT_TIMESTAMP,
+			    updated_by = 'email_change', updated_at = CURRENT_TIMESTAMP
+			WHERE user_apps_id = $2`, tokenEmail, userID)
+		result.Changed = true
+		action = ActivityEmailChanged
+	default:
+		// The address was changed again after the link was sent
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	description := "Email address " + tokenEmail + " verified"
+	if result.Changed {
+		description = "Email changed from " + currentEmail + " to " + tokenEmail
+	}
+	s.activity.Record(ActivityLogEntry{
+		Actor:       ActivityActor{UserID: userID, Username: username, IPAddress: ipAddress, UserAgent: userAgent},
+		Action:      action,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: description,
+	})
+	return result, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *EmailVerificationService) emailTaken(db queryRower, userID int, email string) (bool, error) {
+	var taken bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM users_application
+			WHERE LOWER(email) = LOWER($1) AND user_apps_id <> $2
+		)`, email, userID).Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("failed to check email: %w", err)
+	}
+	return taken, nil
+}
+
+// emailVerificationPending reports whether the user has to verify their
+// address before a regular session is opened.
+func (s *AuthService) emailVerificationPending(userID int) (bool, error) {
+	if !s.settings.GetBool("security.require_verified_email", false) {
+		return false, nil
+	}
+	var pending bool
+	err := s.db.QueryRow(`
+		SELECT email_verified_at IS NULL AND NOT is_service_account
+		FROM users_application WHERE user_apps_id = $1`, userID).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("failed to check email verification: %w", err)
+	}
+	return pending, nil
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing or
+// verification.
+const minRSAKeyBits = 2048
+
+// jwtKey is a key usable for verifying tokens and, when private is set, for
+// signing them.
+type jwtKey struct {
+	id      string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.Signer
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet holds the key used to sign new tokens and every key that is still
+// accepted for verification. During a rotation the previous key stays in the
+// verification set until the tokens it signed have expired.
+type KeySet struct {
+	signing      *jwtKey
+	verification map[string]*jwtKey
+	order        []string
+}
+
+// KeySetOptions lists the PEM files that make up a KeySet. SigningKeyFile holds
+// an RSA or Ed25519 private key; VerificationKeyFiles may hold public or
+// private keys of previous (or upcoming) signing keys.
+type KeySetOptions struct {
+	SigningKeyFile       string
+	VerificationKeyFiles []string
+	// AllowEphemeral permits running without SigningKeyFile, for development.
+	AllowEphemeral bool
+}
+
+// ErrNoSigningKey is returned by LoadKeySet when no signing key file is
+// configured and an ephemeral key is not allowed.
+var ErrNoSigningKey = errors.New("no JWT signing key file configured")
+
+// LoadKeySet reads the configured PEM files. Without a signing key file an
+// ephemeral Ed25519 key is generated if AllowEphemeral is set. That is only
+// suitable for development: tokens do not survive a restart and no other
+// service can share the key.
+func LoadKeySet(options KeySetOptions) (*KeySet, error) {
+	var signing *jwtKey
+	if options.SigningKeyFile == "" {
+		if !options.AllowEphemeral {
+			return nil, ErrNoSigningKey
+		}
+		log.Println("JWT_SIGNING_KEY_FILE not set, using an ephemeral Ed25519 signing key")
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+		signing, err = newJWTKey(private)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		key, err := loadPEMKey(options.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key.private == nil {
+			return nil, fmt.Errorf("%s: signing key must be a private key", options.SigningKeyFile)
+		}
+		signing = key
+	}
+
+	set := &KeySet{signing: signing, verification: map[string]*jwtKey{}}
+	set.add(signing)
+
+	for _, file := range options.VerificationKeyFiles {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		key, err := loadPEMKey(file)
+		if err != nil {
+			return nil, err
+		}
+		set.add(key)
+	}
+	return set, nil
+}
+
+func (ks *KeySet) add(key *jwtKey) {
+	if _, exists := ks.verification[key.id]; exists {
+		return
+	}
+	ks.verification[key.id] = key
+	ks.order = append(ks.order, key.id)
+}
+
+// Sign signs claims with the current signing key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.id
+	return token.SignedString(ks.signing.private)
+}
+
+// Keyfunc resolves the verification key for a token by its kid header and
+// rejects tokens whose alg does not match that key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	key, ok := ks.verification[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// ValidMethods lists the algorithms of all verification keys, for use with
+// jwt.WithValidMethods.
+func (ks *KeySet) ValidMethods() []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, id := range ks.order {
+		alg := ks.verification[id].method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWKS returns the public halves of every verification key, signing key first.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.order))}
+	for _, id := range ks.order {
+		jwks.Keys = append(jwks.Keys, ks.verification[id].jwk())
+	}
+	return jwks
+}
+
+func (k *jwtKey) jwk() JWK {
+	jwk := JWK{KeyID: k.id, Use: "sig", Algorithm: k.method.Alg()}
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as kid, so the same key
+// gets the same id on every instance without extra configuration.
+func (k *jwtKey) thumbprint() (string, error) {
+	jwk := k.jwk()
+
+	var members map[string]string
+	switch jwk.KeyType {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.KeyType, "n": jwk.N}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Curve, "kty": jwk.KeyType, "x": jwk.X}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+
+	// encoding/json sorts map keys, which gives the required member order
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func newJWTKey(key interface{}) (*jwtKey, error) {
+	k := &jwtKey{}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.public, k.private = jwt.SigningMethodRS256, &key.PublicKey, key
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.method, k.public, k.private = jwt.SigningMethodEdDSA, key.Public(), key
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", key)
+	}
+
+	if public, ok := k.public.(*rsa.PublicKey); ok && public.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key is %d bits, at least %d required", public.N.BitLen(), minRSAKeyBits)
+	}
+
+	id, err := k.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	k.id = id
+	return k, nil
+}
+
+// loadPEMKey reads the first key block of a PEM file. PKCS#8 and PKCS#1
+// private keys and PKIX and PKCS#1 public keys are accepted.
+func loadPEMKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM key block found", path)
+		}
+
+		var key interface{}
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		jwtKey, err := newJWTKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return jwtKey, nil
+	}
+}
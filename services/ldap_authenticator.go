@@ -0,0 +1,197 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConn is the part of *ldap.Conn the authenticator uses. Tests and local
+// development can supply an in-process implementation through LDAPOptions.Dial.
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	StartTLS(config *tls.Config) error
+	Close() error
+}
+
+// LDAPOptions configures one LDAP or Active Directory server.
+type LDAPOptions struct {
+	Name     string
+	URL      string
+	StartTLS bool
+	// BindDN and BindPassword are the service account used to find users.
+	// Leave empty for servers that allow anonymous search.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter has a single %s for the escaped username, e.g.
+	// "(uid=%s)" for OpenLDAP or "(sAMAccountName=%s)" for Active Directory.
+	UserFilter         string
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	GroupAttribute     string
+	// GroupRoles maps a group DN or CN (case-insensitive) to a role code.
+	GroupRoles   map[string]string
+	Provisioning ProvisioningOptions
+	Dial         func(url string) (LDAPConn, error)
+}
+
+// LDAPAuthenticator verifies passwords by binding as the user: it looks the
+// user up with the service account, then binds with the user's DN and the
+// supplied password.
+type LDAPAuthenticator struct {
+	options LDAPOptions
+}
+
+func NewLDAPAuthenticator(options LDAPOptions) *LDAPAuthenticator {
+	if options.UserFilter == "" {
+		options.UserFilter = "(uid=%s)"
+	}
+	if options.EmailAttribute == "" {
+		options.EmailAttribute = "mail"
+	}
+	if options.FirstNameAttribute == "" {
+		options.FirstNameAttribute = "givenName"
+	}
+	if options.LastNameAttribute == "" {
+		options.LastNameAttribute = "sn"
+	}
+	if options.GroupAttribute == "" {
+		options.GroupAttribute = "memberOf"
+	}
+	if options.Dial == nil {
+		options.Dial = dialLDAP
+	}
+
+	groupRoles := make(map[string]string, len(options.GroupRoles))
+	managed := map[string]bool{}
+	for group, role := range options.GroupRoles {
+		groupRoles[strings.ToLower(strings.TrimSpace(group))] = role
+		if !managed[role] {
+			managed[role] = true
+			options.Provisioning.ManagedRoles = append(options.Provisioning.ManagedRoles, role)
+		}
+	}
+	options.GroupRoles = groupRoles
+
+	return &LDAPAuthenticator{options: options}
+}
+
+func dialLDAP(url string) (LDAPConn, error) {
+	return ldap.DialURL(url)
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return a.options.Name
+}
+
+func (a *LDAPAuthenticator) Provisioning() ProvisioningOptions {
+	return a.options.Provisioning
+}
+
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*AuthenticatedIdentity, error) {
+	// An empty password would be an unauthenticated bind, which most servers
+	// accept without checking anything
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.options.Dial(a.options.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap %s: failed to connect: %v", a.options.Name, err)
+	}
+	defer conn.Close()
+
+	if a.options.StartTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: ldapHost(a.options.URL)}); err != nil {
+			return nil, fmt.Errorf("ldap %s: StartTLS failed: %v", a.options.Name, err)
+		}
+	}
+
+	if a.options.BindDN != "" {
+		if err := conn.Bind(a.options.BindDN, a.options.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap %s: service bind failed: %v", a.options.Name, err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.options.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.options.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.options.EmailAttribute, a.options.FirstNameAttribute, a.options.LastNameAttribute, a.options.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap %s: user search failed: %v", a.options.Name, err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap %s: user bind failed: %v", a.options.Name, err)
+	}
+
+	identity := &AuthenticatedIdentity{
+		Username:  username,
+		Email:     entry.GetAttributeValue(a.options.EmailAttribute),
+		FirstName: entry.GetAttributeValue(a.options.FirstNameAttribute),
+		LastName:  entry.GetAttributeValue(a.options.LastNameAttribute),
+		Groups:    entry.GetAttributeValues(a.options.GroupAttribute),
+	}
+	identity.Roles = a.mapRoles(identity.Groups)
+	return identity, nil
+}
+
+// mapRoles returns the role codes granted by the given group DNs. A group
+// matches a mapping by full DN or by its CN.
+func (a *LDAPAuthenticator) mapRoles(groups []string) []string {
+	seen := map[string]bool{}
+	var roles []string
+	for _, group := range groups {
+		keys := []string{strings.ToLower(group)}
+		if cn := groupCN(group); cn != "" {
+			keys = append(keys, strings.ToLower(cn))
+		}
+		for _, key := range keys {
+			if role, ok := a.options.GroupRoles[key]; ok && !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+func groupCN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, attribute := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attribute.Type, "cn") {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+func ldapHost(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
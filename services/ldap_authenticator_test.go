@@ -0,0 +1,235 @@
+package services
+
+import (
+	"crypto/tls"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	stubServiceDN = "cn=reader,dc=corp,dc=example"
+	stubUserDN    = "uid=alice,ou=people,dc=corp,dc=example"
+)
+
+// stubLDAPConn is an in-process directory holding one user.
+type stubLDAPConn struct {
+	passwords map[string]string
+	entry     *ldap.Entry
+	binds     []string
+	filters   []string
+	closed    bool
+}
+
+func newStubLDAPConn(groups ...string) *stubLDAPConn {
+	return &stubLDAPConn{
+		passwords: map[string]string{
+			stubServiceDN: "reader-secret",
+			stubUserDN:    "alice-secret",
+		},
+		entry: ldap.NewEntry(stubUserDN, map[string][]string{
+			"mail":      {"alice@corp.example"},
+			"givenName": {"Alice"},
+			"sn":        {"Liddell"},
+			"memberOf":  groups,
+		}),
+	}
+}
+
+func (c *stubLDAPConn) Bind(username, password string) error {
+	c.binds = append(c.binds, username)
+	if want, ok := c.passwords[username]; !ok || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (c *stubLDAPConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.filters = append(c.filters, request.Filter)
+	if request.Filter != "(uid=alice)" {
+		return &ldap.SearchResult{}, nil
+	}
+	return &ldap.SearchResult{Entries: []*ldap.Entry{c.entry}}, nil
+}
+
+func (c *stubLDAPConn) StartTLS(config *tls.Config) error {
+	return nil
+}
+
+func (c *stubLDAPConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newStubLDAPAuthenticator(conn *stubLDAPConn, groupRoles map[string]string, provisioning ProvisioningOptions) *LDAPAuthenticator {
+	return NewLDAPAuthenticator(LDAPOptions{
+		Name:         "corp",
+		URL:          "ldap://ldap.corp.example",
+		BindDN:       stubServiceDN,
+		BindPassword: "reader-secret",
+		BaseDN:       "dc=corp,dc=example",
+		GroupRoles:   groupRoles,
+		Provisioning: provisioning,
+		Dial:         func(string) (LDAPConn, error) { return conn, nil },
+	})
+}
+
+func TestLDAPAuthenticatorBindsAsUser(t *testing.T) {
+	conn := newStubLDAPConn()
+	authenticator := newStubLDAPAuthenticator(conn, nil, ProvisioningOptions{})
+
+	identity, err := authenticator.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if want := []string{stubServiceDN, stubUserDN}; !reflect.DeepEqual(conn.binds, want) {
+		t.Errorf("binds = %v, want %v", conn.binds, want)
+	}
+	if !conn.closed {
+		t.Error("connection not closed")
+	}
+	if identity.Username != "alice" || identity.Email != "alice@corp.example" ||
+		identity.FirstName != "Alice" || identity.LastName != "Liddell" {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	if _, err := authenticator.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := authenticator.Authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("empty password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	// The username is escaped, so it cannot widen the filter
+	if _, err := authenticator.Authenticate("*)(uid=alice", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("filter injection error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if got := conn.filters[len(conn.filters)-1]; got != `(uid=\2a\29\28uid=alice)` {
+		t.Errorf("filter = %q", got)
+	}
+}
+
+func TestLDAPAuthenticatorMapsGroupsToRoles(t *testing.T) {
+	conn := newStubLDAPConn(
+		"CN=Admins,OU=Groups,DC=corp,DC=example",
+		"cn=staff,ou=groups,dc=corp,dc=example",
+		"cn=unmapped,ou=groups,dc=corp,dc=example",
+	)
+	authenticator := newStubLDAPAuthenticator(conn, map[string]string{
+		"admins":                                "ADMIN",
+		"cn=staff,ou=groups,dc=corp,dc=example": "USER",
+		"Staff":                                 "USER",
+		"auditor":                               "AUDITOR",
+	}, ProvisioningOptions{})
+
+	identity, err := authenticator.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if want := []string{"ADMIN", "USER"}; !reflect.DeepEqual(identity.Roles, want) {
+		t.Errorf("roles = %v, want %v", identity.Roles, want)
+	}
+
+	managed := map[string]bool{}
+	for _, role := range authenticator.Provisioning().ManagedRoles {
+		managed[role] = true
+	}
+	if want := map[string]bool{"ADMIN": true, "USER": true, "AUDITOR": true}; !reflect.DeepEqual(managed, want) {
+		t.Errorf("managed roles = %v, want %v", managed, want)
+	}
+}
+
+// bcryptCost matches a bcrypt hash of the given cost.
+type bcryptCost int
+
+func (c bcryptCost) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	if !ok {
+		return false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == int(c)
+}
+
+func TestLDAPLoginProvisionsUserJustInTime(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	keys, err := LoadKeySet(KeySetOptions{AllowEphemeral: true})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	conn := newStubLDAPConn("cn=staff,ou=groups,dc=corp,dc=example")
+	chain := NewAuthenticatorChain(NewLocalAuthenticator(db, nil))
+	if err := chain.Register(newStubLDAPAuthenticator(conn, map[string]string{"staff": "USER"},
+		ProvisioningOptions{JIT: true, DefaultStatusID: 2}), []string{"corp.example"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	s := NewAuthService(db, AuthOptions{Keys: keys, Authenticators: chain})
+
+	accountColumns := []string{"user_apps_id", "username", "auth_provider", "is_active", "is_service_account", "locked_until"}
+	mock.ExpectQuery(`SELECT user_apps_id, username`).WithArgs("alice@corp.example").
+		WillReturnRows(sqlmock.NewRows(accountColumns))
+	mock.ExpectQuery(`SELECT user_apps_id, username`).WithArgs("alice", "corp").
+		WillReturnRows(sqlmock.NewRows(accountColumns))
+
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs("alice", "alice@corp.example").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	// The placeholder password is hashed with the configured algorithm
+	for _, setting := range []struct{ key, value string }{
+		{"password.hash_algorithm", "bcrypt"},
+		{"password.bcrypt_cost", "4"},
+		{"password.argon2_memory_kib", ""},
+		{"password.argon2_iterations", ""},
+		{"password.argon2_parallelism", ""},
+	} {
+		rows := sqlmock.NewRows([]string{"setting_value"})
+		if setting.value != "" {
+			rows.AddRow(setting.value)
+		}
+		mock.ExpectQuery(`SELECT setting_value FROM system_settings`).WithArgs(setting.key).WillReturnRows(rows)
+	}
+	mock.ExpectQuery(`INSERT INTO users_application`).
+		WithArgs("alice", "alice@corp.example", bcryptCost(4), "Alice", "Liddell", 2, "corp", "provisioning:corp").
+		WillReturnRows(sqlmock.NewRows([]string{"user_apps_id"}).AddRow(42))
+	mock.ExpectExec(`INSERT INTO users_activity_logs`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE user_roles ur SET is_active = false`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE user_roles ur SET is_active = true`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO user_roles`).WithArgs(42, pq.Array([]string{"USER"}), pq.Array([]string{"USER"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery(`SELECT totp_enabled`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_enabled"}).AddRow(false))
+	mock.ExpectExec(`SET failed_login_attempts = 0`).WithArgs(42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE users_application SET last_login_at`).WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "user_info"}).AddRow("alice", []byte(`{}`)))
+	mock.ExpectExec(`INSERT INTO users_activity_logs`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := s.authenticatePassword("alice@corp.example", "alice-secret", "203.0.113.5", "test")
+	if err != nil {
+		t.Fatalf("authenticatePassword: %v", err)
+	}
+	if !result.Success || result.UserID != 42 {
+		t.Fatalf("result = %+v, want a successful login of user 42", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
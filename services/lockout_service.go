@@ -0,0 +1,250 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	ActivityAccountLocked   = "ACCOUNT_LOCKED"
+	ActivityAccountUnlocked = "ACCOUNT_UNLOCKED"
+)
+
+// ErrAccountLocked is returned when a locked account tries a credential.
+var ErrAccountLocked = errors.New("too many failed attempts, account is temporarily locked")
+
+// indefiniteLock is stored in locked_until for administrator locks without a
+// duration. It stays in the future until the account is unlocked explicitly.
+var indefiniteLock = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// LockoutPolicy controls automatic lockout after failed logins. Every lock
+// after the first lasts BackoffMultiplier times longer than the previous
+// one, up to MaxDuration, until a successful login resets the count.
+type LockoutPolicy struct {
+	MaxAttempts       int
+	Duration          time.Duration
+	BackoffMultiplier float64
+	MaxDuration       time.Duration
+}
+
+// LockoutService is the single owner of failed_login_attempts, locked_until
+// and lockout_count on users_application.
+type LockoutService struct {
+	db       *sql.DB
+	settings *SettingsService
+	activity *ActivityLogService
+}
+
+func NewLockoutService(db *sql.DB, settings *SettingsService, activity *ActivityLogService) *LockoutService {
+	return &LockoutService{db: db, settings: settings, activity: activity}
+}
+
+func (s *LockoutService) Policy() LockoutPolicy {
+	policy := LockoutPolicy{
+		MaxAttempts:       s.settings.GetInt("security.lockout_max_attempts", 5),
+		Duration:          time.Duration(s.settings.GetInt("security.lockout_duration_minutes", 15)) * time.Minute,
+		BackoffMultiplier: s.settings.GetFloat("security.lockout_backoff_multiplier", 2),
+		MaxDuration:       time.Duration(s.settings.GetInt("security.lockout_max_duration_minutes", 1440)) * time.Minute,
+	}
+
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Duration <= 0 {
+		policy.Duration = 15 * time.Minute
+	}
+	if policy.BackoffMultiplier < 1 {
+		policy.BackoffMultiplier = 1
+	}
+	if policy.MaxDuration < policy.Duration {
+		policy.MaxDuration = policy.Duration
+	}
+	return policy
+}
+
+// lockDuration returns how long the lockoutCount-th lock lasts.
+func (p LockoutPolicy) lockDuration(lockoutCount int) time.Duration {
+	if lockoutCount < 1 {
+		lockoutCount = 1
+	}
+	factor := math.Pow(p.BackoffMultiplier, float64(lockoutCount-1))
+	duration := time.Duration(float64(p.Duration) * factor)
+	if duration <= 0 || duration > p.MaxDuration {
+		return p.MaxDuration
+	}
+	return duration
+}
+
+// LockedUntil returns the end of the user's current lock, or nil when the
+// account is not locked.
+func (s *LockoutService) LockedUntil(userID int) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow(`SELECT locked_until FROM users_application WHERE user_apps_id = $1`, userID).Scan(&lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if !lockedUntil.Valid || !lockedUntil.Time.After(time.Now()) {
+		return nil, nil
+	}
+	return &lockedUntil.Time, nil
+}
+
+// RecordFailure counts a failed login for the user and locks the account once
+// the policy threshold is reached. It returns the lock end when a lock was
+// applied.
+func (s *LockoutService) RecordFailure(userID int, actor ActivityActor) (*time.Time, error) {
+	policy := s.Policy()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var attempts, lockoutCount int
+	err = tx.QueryRow(`
+		SELECT COALESCE(failed_login_attempts, 0), COALESCE(lockout_count, 0)
+		FROM users_application WHERE user_apps_id = $1
+		FOR UPDATE`, userID).Scan(&attempts, &lockoutCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lockout state: %w", err)
+	}
+
+	attempts++
+	if attempts < policy.MaxAttempts {
+		if _, err := tx.Exec(`UPDATE users_application SET failed_login_attempts = $1 WHERE user_apps_id = $2`, attempts, userID); err != nil {
+			return nil, fmt.Errorf("failed to record failed login: %w", err)
+		}
+		return nil, tx.Commit()
+	}
+
+	lockoutCount++
+	duration := policy.lockDuration(lockoutCount)
+	lockedUntil := time.Now().Add(duration)
+	_, err = tx.Exec(`
+		UPDATE users_application
+		SET failed_login_attempts = 0, lockout_count = $1, locked_until = $2
+		WHERE user_apps_id = $3`, lockoutCount, lockedUntil, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityAccountLocked,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: fmt.Sprintf("Account locked for %s after %d failed login attempts", duration, attempts),
+		RequestData: map[string]interface{}{
+			"automatic":     true,
+			"lockout_count": lockoutCount,
+			"locked_until":  lockedUntil,
+		},
+	})
+	return &lockedUntil, nil
+}
+
+// RecordSuccess clears failed attempts and the backoff count after a
+// successful login.
+func (s *LockoutService) RecordSuccess(userID int) error {
+	_, err := s.db.Exec(`
+		UPDATE users_application
+		SET failed_login_attempts = 0, lockout_count = 0, locked_until = NULL
+		WHERE user_apps_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to reset lockout state: %w", err)
+	}
+	return nil
+}
+
+// Lock locks the account on behalf of an administrator. A zero duration locks
+// it until Unlock is called. Active sessions of the user are ended and failed
+// attempts made before the lock no longer count once it ends.
+func (s *LockoutService) Lock(userID int, duration time.Duration, reason string, actor ActivityActor) (time.Time, error) {
+	lockedUntil := indefiniteLock
+	if duration > 0 {
+		lockedUntil = time.Now().Add(duration)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users_application
+		SET locked_until = $1, failed_login_attempts = 0,
+		    updated_by = NULLIF($2, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $3`, lockedUntil, actor.Username, userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to lock account: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return time.Time{}, sql.ErrNoRows
+	}
+
+	if err := revokeUserSessionsTx(tx, userID, 0); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	description := "Account locked by administrator"
+	if reason != "" {
+		description += ": " + reason
+	}
+	s.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityAccountLocked,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: description,
+		RequestData: map[string]interface{}{
+			"automatic":    false,
+			"reason":       reason,
+			"locked_until": lockedUntil,
+		},
+	})
+	return lockedUntil, nil
+}
+
+// Unlock clears any lock, the failed attempt counter and the backoff count.
+func (s *LockoutService) Unlock(userID int, reason string, actor ActivityActor) error {
+	result, err := s.db.Exec(`
+		UPDATE users_application
+		SET failed_login_attempts = 0, lockout_count = 0, locked_until = NULL,
+		    updated_by = NULLIF($1, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $2`, actor.Username, userID)
+	if err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	description := "Account unlocked by administrator"
+	if reason != "" {
+		description += ": " + reason
+	}
+	s.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityAccountUnlocked,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: description,
+		RequestData: map[string]interface{}{
+			"reason": reason,
+		},
+	})
+	return nil
+}
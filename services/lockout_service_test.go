@@ -0,0 +1,74 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newTestLockoutService(t *testing.T) (*LockoutService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewLockoutService(db, NewSettingsService(db), NewActivityLogService(db)), mock
+}
+
+func TestLockUnlockThenFailureStartsCountingAfresh(t *testing.T) {
+	lockout, mock := newTestLockoutService(t)
+	admin := ActivityActor{UserID: 1, Username: "admin"}
+
+	// Lock: the attempts made before the lock are cleared with it
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET locked_until = \$1, failed_login_attempts = 0,`).
+		WithArgs(sqlmock.AnyArg(), "admin", 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_refresh_tokens`).WithArgs(42, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE user_sessions`).WithArgs(42, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO users_activity_logs`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// Unlock
+	mock.ExpectExec(`SET failed_login_attempts = 0, lockout_count = 0, locked_until = NULL`).
+		WithArgs("admin", 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO users_activity_logs`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// A wrong password afterwards is the first failed attempt, not another
+	// step towards the lock the administrator just lifted
+	for _, key := range []string{
+		"security.lockout_max_attempts", "security.lockout_duration_minutes",
+		"security.lockout_backoff_multiplier", "security.lockout_max_duration_minutes",
+	} {
+		mock.ExpectQuery(`SELECT setting_value FROM system_settings`).WithArgs(key).
+			WillReturnRows(sqlmock.NewRows([]string{"setting_value"}))
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COALESCE\(failed_login_attempts, 0\), COALESCE\(lockout_count, 0\)`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts", "lockout_count"}).AddRow(0, 0))
+	mock.ExpectExec(`UPDATE users_application SET failed_login_attempts = \$1`).
+		WithArgs(1, 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := lockout.Lock(42, time.Hour, "investigation", admin); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := lockout.Unlock(42, "resolved", admin); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	lockedUntil, err := lockout.RecordFailure(42, ActivityActor{Username: "alice"})
+	if err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	if lockedUntil != nil {
+		t.Errorf("RecordFailure locked the account until %v after a single failure", lockedUntil)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	mfaIssuer          = "SPA System"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrMFANotEnrolled      = errors.New("two-factor authentication is not enrolled")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFAInvalidCode      = errors.New("invalid two-factor authentication code")
+	ErrMFARequiredForRoles = errors.New("two-factor authentication is required for your role")
+)
+
+// MFAEnrollment is returned when a user starts TOTP enrolment.
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// MFAService manages TOTP enrolment, verification and recovery codes for
+// users_application rows.
+type MFAService struct {
+	db       *sql.DB
+	settings *SettingsService
+	lockout  *LockoutService
+}
+
+func NewMFAService(db *sql.DB, settings *SettingsService) *MFAService {
+	return &MFAService{
+		db:       db,
+		settings: settings,
+		lockout:  NewLockoutService(db, settings, NewActivityLogService(db)),
+	}
+}
+
+// IsEnabled reports whether the user has a confirmed TOTP enrolment.
+func (s *MFAService) IsEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(`SELECT totp_enabled FROM users_application WHERE user_apps_id = $1`, userID).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("failed to read two-factor status: %w", err)
+	}
+	return enabled, nil
+}
+
+// IsRequired reports whether one of the user's active roles is listed in the
+// security.mfa_required_roles setting (comma separated role codes).
+func (s *MFAService) IsRequired(userID int) (bool, error) {
+	roleCodes := s.settings.GetStringList("security.mfa_required_roles", nil)
+	if len(roleCodes) == 0 {
+		return false, nil
+	}
+
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM user_roles ur
+			JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+			WHERE ur.user_id = $1 AND ur.is_active = true AND r.roles_code = ANY($2)
+		)
+	`
+	var required bool
+	if err := s.db.QueryRow(query, userID, pq.Array(roleCodes)).Scan(&required); err != nil {
+		return false, fmt.Errorf("failed to check two-factor requirement: %w", err)
+	}
+	return required, nil
+}
+
+// Enroll generates a new, unconfirmed TOTP secret for the user. Calling it
+// again before confirmation replaces the pending secret.
+func (s *MFAService) Enroll(userID int) (*MFAEnrollment, error) {
+	var username string
+	var enabled bool
+	err := s.db.QueryRow(`SELECT username, totp_enabled FROM users_application WHERE user_apps_id = $1`, userID).Scan(&username, &enabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users_application
+		SET totp_secret = $1, totp_enabled = false, totp_confirmed_at = NULL, totp_last_used_step = NULL
+		WHERE user_apps_id = $2`, secret, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return &MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURL: totpProvisioningURI(mfaIssuer, username, secret),
+	}, nil
+}
+
+// Confirm enables TOTP once the user proves the authenticator app works, and
+// returns a fresh set of one-time recovery codes. The codes are only shown here.
+func (s *MFAService) Confirm(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	err = tx.QueryRow(`SELECT totp_secret, totp_enabled FROM users_application WHERE user_apps_id = $1 FOR UPDATE`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, ok := verifyTOTP(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users_application
+		SET totp_enabled = true, totp_confirmed_at = CURRENT_TIMESTAMP, totp_last_used_step = $1
+		WHERE user_apps_id = $2`, step, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable removes the TOTP enrolment of the user in actor after checking a
+// current code or an unused recovery code. Users whose role requires 2FA
+// cannot disable it.
+func (s *MFAService) Disable(actor ActivityActor, code, recoveryCode string) error {
+	userID := actor.UserID
+	required, err := s.IsRequired(userID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequiredForRoles
+	}
+
+	if err := s.verifyAttempt(actor, code, recoveryCode); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users_application
+		SET totp_secret = NULL, totp_enabled = false, totp_confirmed_at = NULL, totp_last_used_step = NULL
+		WHERE user_apps_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Verify checks a TOTP code, or consumes a recovery code when code is empty.
+// A TOTP code can only be used once.
+func (s *MFAService) Verify(userID int, code, recoveryCode string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	var lastStep sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT totp_secret, totp_enabled, totp_last_used_step
+		FROM users_application WHERE user_apps_id = $1 FOR UPDATE`, userID).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if !enabled || !secret.Valid {
+		return ErrMFANotEnrolled
+	}
+
+	if strings.TrimSpace(code) != "" {
+		step, ok := verifyTOTP(secret.String, code, time.Now())
+		if !ok || (lastStep.Valid && step <= lastStep.Int64) {
+			return ErrMFAInvalidCode
+		}
+		if _, err := tx.Exec(`UPDATE users_application SET totp_last_used_step = $1 WHERE user_apps_id = $2`, step, userID); err != nil {
+			return fmt.Errorf("failed to record TOTP usage: %w", err)
+		}
+	} else {
+		result, err := tx.Exec(`
+			UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+			userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return fmt.Errorf("failed to consume recovery code: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return ErrMFAInvalidCode
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// verifyAttempt runs Verify for the user in actor under the account lockout:
+// a locked account is refused without checking the code, and a wrong code
+// counts as a failed attempt.
+func (s *MFAService) verifyAttempt(actor ActivityActor, code, recoveryCode string) error {
+	lockedUntil, err := s.lockout.LockedUntil(actor.UserID)
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil {
+		return ErrAccountLocked
+	}
+
+	err = s.Verify(actor.UserID, code, recoveryCode)
+	if !errors.Is(err, ErrMFAInvalidCode) {
+		return err
+	}
+	locked, lockErr := s.lockout.RecordFailure(actor.UserID, actor)
+	if lockErr != nil {
+		return lockErr
+	}
+	if locked != nil {
+		return ErrAccountLocked
+	}
+	return err
+}
+
+// RegenerateRecoveryCodes invalidates the recovery codes of the user in
+// actor after checking a current TOTP code, and returns a new set.
+func (s *MFAService) RegenerateRecoveryCodes(actor ActivityActor, code string) ([]string, error) {
+	userID := actor.UserID
+	if err := s.verifyAttempt(actor, code, ""); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodesTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodesTx deletes the user's recovery codes and stores a new
+// hashed set, returning the plain codes.
+func replaceRecoveryCodesTx(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code such as "k7xq2-m9vbt" from an
+// unambiguous lowercase alphabet.
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	buf := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %v", err)
+	}
+	for i := range buf {
+		buf[i] = alphabet[int(buf[i])%len(alphabet)]
+	}
+	half := recoveryCodeLength / 2
+	return string(buf[:half]) + "-" + string(buf[half:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
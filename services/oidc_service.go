@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCStateTTL bounds the time between redirecting to the provider and the
+// callback.
+const OIDCStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownOIDCProvider  = errors.New("unknown identity provider")
+	ErrInvalidOIDCState     = errors.New("invalid or expired login state")
+	ErrOIDCAccountNotLinked = errors.New("no active account is linked to this identity")
+)
+
+// OIDCProviderOptions configures one OpenID Connect identity provider.
+type OIDCProviderOptions struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// oidcIdentityClaims are the ID token claims used to find the local account.
+type oidcIdentityClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcProvider caches provider discovery, which needs a round trip to the
+// issuer and is done on first use rather than at startup.
+type oidcProvider struct {
+	options  OIDCProviderOptions
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// OIDCService implements the authorization code flow with PKCE as a relying
+// party. Login state (state, nonce and PKCE verifier) is kept in
+// oidc_login_states so any instance can handle the callback.
+type OIDCService struct {
+	db        *sql.DB
+	auth      *AuthService
+	providers map[string]*oidcProvider
+}
+
+func NewOIDCService(db *sql.DB, auth *AuthService, providers []OIDCProviderOptions) *OIDCService {
+	service := &OIDCService{db: db, auth: auth, providers: map[string]*oidcProvider{}}
+	for _, options := range providers {
+		if len(options.Scopes) == 0 {
+			options.Scopes = []string{"email", "profile"}
+		}
+		service.providers[options.Name] = &oidcProvider{options: options}
+	}
+	return service
+}
+
+// Providers returns the names of the configured providers.
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.options.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover provider %s: %w", p.options.Name, err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+func (p *oidcProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range p.options.Scopes {
+		if scope != oidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &oauth2.Config{
+		ClientID:     p.options.ClientID,
+		ClientSecret: p.options.ClientSecret,
+		RedirectURL:  p.options.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+}
+
+func (s *OIDCService) provider(name string) (*oidcProvider, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+	return provider, nil
+}
+
+// AuthorizationURL starts a login with the provider and returns the URL the
+// browser must be sent to, and the state. The caller keeps the state in the
+// browser, so that the callback can only complete a login the same browser
+// started.
+func (s *OIDCService) AuthorizationURL(ctx context.Context, providerName string, rememberMe bool) (string, string, error) {
+	p, err := s.provider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// Expired states are cleaned up opportunistically
+	if _, err := s.db.Exec(`DELETE FROM oidc_login_states WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return "", "", fmt.Errorf("failed to clean up login states: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, remember_me, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		hashToken(state), providerName, nonce, verifier, rememberMe, time.Now().Add(OIDCStateTTL),
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	return p.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), state, nil
+}
+
+// HandleCallback completes the login: it checks the state against the one
+// kept by the browser and consumes it, exchanges the code, verifies the ID
+// token and nonce, resolves the local account and then logs the user in
+// exactly like a password login.
+func (s *OIDCService) HandleCallback(ctx context.Context, providerName, state, browserState, code, ipAddress, userAgent string) (*LoginResponse, error) {
+	p, err := s.provider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	// A callback carrying someone else's state would sign this browser in
+	// to their account
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	var nonce, verifier string
+	var rememberMe bool
+	err = s.db.QueryRow(`
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING nonce, code_verifier, remember_me`,
+		hashToken(state), providerName,
+	).Scan(&nonce, &verifier, &rememberMe)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, fmt.Errorf("failed to load login state: %w", err)
+	}
+
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := p.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("token response did not include an id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.options.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	var claims oidcIdentityClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to read id_token claims: %w", err)
+	}
+
+	actor := ActivityActor{Username: claims.Email, IPAddress: ipAddress, UserAgent: userAgent}
+	userID, err := s.resolveUser(providerName, claims)
+	if err != nil {
+		if errors.Is(err, ErrOIDCAccountNotLinked) {
+			s.auth.activity.Record(ActivityLogEntry{
+				Actor:       actor,
+				Action:      ActivityLoginFailed,
+				Description: fmt.Sprintf("No account linked to %s identity %s", providerName, claims.Subject),
+			})
+		}
+		return nil, err
+	}
+	actor.UserID = userID
+
+	// Locks apply to every sign-in method
+	lockedUntil, err := s.auth.lockout.LockedUntil(userID)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil != nil {
+		s.auth.activity.Record(ActivityLogEntry{Actor: actor, Action: ActivityLoginFailed, Description: "Account is locked"})
+		return &LoginResponse{Success: false, Message: "Account is temporarily locked. Please try again later."}, nil
+	}
+
+	result, err := s.auth.loadLoginResult(userID)
+	if err != nil {
+		return nil, err
+	}
+	actor.Username = result.Username
+	s.auth.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityLogin,
+		Description: "Signed in with " + providerName,
+	})
+
+	return s.auth.completeLogin(result, rememberMe, ipAddress, userAgent)
+}
+
+// resolveUser finds the active account for an external identity. A known
+// (provider, subject) pair wins; otherwise an account with the same email
+// address, verified both by the provider and locally, is linked to the
+// identity on first sign-in.
+func (s *OIDCService) resolveUser(providerName string, claims oidcIdentityClaims) (int, error) {
+	if claims.Subject == "" {
+		return 0, errors.New("id_token has no subject")
+	}
+
+	var userID int
+	err := s.db.QueryRow(`
+		SELECT ui.user_id
+		FROM user_identities ui
+		JOIN users_application u ON u.user_apps_id = ui.user_id
+		WHERE ui.provider = $1 AND ui.subject = $2 AND u.is_active = true AND u.is_service_account = false`,
+		providerName, claims.Subject,
+	).Scan(&userID)
+	if err == nil {
+		_, err = s.db.Exec(`
+			UPDATE user_identities SET email = NULLIF($1, ''), last_login_at = CURRENT_TIMESTAMP
+			WHERE provider = $2 AND subject = $3`, claims.Email, providerName, claims.Subject)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update identity: %w", err)
+		}
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	// Only a verified address may claim an existing account, and only one the
+	// account holder has confirmed too: an unconfirmed local address may have
+	// been entered by someone who does not own it
+	if !claims.EmailVerified || strings.TrimSpace(claims.Email) == "" {
+		return 0, ErrOIDCAccountNotLinked
+	}
+
+	err = s.db.QueryRow(`
+		SELECT user_apps_id FROM users_application
+		WHERE LOWER(email) = LOWER($1) AND email_verified_at IS NOT NULL
+		  AND is_active = true AND is_service_account = false`, claims.Email,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrOIDCAccountNotLinked
+		}
+		return 0, fmt.Errorf("failed to look up user by email: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (provider, subject) DO NOTHING`,
+		userID, providerName, claims.Subject, claims.Email,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to link identity: %w", err)
+	}
+	return userID, nil
+}
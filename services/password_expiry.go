@@ -0,0 +1,153 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ScopePasswordChange marks sessions of users whose password has expired or
+// was flagged by an administrator; they may only change it.
+const ScopePasswordChange = "password_change"
+
+// passwordExpiringNotificationType marks the warnings sent before expiry
+const passwordExpiringNotificationType = "PASSWORD_EXPIRING"
+
+// PasswordExpiryService applies password.max_age_days to local accounts and
+// warns their owners password.expiry_warning_days ahead. Accounts of external
+// authenticators are exempt because their password is not kept here.
+type PasswordExpiryService struct {
+	db       *sql.DB
+	settings *SettingsService
+}
+
+func NewPasswordExpiryService(db *sql.DB, settings *SettingsService) *PasswordExpiryService {
+	return &PasswordExpiryService{db: db, settings: settings}
+}
+
+// MaxAge is the password lifetime; 0 means passwords do not expire.
+func (s *PasswordExpiryService) MaxAge() time.Duration {
+	days := s.settings.GetInt("password.max_age_days", 0)
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ChangeRequired reports whether the user must choose a new password before
+// doing anything else.
+func (s *PasswordExpiryService) ChangeRequired(userID int) (bool, error) {
+	var mustChange bool
+	var changedAt sql.NullTime
+	var provider string
+	err := s.db.QueryRow(`
+		SELECT must_change_password, password_changed_at, COALESCE(auth_provider, $2)
+		FROM users_application WHERE user_apps_id = $1`, userID, LocalAuthenticatorName,
+	).Scan(&mustChange, &changedAt, &provider)
+	if err != nil {
+		return false, fmt.Errorf("failed to check password expiry: %w", err)
+	}
+	if provider != LocalAuthenticatorName {
+		return false, nil
+	}
+	if mustChange {
+		return true, nil
+	}
+
+	maxAge := s.MaxAge()
+	if maxAge == 0 {
+		return false, nil
+	}
+	return !changedAt.Valid || time.Since(changedAt.Time) >= maxAge, nil
+}
+
+// SendWarnings notifies every active local user whose password expires within
+// the warning period. Each password is warned about once.
+func (s *PasswordExpiryService) SendWarnings() (int, error) {
+	maxAge := s.MaxAge()
+	warningDays := s.settings.GetInt("password.expiry_warning_days", 7)
+	if maxAge == 0 || warningDays <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	rows, err := s.db.Query(`
+		SELECT u.user_apps_id, u.password_changed_at
+		FROM users_application u
+		WHERE u.is_active = true AND u.is_service_account = false AND u.must_change_password = false
+		  AND COALESCE(u.auth_provider, $1) = $1
+		  AND u.password_changed_at IS NOT NULL
+		  AND u.password_changed_at > $2 AND u.password_changed_at <= $3
+		  AND NOT EXISTS (
+		      SELECT 1 FROM notifications n
+		      WHERE n.user_id = u.user_apps_id AND n.notification_type = $4
+		        AND n.created_at >= u.password_changed_at
+		  )`,
+		LocalAuthenticatorName, now.Add(-maxAge), now.Add(-maxAge).AddDate(0, 0, warningDays),
+		passwordExpiringNotificationType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expiring passwords: %w", err)
+	}
+	type expiring struct {
+		userID    int
+		expiresAt time.Time
+	}
+	var due []expiring
+	for rows.Next() {
+		var item expiring
+		var changedAt time.Time
+		if err := rows.Scan(&item.userID, &changedAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to find expiring passwords: %w", err)
+		}
+		item.expiresAt = changedAt.Add(maxAge)
+		due = append(due, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to find expiring passwords: %w", err)
+	}
+
+	sent := 0
+	for _, item := range due {
+		days := int(time.Until(item.expiresAt).Hours()/24) + 1
+		data, err := json.Marshal(map[string]interface{}{"expires_at": item.expiresAt})
+		if err != nil {
+			return sent, fmt.Errorf("failed to encode notification: %w", err)
+		}
+		_, err = s.db.Exec(`
+			INSERT INTO notifications (user_id, notification_type, title, message, data, is_read, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, false, $6, CURRENT_TIMESTAMP)`,
+			item.userID, passwordExpiringNotificationType, "Your password expires soon",
+			fmt.Sprintf("Your password expires in %d day(s). Change it before %s to avoid being asked at sign-in.",
+				days, item.expiresAt.Format("2006-01-02 15:04")),
+			string(data), item.expiresAt)
+		if err != nil {
+			return sent, fmt.Errorf("failed to notify user %d: %w", item.userID, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// RunWarnings calls SendWarnings every interval in the background. Running it
+// on several instances is harmless apart from the rare duplicate warning.
+func (s *PasswordExpiryService) RunWarnings(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if sent, err := s.SendWarnings(); err != nil {
+				log.Printf("Password expiry warnings failed: %v", err)
+			} else if sent > 0 {
+				log.Printf("Sent %d password expiry warning(s)", sent)
+			}
+			<-ticker.C
+		}
+	}()
+}
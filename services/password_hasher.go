@@ -0,0 +1,267 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash algorithms. HashPgcrypto covers the legacy MD5 and DES
+// formats that pgcrypto's gen_salt() can produce; they are verified but never
+// written.
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+	HashPgcrypto = "pgcrypto"
+	HashUnknown  = "unknown"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordHashOptions is read from the password.hash_* settings and decides
+// how new hashes are written.
+type PasswordHashOptions struct {
+	Algorithm         string `json:"algorithm"`
+	BcryptCost        int    `json:"bcrypt_cost"`
+	Argon2MemoryKiB   int    `json:"argon2_memory_kib"`
+	Argon2Iterations  int    `json:"argon2_iterations"`
+	Argon2Parallelism int    `json:"argon2_parallelism"`
+}
+
+// argon2Params are the parameters encoded in an argon2id hash.
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// PasswordHasher is the single place password hashes are created and checked.
+// The algorithm of a stored hash is identified from its format, so bcrypt
+// hashes written by Go or by pgcrypto, argon2id hashes and legacy pgcrypto
+// hashes all verify. Hashes that differ from the configured target are
+// replaced after the next successful sign-in.
+type PasswordHasher struct {
+	db       *sql.DB
+	settings *SettingsService
+
+	mu sync.Mutex
+	// dummyHashes holds one hash per configuration for VerifyDummy
+	dummyHashes map[PasswordHashOptions]string
+}
+
+func NewPasswordHasher(db *sql.DB, settings *SettingsService) *PasswordHasher {
+	return &PasswordHasher{db: db, settings: settings, dummyHashes: map[PasswordHashOptions]string{}}
+}
+
+func (h *PasswordHasher) Options() PasswordHashOptions {
+	options := PasswordHashOptions{
+		Algorithm:         strings.ToLower(h.settings.GetString("password.hash_algorithm", HashBcrypt)),
+		BcryptCost:        h.settings.GetInt("password.bcrypt_cost", bcrypt.DefaultCost),
+		Argon2MemoryKiB:   h.settings.GetInt("password.argon2_memory_kib", 64*1024),
+		Argon2Iterations:  h.settings.GetInt("password.argon2_iterations", 3),
+		Argon2Parallelism: h.settings.GetInt("password.argon2_parallelism", 2),
+	}
+	if options.Algorithm != HashArgon2id {
+		options.Algorithm = HashBcrypt
+	}
+	if options.BcryptCost < bcrypt.MinCost || options.BcryptCost > bcrypt.MaxCost {
+		options.BcryptCost = bcrypt.DefaultCost
+	}
+	if options.Argon2MemoryKiB < 8*1024 {
+		options.Argon2MemoryKiB = 8 * 1024
+	}
+	if options.Argon2Iterations < 1 {
+		options.Argon2Iterations = 1
+	}
+	if options.Argon2Parallelism < 1 || options.Argon2Parallelism > 255 {
+		options.Argon2Parallelism = 1
+	}
+	return options
+}
+
+// IdentifyPasswordHash returns the algorithm of a stored hash.
+func IdentifyPasswordHash(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return HashBcrypt
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return HashArgon2id
+	case strings.HasPrefix(hash, "$1$"), strings.HasPrefix(hash, "_") && len(hash) == 20, len(hash) == 13:
+		return HashPgcrypto
+	default:
+		return HashUnknown
+	}
+}
+
+// Hash hashes password with the configured algorithm.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	options := h.Options()
+	if options.Algorithm == HashArgon2id {
+		return hashArgon2id(password, argon2Params{
+			memory:      uint32(options.Argon2MemoryKiB),
+			iterations:  uint32(options.Argon2Iterations),
+			parallelism: uint8(options.Argon2Parallelism),
+		})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), options.BcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Verify reports whether password matches hash.
+func (h *PasswordHasher) Verify(password, hash string) (bool, error) {
+	switch IdentifyPasswordHash(hash) {
+	case HashBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case HashArgon2id:
+		return verifyArgon2id(password, hash)
+	case HashPgcrypto:
+		var matches bool
+		if err := h.db.QueryRow(`SELECT crypt($1, $2) = $2`, password, hash).Scan(&matches); err != nil {
+			return false, fmt.Errorf("failed to verify password: %w", err)
+		}
+		return matches, nil
+	default:
+		return false, nil
+	}
+}
+
+// VerifyDummy checks password against a fixed hash in the configured format,
+// so that a sign-in for an unknown account takes as long as one for a known
+// account.
+func (h *PasswordHasher) VerifyDummy(password string) {
+	options := h.Options()
+
+	h.mu.Lock()
+	hash, ok := h.dummyHashes[options]
+	h.mu.Unlock()
+	if !ok {
+		var err error
+		if hash, err = h.Hash("dummy password"); err != nil {
+			log.Printf("Failed to create dummy password hash: %v", err)
+			return
+		}
+		h.mu.Lock()
+		h.dummyHashes[options] = hash
+		h.mu.Unlock()
+	}
+
+	h.Verify(password, hash)
+}
+
+// NeedsRehash reports whether hash was written with another algorithm or
+// other parameters than the configured ones.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	options := h.Options()
+	algorithm := IdentifyPasswordHash(hash)
+	if algorithm != options.Algorithm {
+		return true
+	}
+
+	switch algorithm {
+	case HashBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != options.BcryptCost
+	case HashArgon2id:
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil ||
+			params.memory != uint32(options.Argon2MemoryKiB) ||
+			params.iterations != uint32(options.Argon2Iterations) ||
+			params.parallelism != uint8(options.Argon2Parallelism)
+	}
+	return true
+}
+
+// Rehash replaces the stored hash of a user who has just proven password when
+// it is not in the configured format. The update is skipped if the hash has
+// changed in the meantime. Failures are only logged: the sign-in that
+// triggered the upgrade has already succeeded.
+func (h *PasswordHasher) Rehash(userID int, currentHash, password string) {
+	if !h.NeedsRehash(currentHash) {
+		return
+	}
+
+	newHash, err := h.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", userID, err)
+		return
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE users_application SET password_hash = $1
+		WHERE user_apps_id = $2 AND password_hash = $3`, newHash, userID, currentHash)
+	if err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", userID, err)
+	}
+}
+
+// hashArgon2id encodes the hash in the PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func hashArgon2id(password string, params argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.iterations, params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func verifyArgon2id(password, hash string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+func decodeArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, errors.New("malformed argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errors.New("malformed argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("malformed argon2id key")
+	}
+	return params, salt, key, nil
+}
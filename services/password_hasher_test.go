@@ -0,0 +1,72 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdentifyPasswordHash(t *testing.T) {
+	tests := []struct {
+		hash string
+		want string
+	}{
+		{"$2a$10$abcdefghijklmnopqrstuu5Yb7cL0tJ6rX9Xy2yqkK0dW0G9f0aW", HashBcrypt},
+		{"$2b$12$abcdefghijklmnopqrstuu5Yb7cL0tJ6rX9Xy2yqkK0dW0G9f0aW", HashBcrypt},
+		{"$2y$10$abcdefghijklmnopqrstuu5Yb7cL0tJ6rX9Xy2yqkK0dW0G9f0aW", HashBcrypt},
+		{"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$a2V5", HashArgon2id},
+		{"$1$saltsalt$qjXMvbEw8oaL.CzflDugX/", HashPgcrypto},
+		{"_J9..K0AyUubDrfOgO4s", HashPgcrypto},
+		{"saEZU7N0UqMYs", HashPgcrypto},
+		{"plaintext-password", HashUnknown},
+		{"", HashUnknown},
+	}
+	for _, tt := range tests {
+		if got := IdentifyPasswordHash(tt.hash); got != tt.want {
+			t.Errorf("IdentifyPasswordHash(%q) = %q, want %q", tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	params := argon2Params{memory: 8 * 1024, iterations: 1, parallelism: 1}
+	hash, err := hashArgon2id("correct horse", params)
+	if err != nil {
+		t.Fatalf("hashArgon2id: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Fatalf("hash %q is not in the PHC format", hash)
+	}
+
+	decoded, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if decoded != params {
+		t.Errorf("decoded parameters = %+v, want %+v", decoded, params)
+	}
+	if len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("salt/key length = %d/%d, want %d/%d", len(salt), len(key), argon2SaltLength, argon2KeyLength)
+	}
+
+	if ok, err := verifyArgon2id("correct horse", hash); err != nil || !ok {
+		t.Errorf("verifyArgon2id(correct) = %v, %v; want true", ok, err)
+	}
+	if ok, err := verifyArgon2id("wrong horse", hash); err != nil || ok {
+		t.Errorf("verifyArgon2id(wrong) = %v, %v; want false", ok, err)
+	}
+}
+
+func TestDecodeArgon2idRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA",
+		"$argon2i$v=19$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$",
+	} {
+		if _, _, _, err := decodeArgon2id(hash); err == nil {
+			t.Errorf("decodeArgon2id(%q) succeeded, want an error", hash)
+		}
+	}
+}
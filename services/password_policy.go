@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// PasswordPolicy is the effective set of password rules. It is built from the
+// password.* keys in system_settings and is safe to expose to clients.
+type PasswordPolicy struct {
+	MinLength        int  `json:"min_length"`
+	MaxLength        int  `json:"max_length"`
+	RequireUppercase bool `json:"require_uppercase"`
+	RequireLowercase bool `json:"require_lowercase"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	DisallowUserInfo bool `json:"disallow_user_info"`
+	MaxAgeDays       int  `json:"max_age_days"`
+	HistoryDepth     int  `json:"history_depth"`
+	CheckBannedList  bool `json:"check_banned_list"`
+	// MaxBytes limits the UTF-8 length when bcrypt hashes new passwords; 0
+	// means no byte limit.
+	MaxBytes int `json:"max_bytes"`
+}
+
+// PasswordSubject carries the account details a password must not contain.
+type PasswordSubject struct {
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// PasswordPolicyError lists every rule a password failed.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet policy: " + strings.Join(e.Violations, "; ")
+}
+
+// userInfoFragmentMinLength is the shortest account fragment that is checked;
+// shorter fragments would reject too many legitimate passwords.
+const userInfoFragmentMinLength = 3
+
+// bcryptMaxPasswordBytes is the longest input bcrypt accepts.
+const bcryptMaxPasswordBytes = 72
+
+var (
+	bannedPasswordsMu    sync.Mutex
+	bannedPasswordsCache = map[string]map[string]struct{}{}
+)
+
+type PasswordPolicyService struct {
+	settings   *SettingsService
+	bannedFile string
+}
+
+func NewPasswordPolicyService(settings *SettingsService, bannedFile string) *PasswordPolicyService {
+	return &PasswordPolicyService{settings: settings, bannedFile: bannedFile}
+}
+
+// Policy returns the current policy. Settings are read on every call so
+// changes made through the system settings API apply immediately.
+func (s *PasswordPolicyService) Policy() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength:        s.settings.GetInt("password.min_length", 8),
+		MaxLength:        s.settings.GetInt("password.max_length", 100),
+		RequireUppercase: s.settings.GetBool("password.require_uppercase", true),
+		RequireLowercase: s.settings.GetBool("password.require_lowercase", true),
+		RequireDigit:     s.settings.GetBool("password.require_digit", true),
+		RequireSymbol:    s.settings.GetBool("password.require_symbol", false),
+		DisallowUserInfo: s.settings.GetBool("password.disallow_user_info", true),
+		MaxAgeDays:       s.settings.GetInt("password.max_age_days", 0),
+		HistoryDepth:     s.settings.GetInt("password.history_depth", 5),
+		CheckBannedList:  s.settings.GetBool("password.check_banned_list", true),
+	}
+
+	// bcrypt rejects longer input, so a longer password could not be stored
+	if strings.ToLower(s.settings.GetString("password.hash_algorithm", HashBcrypt)) != HashArgon2id {
+		policy.MaxBytes = bcryptMaxPasswordBytes
+		if policy.MaxLength > policy.MaxBytes {
+			policy.MaxLength = policy.MaxBytes
+		}
+		if policy.MinLength > policy.MaxBytes {
+			policy.MinLength = policy.MaxBytes
+		}
+	}
+
+	if policy.MinLength < 1 {
+		policy.MinLength = 1
+	}
+	if policy.MaxLength < policy.MinLength {
+		policy.MaxLength = policy.MinLength
+	}
+	if policy.HistoryDepth < 0 {
+		policy.HistoryDepth = 0
+	}
+	if policy.MaxAgeDays < 0 {
+		policy.MaxAgeDays = 0
+	}
+	return policy
+}
+
+// Validate checks password against the current policy and returns a
+// *PasswordPolicyError describing every failed rule.
+func (s *PasswordPolicyService) Validate(password string, subject PasswordSubject) error {
+	policy := s.Policy()
+	var violations []string
+
+	length := len([]rune(password))
+	if length < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", policy.MinLength))
+	}
+	if length > policy.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters", policy.MaxLength))
+	}
+	if policy.MaxBytes > 0 && len(password) > policy.MaxBytes && length <= policy.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes", policy.MaxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if policy.DisallowUserInfo {
+		for _, fragment := range subject.fragments() {
+			if strings.Contains(lowered, fragment) {
+				violations = append(violations, "must not contain your username, name or email")
+				break
+			}
+		}
+	}
+
+	if policy.CheckBannedList && s.isBanned(lowered) {
+		violations = append(violations, "is too common")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// fragments returns the lowercase account parts that may not appear in a
+// password: the username, names and the email local part with its pieces.
+func (p PasswordSubject) fragments() []string {
+	candidates := []string{p.Username, p.FirstName, p.LastName}
+	if local, _, ok := strings.Cut(p.Email, "@"); ok {
+		candidates = append(candidates, local)
+		candidates = append(candidates, strings.FieldsFunc(local, func(r rune) bool {
+			return r == '.' || r == '_' || r == '-' || r == '+'
+		})...)
+	}
+
+	fragments := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if len([]rune(candidate)) >= userInfoFragmentMinLength {
+			fragments = append(fragments, candidate)
+		}
+	}
+	return fragments
+}
+
+func (s *PasswordPolicyService) isBanned(lowered string) bool {
+	if s.bannedFile == "" {
+		return false
+	}
+	_, banned := loadBannedPasswords(s.bannedFile)[lowered]
+	return banned
+}
+
+// loadBannedPasswords reads the banned password list once per path. The file
+// holds one password per line; blank lines and lines starting with # are
+// ignored. A missing file is logged and treated as an empty list.
+func loadBannedPasswords(path string) map[string]struct{} {
+	bannedPasswordsMu.Lock()
+	defer bannedPasswordsMu.Unlock()
+
+	if list, ok := bannedPasswordsCache[path]; ok {
+		return list
+	}
+
+	list := map[string]struct{}{}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Banned password list %s not loaded: %v", path, err)
+		bannedPasswordsCache[path] = list
+		return list
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Banned password list %s partially loaded: %v", path, err)
+	}
+
+	bannedPasswordsCache[path] = list
+	return list
+}
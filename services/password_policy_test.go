@@ -0,0 +1,89 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestPasswordPolicyService answers every password.* setting read by
+// Policy from values; missing keys fall back to their defaults.
+func newTestPasswordPolicyService(t *testing.T, values map[string]string) *PasswordPolicyService {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.MatchExpectationsInOrder(false)
+
+	keys := []string{
+		"password.min_length", "password.max_length", "password.require_uppercase",
+		"password.require_lowercase", "password.require_digit", "password.require_symbol",
+		"password.disallow_user_info", "password.max_age_days", "password.history_depth",
+		"password.check_banned_list", "password.hash_algorithm",
+	}
+	for _, key := range keys {
+		rows := sqlmock.NewRows([]string{"setting_value"})
+		if value, ok := values[key]; ok {
+			rows.AddRow(value)
+		}
+		mock.ExpectQuery(`SELECT setting_value FROM system_settings`).WithArgs(key).WillReturnRows(rows)
+	}
+	return NewPasswordPolicyService(NewSettingsService(db), "")
+}
+
+var lenientPasswordSettings = map[string]string{
+	"password.max_length":         "100",
+	"password.require_uppercase":  "false",
+	"password.require_lowercase":  "false",
+	"password.require_digit":      "false",
+	"password.disallow_user_info": "false",
+	"password.check_banned_list":  "false",
+}
+
+func TestPasswordPolicyBcryptByteLimit(t *testing.T) {
+	cases := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{"72 bytes", strings.Repeat("a", 72), ""},
+		{"73 bytes", strings.Repeat("a", 73), "must be at most 72 characters"},
+		// 36 two-byte runes fit, 37 fit the character limit but not bcrypt
+		{"72 bytes of multibyte runes", strings.Repeat("é", 36), ""},
+		{"74 bytes of multibyte runes", strings.Repeat("é", 37), "must be at most 72 bytes"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := newTestPasswordPolicyService(t, lenientPasswordSettings).Validate(tc.password, PasswordSubject{})
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Validate error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyMaxLengthFollowsHashAlgorithm(t *testing.T) {
+	policy := newTestPasswordPolicyService(t, lenientPasswordSettings).Policy()
+	if policy.MaxLength != 72 || policy.MaxBytes != 72 {
+		t.Errorf("bcrypt: max_length = %d, max_bytes = %d, want 72 and 72", policy.MaxLength, policy.MaxBytes)
+	}
+
+	settings := map[string]string{"password.hash_algorithm": "argon2id"}
+	for key, value := range lenientPasswordSettings {
+		settings[key] = value
+	}
+	service := newTestPasswordPolicyService(t, settings)
+	if err := service.Validate(strings.Repeat("é", 100), PasswordSubject{}); err != nil {
+		t.Errorf("argon2id: Validate of 100 characters: %v", err)
+	}
+}
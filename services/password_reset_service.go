@@ -0,0 +1,216 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	passwordResetTemplate  = "PASSWORD_RESET"
+	userInvitationTemplate = "USER_INVITATION"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// PasswordResetOptions configures reset links and token lifetime.
+type PasswordResetOptions struct {
+	AppBaseURL    string
+	TokenTTL      time.Duration
+	InvitationTTL time.Duration
+}
+
+// PasswordResetService implements the self-service reset flow on top of the
+// password_reset_tokens table. Tokens are single use and stored hashed.
+type PasswordResetService struct {
+	db        *sql.DB
+	email     *EmailService
+	passwords *PasswordService
+	options   PasswordResetOptions
+}
+
+func NewPasswordResetService(db *sql.DB, email *EmailService, passwords *PasswordService, options PasswordResetOptions) *PasswordResetService {
+	if options.TokenTTL <= 0 {
+		options.TokenTTL = time.Hour
+	}
+	if options.InvitationTTL <= 0 {
+		options.InvitationTTL = 72 * time.Hour
+	}
+	return &PasswordResetService{db: db, email: email, passwords: passwords, options: options}
+}
+
+// RequestReset issues a reset token for the active account with the given
+// email and mails the link. It returns nil whether or not the account exists,
+// so callers cannot tell the two cases apart.
+func (s *PasswordResetService) RequestReset(email string) error {
+	var userID int
+	var username, firstName string
+	query := `
+		SELECT user_apps_id, username, first_name
+		FROM users_application
+		WHERE LOWER(email) = LOWER($1) AND is_active = true
+	`
+	err := s.db.QueryRow(query, strings.TrimSpace(email)).Scan(&userID, &username, &firstName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("failed to look up account: %w", err)
+	}
+
+	return s.issueResetToken(userID, username, firstName, email)
+}
+
+// RequestResetForUser sends a reset link to a user on behalf of an administrator.
+func (s *PasswordResetService) RequestResetForUser(userID int) error {
+	var username, firstName, email string
+	query := `
+		SELECT username, first_name, email
+		FROM users_application
+		WHERE user_apps_id = $1 AND is_active = true
+	`
+	if err := s.db.QueryRow(query, userID).Scan(&username, &firstName, &email); err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("failed to look up account: %w", err)
+	}
+
+	return s.issueResetToken(userID, username, firstName, email)
+}
+
+// Invite mails a new user a link to choose their first password. The link is
+// a reset token that lives for InvitationTTL instead of TokenTTL.
+func (s *PasswordResetService) Invite(userID int) error {
+	var username, firstName, email string
+	query := `
+		SELECT username, first_name, email
+		FROM users_application
+		WHERE user_apps_id = $1 AND is_active = true
+	`
+	if err := s.db.QueryRow(query, userID).Scan(&username, &firstName, &email); err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("failed to look up account: %w", err)
+	}
+
+	return s.issueToken(userID, email, userInvitationTemplate, s.options.InvitationTTL, map[string]string{
+		"username":         username,
+		"first_name":       firstName,
+		"expires_in_hours": strconv.Itoa(int(s.options.InvitationTTL.Hours())),
+	})
+}
+
+func (s *PasswordResetService) issueResetToken(userID int, username, firstName, email string) error {
+	return s.issueToken(userID, email, passwordResetTemplate, s.options.TokenTTL, map[string]string{
+		"username":           username,
+		"first_name":         firstName,
+		"expires_in_minutes": strconv.Itoa(int(s.options.TokenTTL.Minutes())),
+	})
+}
+
+// issueToken stores a new reset token valid for ttl and mails its link with
+// template. The token and link are added to variables as reset_token and
+// reset_link.
+func (s *PasswordResetService) issueToken(userID int, email, template string, ttl time.Duration, variables map[string]string) error {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Only the newest link stays valid
+	if _, err := tx.Exec(`UPDATE password_reset_tokens SET is_used = true WHERE user_id = $1 AND is_used = false`, userID); err != nil {
+		return fmt.Errorf("failed to invalidate previous tokens: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token, expires_at, is_used, created_at)
+		VALUES ($1, $2, $3, false, CURRENT_TIMESTAMP)`,
+		userID, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	variables["reset_token"] = token
+	variables["reset_link"] = strings.TrimRight(s.options.AppBaseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+
+	// Sending happens in the background so the response time does not reveal
+	// whether the account exists.
+	go func() {
+		if err := s.email.SendTemplate(email, template, variables); err != nil {
+			log.Printf("%s email for user %d failed: %v", template, userID, err)
+		}
+	}()
+
+	return nil
+}
+
+// ResetPassword consumes a reset token and sets the new password. The new hash
+// is recorded in user_password_history and every session of the user is
+// revoked. As the token was mailed to the account's address, that address
+// counts as verified from then on.
+func (s *PasswordResetService) ResetPassword(token, newPassword string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	err = tx.QueryRow(`
+		SELECT id, user_id FROM password_reset_tokens
+		WHERE token = $1 AND is_used = false AND expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE`, hashToken(token)).Scan(&tokenID, &userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to load reset token: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE password_reset_tokens SET is_used = true WHERE id = $1`, tokenID); err != nil {
+		return fmt.Errorf("failed to consume reset token: %w", err)
+	}
+
+	if err := s.passwords.SetPasswordTx(tx, userID, newPassword, "password_reset", 0); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users_application SET failed_login_attempts = 0, lockout_count = 0, locked_until = NULL,
+		    email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE user_apps_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update account: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCurrentPasswordIncorrect = errors.New("current password is incorrect")
+	ErrPasswordReused           = errors.New("new password must not match a recently used password")
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword     string `json:"current_password" validate:"required"`
+	NewPassword         string `json:"new_password" validate:"required"`
+	LogoutOtherSessions bool   `json:"logout_other_sessions"`
+	// MustChangePassword is only honoured when an administrator sets another
+	// user's password
+	MustChangePassword bool `json:"must_change_password"`
+}
+
+// PasswordService owns every write of users_application.password_hash so that
+// history and session rules are applied the same way everywhere.
+type PasswordService struct {
+	db     *sql.DB
+	policy *PasswordPolicyService
+	hasher *PasswordHasher
+}
+
+func NewPasswordService(db *sql.DB, policy *PasswordPolicyService, hasher *PasswordHasher) *PasswordService {
+	return &PasswordService{db: db, policy: policy, hasher: hasher}
+}
+
+// Policy returns the password policy enforced by this service.
+func (s *PasswordService) Policy() *PasswordPolicyService {
+	return s.policy
+}
+
+// ValidatePassword checks a candidate password against the policy. Use it
+// before creating an account; SetPassword validates on its own.
+func (s *PasswordService) ValidatePassword(password string, subject PasswordSubject) error {
+	return s.policy.Validate(password, subject)
+}
+
+// HashPassword hashes a password with the configured algorithm without
+// storing it. Use it when inserting a new account; SetPassword hashes on its
+// own.
+func (s *PasswordService) HashPassword(password string) (string, error) {
+	return s.hasher.Hash(password)
+}
+
+// VerifyPassword checks a plain password against the stored hash, whatever
+// algorithm wrote it. A matching hash that is not in the configured format is
+// replaced.
+func (s *PasswordService) VerifyPassword(userID int, password string) (bool, error) {
+	var hash sql.NullString
+	err := s.db.QueryRow(`
+		SELECT password_hash FROM users_application WHERE user_apps_id = $1`, userID).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, sql.ErrNoRows
+		}
+		return false, fmt.Errorf("failed to verify password: %w", err)
+	}
+
+	matches, err := s.hasher.Verify(password, hash.String)
+	if err != nil {
+		return false, err
+	}
+	if matches {
+		s.hasher.Rehash(userID, hash.String, password)
+	}
+	return matches, nil
+}
+
+// SetPassword stores newPassword without checking the current one. It rejects
+// passwords that break the policy or are found in the recent history, records the new hash in
+// user_password_history and updates password_changed_at. revokeExcept selects
+// which sessions to end: -1 keeps all, 0 ends all, otherwise all but that one.
+func (s *PasswordService) SetPassword(userID int, newPassword, updatedBy string, revokeExcept int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.SetPasswordTx(tx, userID, newPassword, updatedBy, revokeExcept); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SetPasswordTx is SetPassword within tx, for callers that update the user in
+// the same transaction.
+func (s *PasswordService) SetPasswordTx(tx *sql.Tx, userID int, newPassword, updatedBy string, revokeExcept int) error {
+	var subject PasswordSubject
+	err := tx.QueryRow(`
+		SELECT username, email, COALESCE(first_name, ''), COALESCE(last_name, '')
+		FROM users_application WHERE user_apps_id = $1`, userID).
+		Scan(&subject.Username, &subject.Email, &subject.FirstName, &subject.LastName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	policy := s.policy.Policy()
+	if err := s.policy.Validate(newPassword, subject); err != nil {
+		return err
+	}
+
+	reused, err := s.isRecentlyUsedTx(tx, userID, newPassword, policy.HistoryDepth)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return ErrPasswordReused
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users_application
+		SET password_hash = $1, password_changed_at = CURRENT_TIMESTAMP, must_change_password = false,
+		    updated_by = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $3`, hashedPassword, updatedBy, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_password_history (user_id, password_hash, created_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)`, userID, hashedPassword)
+	if err != nil {
+		return fmt.Errorf("failed to record password history: %w", err)
+	}
+
+	if revokeExcept >= 0 {
+		if err := revokeUserSessionsTx(tx, userID, revokeExcept); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequirePasswordChange makes the user's next sessions reach nothing but the
+// password change until a new password is set.
+func (s *PasswordService) RequirePasswordChange(userID int, updatedBy string) error {
+	result, err := s.db.Exec(`
+		UPDATE users_application
+		SET must_change_password = true, updated_by = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $2 AND is_service_account = false`, updatedBy, userID)
+	if err != nil {
+		return fmt.Errorf("failed to require password change: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// isRecentlyUsedTx reports whether password matches the current hash or one of
+// the last depth hashes in user_password_history. The hashes are compared in
+// Go because history may hold several algorithms.
+func (s *PasswordService) isRecentlyUsedTx(tx *sql.Tx, userID int, password string, depth int) (bool, error) {
+	rows, err := tx.Query(`
+		SELECT password_hash FROM users_application
+		WHERE user_apps_id = $1 AND password_hash IS NOT NULL
+		UNION ALL (
+			SELECT password_hash FROM user_password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC
+			LIMIT $2
+		)`, userID, depth)
+	if err != nil {
+		return false, fmt.Errorf("failed to check password history: %w", err)
+	}
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to check password history: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to check password history: %w", err)
+	}
+
+	for _, hash := range hashes {
+		matches, err := s.hasher.Verify(password, hash)
+		if err != nil {
+			return false, fmt.Errorf("failed to check password history: %w", err)
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
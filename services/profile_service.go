@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxPreferencesSize bounds the preferences document a user can store.
+const maxPreferencesSize = 16 * 1024
+
+var (
+	ErrInvalidPreferences = errors.New("preferences must be a JSON object of at most 16 KiB")
+	ErrBlankName          = errors.New("first and last name cannot be blank")
+)
+
+// UserProfile is the caller's own users_application row without credentials
+// and lockout state.
+type UserProfile struct {
+	ID                 int        `json:"id"`
+	Username           string     `json:"username"`
+	Email              string     `json:"email"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	PendingEmail       *string    `json:"pending_email"`
+	FirstName          string     `json:"first_name"`
+	LastName           string     `json:"last_name"`
+	StatusID           int        `json:"status_id"`
+	DepartmentID       *int       `json:"department_id"`
+	EmployeeID         *string    `json:"employee_id"`
+	Phone              *string    `json:"phone"`
+	AvatarURL          *string    `json:"avatar_url"`
+	AvatarThumbnailURL *string    `json:"avatar_thumbnail_url"`
+	LastLoginAt        *time.Time `json:"last_login_at"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	IsServiceAccount   bool       `json:"is_service_account"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// UpdateProfileRequest lists the fields users may change on their own account.
+// Omitted fields keep their value; an empty phone clears it.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name" validate:"omitempty,max=100"`
+	LastName  *string `json:"last_name" validate:"omitempty,max=100"`
+	Phone     *string `json:"phone" validate:"omitempty,max=20"`
+}
+
+type ProfileDepartment struct {
+	DepartmentID   int    `json:"department_id"`
+	DepartmentName string `json:"department_name"`
+	DepartmentCode string `json:"department_code"`
+}
+
+type ProfileRole struct {
+	RoleID     int       `json:"role_id"`
+	RoleCode   string    `json:"role_code"`
+	RoleName   string    `json:"role_name"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+// MenuNode is a menu the user can reach, with the can_* flags granted by their
+// roles and the accessible children nested below it.
+type MenuNode struct {
+	MenusID     int         `json:"menus_id"`
+	MenuCode    string      `json:"menu_code"`
+	MenuName    string      `json:"menu_name"`
+	ParentID    *int        `json:"parent_id"`
+	IconName    *string     `json:"icon_name"`
+	Route       *string     `json:"route"`
+	MenuOrder   int         `json:"menu_order"`
+	CanView     bool        `json:"can_view"`
+	CanCreate   bool        `json:"can_create"`
+	CanModify   bool        `json:"can_modify"`
+	CanDelete   bool        `json:"can_delete"`
+	CanUpload   bool        `json:"can_upload"`
+	CanDownload bool        `json:"can_download"`
+	Children    []*MenuNode `json:"children"`
+}
+
+// CurrentUser is everything a client needs to bootstrap after sign-in.
+type CurrentUser struct {
+	Profile     UserProfile        `json:"profile"`
+	Department  *ProfileDepartment `json:"department"`
+	Roles       []ProfileRole      `json:"roles"`
+	Permissions []string           `json:"permissions"`
+	Menus       []*MenuNode        `json:"menus"`
+	Preferences json.RawMessage    `json:"preferences"`
+}
+
+// ProfileService reads and updates the signed-in user's own account data.
+type ProfileService struct {
+	db *sql.DB
+}
+
+func NewProfileService(db *sql.DB) *ProfileService {
+	return &ProfileService{db: db}
+}
+
+// CurrentUser loads the profile, department, active roles, permission codes,
+// menu tree and preferences of a user.
+func (s *ProfileService) CurrentUser(userID int) (*CurrentUser, error) {
+	profile, department, err := s.loadProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	current := &CurrentUser{Profile: *profile, Department: department}
+
+	if current.Roles, err = s.roles(userID); err != nil {
+		return nil, err
+	}
+	if current.Permissions, err = s.permissions(userID); err != nil {
+		return nil, err
+	}
+	if current.Menus, err = s.menuTree(userID); err != nil {
+		return nil, err
+	}
+	if current.Preferences, err = s.Preferences(userID); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (s *ProfileService) loadProfile(userID int) (*UserProfile, *ProfileDepartment, error) {
+	profile := &UserProfile{}
+	var department *ProfileDepartment
+	var departmentID sql.NullInt64
+	var departmentName, departmentCode sql.NullString
+	err := s.db.QueryRow(`
+		SELECT u.user_apps_id, u.username, u.email, u.email_verified_at, u.pending_email, u.first_name, u.last_name, u.status_id,
+		       u.department_id, u.employee_id, u.phone, u.avatar_url, u.avatar_thumbnail_url,
+		       u.last_login_at, u.password_changed_at, u.is_service_account, u.created_at, u.updated_at,
+		       d.department_id, d.department_name, d.department_code
+		FROM users_application u
+		LEFT JOIN departments d ON d.department_id = u.department_id
+		WHERE u.user_apps_id = $1`, userID,
+	).Scan(&profile.ID, &profile.Username, &profile.Email, &profile.EmailVerifiedAt, &profile.PendingEmail, &profile.FirstName, &profile.LastName,
+		&profile.StatusID, &profile.DepartmentID, &profile.EmployeeID, &profile.Phone,
+		&profile.AvatarURL, &profile.AvatarThumbnailURL, &profile.LastLoginAt, &profile.PasswordChangedAt,
+		&profile.IsServiceAccount, &profile.CreatedAt, &profile.UpdatedAt,
+		&departmentID, &departmentName, &departmentCode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, sql.ErrNoRows
+		}
+		return nil, nil, fmt.Errorf("failed to load profile: %w", err)
+	}
+	if departmentID.Valid {
+		department = &ProfileDepartment{
+			DepartmentID:   int(departmentID.Int64),
+			DepartmentName: departmentName.String,
+			DepartmentCode: departmentCode.String,
+		}
+	}
+	return profile, department, nil
+}
+
+// UpdateProfile applies a self-service edit and returns the updated profile.
+func (s *ProfileService) UpdateProfile(userID int, req UpdateProfileRequest, updatedBy string) (*UserProfile, error) {
+	for _, name := range []*string{req.FirstName, req.LastName} {
+		if name != nil {
+			*name = strings.TrimSpace(*name)
+			if *name == "" {
+				return nil, ErrBlankName
+			}
+		}
+	}
+	var phone sql.NullString
+	if req.Phone != nil {
+		phone = sql.NullString{String: strings.TrimSpace(*req.Phone), Valid: true}
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users_application
+		SET first_name = COALESCE($1, first_name),
+		    last_name = COALESCE($2, last_name),
+		    phone = CASE WHEN $3::boolean THEN NULLIF($4, '') ELSE phone END,
+		    updated_by = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $6`,
+		req.FirstName, req.LastName, phone.Valid, phone.String, updatedBy, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	profile, _, err := s.loadProfile(userID)
+	return profile, err
+}
+
+func (s *ProfileService) roles(userID int) ([]ProfileRole, error) {
+	rows, err := s.db.Query(`
+		SELECT r.roles_id, r.roles_code, r.roles_name, ur.assigned_at
+		FROM user_roles ur
+		JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+		WHERE ur.user_id = $1 AND ur.is_active = true
+		ORDER BY r.roles_name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []ProfileRole{}
+	for rows.Next() {
+		var role ProfileRole
+		if err := rows.Scan(&role.RoleID, &role.RoleCode, &role.RoleName, &role.AssignedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// permissions returns the distinct permission codes granted by the user's
+// active roles, the same rule AuthorizationService.HasPermission applies.
+func (s *ProfileService) permissions(userID int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT p.permission_code
+		FROM user_roles ur
+		JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+		JOIN role_permissions rp ON rp.role_id = ur.role_id AND rp.is_active = true
+		JOIN permissions p ON p.permissions_id = rp.permission_id AND p.is_active = true
+		WHERE ur.user_id = $1 AND ur.is_active = true
+		ORDER BY p.permission_code`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, code)
+	}
+	return permissions, rows.Err()
+}
+
+// menuTree nests the flat list from security.get_user_menus. A menu whose
+// parent is not accessible to the user is shown at the top level.
+func (s *ProfileService) menuTree(userID int) ([]*MenuNode, error) {
+	rows, err := s.db.Query(`SELECT * FROM security.get_user_menus($1)`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load menus: %w", err)
+	}
+	defer rows.Close()
+
+	var menus []*MenuNode
+	byID := map[int]*MenuNode{}
+	for rows.Next() {
+		menu := &MenuNode{Children: []*MenuNode{}}
+		if err := rows.Scan(&menu.MenusID, &menu.MenuCode, &menu.MenuName, &menu.ParentID,
+			&menu.IconName, &menu.Route, &menu.MenuOrder, &menu.CanView, &menu.CanCreate,
+			&menu.CanModify, &menu.CanDelete, &menu.CanUpload, &menu.CanDownload); err != nil {
+			return nil, fmt.Errorf("failed to scan menu: %w", err)
+		}
+		menus = append(menus, menu)
+		byID[menu.MenusID] = menu
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load menus: %w", err)
+	}
+
+	roots := []*MenuNode{}
+	for _, menu := range menus {
+		if menu.ParentID != nil {
+			if parent, ok := byID[*menu.ParentID]; ok {
+				parent.Children = append(parent.Children, menu)
+				continue
+			}
+		}
+		roots = append(roots, menu)
+	}
+	sortMenus(roots)
+	return roots, nil
+}
+
+func sortMenus(menus []*MenuNode) {
+	sort.SliceStable(menus, func(i, j int) bool {
+		return menus[i].MenuOrder < menus[j].MenuOrder
+	})
+	for _, menu := range menus {
+		sortMenus(menu.Children)
+	}
+}
+
+// Preferences returns the user's stored preferences, or an empty object.
+func (s *ProfileService) Preferences(userID int) (json.RawMessage, error) {
+	var preferences []byte
+	err := s.db.QueryRow(`SELECT preferences FROM user_preferences WHERE user_id = $1`, userID).Scan(&preferences)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return json.RawMessage(`{}`), nil
+		}
+		return nil, fmt.Errorf("failed to load preferences: %w", err)
+	}
+	return json.RawMessage(preferences), nil
+}
+
+// SetPreferences replaces the user's preferences. They are opaque to the
+// server but must be a JSON object.
+func (s *ProfileService) SetPreferences(userID int, preferences json.RawMessage) error {
+	trimmed := bytes.TrimSpace(preferences)
+	if len(trimmed) > maxPreferencesSize || !bytes.HasPrefix(trimmed, []byte("{")) || !json.Valid(trimmed) {
+		return ErrInvalidPreferences
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO user_preferences (user_id, preferences, updated_at)
+		VALUES ($1, $2::jsonb, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE
+		SET preferences = EXCLUDED.preferences, updated_at = EXCLUDED.updated_at`, userID, string(trimmed))
+	if err != nil {
+		return fmt.Errorf("failed to save preferences: %w", err)
+	}
+	return nil
+}
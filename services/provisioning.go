@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var errProvisioningConflict = errors.New("a local account with this username or email already exists")
+
+// provisionUser creates the users_application row for a directory user on
+// first login. The stored password hash is random: the account can only sign
+// in through its authenticator until an administrator sets a password.
+func (s *AuthService) provisionUser(provider string, identity *AuthenticatedIdentity, options ProvisioningOptions) (int, error) {
+	if identity.Email == "" {
+		return 0, fmt.Errorf("cannot provision %s user %s without an email address", provider, identity.Username)
+	}
+
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users_application WHERE username = $1 OR LOWER(email) = LOWER($2))`,
+		identity.Username, identity.Email,
+	).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check existing account: %v", err)
+	}
+	if exists {
+		return 0, fmt.Errorf("cannot provision %s user %s: %w", provider, identity.Username, errProvisioningConflict)
+	}
+
+	placeholder, err := generateOpaqueToken()
+	if err != nil {
+		return 0, err
+	}
+	hash, err := s.hasher.Hash(placeholder)
+	if err != nil {
+		return 0, err
+	}
+
+	firstName := identity.FirstName
+	if firstName == "" {
+		firstName = identity.Username
+	}
+
+	statusID := options.DefaultStatusID
+	if statusID <= 0 {
+		statusID = 1
+	}
+
+	// The directory or identity provider vouches for the email address
+	var userID int
+	err = s.db.QueryRow(`
+		INSERT INTO users_application
+			(username, email, password_hash, first_name, last_name, status_id,
+			 auth_provider, is_active, created_by, password_changed_at, email_verified_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, true, $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING user_apps_id`,
+		identity.Username, identity.Email, hash, firstName, identity.LastName,
+		statusID, provider, "provisioning:"+provider,
+	).Scan(&userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %v", err)
+	}
+
+	s.activity.Record(ActivityLogEntry{
+		Actor:       ActivityActor{UserID: userID, Username: identity.Username},
+		Action:      "USER_PROVISIONED",
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: "Account created on first sign-in with " + provider,
+	})
+	return userID, nil
+}
+
+// syncManagedRoles makes the user's assignments of the managed role codes
+// match roles: missing ones are granted, ones no longer mapped are revoked.
+// Roles outside managed are never touched.
+func (s *AuthService) syncManagedRoles(userID int, roles, managed []string) error {
+	if len(managed) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Revoke managed roles the directory no longer grants
+	_, err = tx.Exec(`
+		UPDATE user_roles ur SET is_active = false
+		FROM users_roles r
+		WHERE ur.role_id = r.roles_id
+		  AND ur.user_id = $1 AND ur.is_active = true
+		  AND r.roles_code = ANY($2) AND NOT (r.roles_code = ANY($3))`,
+		userID, pq.Array(managed), pq.Array(roles),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke mapped roles: %v", err)
+	}
+
+	// Reactivate earlier assignments, then add the ones that never existed
+	_, err = tx.Exec(`
+		UPDATE user_roles ur SET is_active = true, assigned_at = CURRENT_TIMESTAMP
+		FROM users_roles r
+		WHERE ur.role_id = r.roles_id
+		  AND ur.user_id = $1 AND ur.is_active = false
+		  AND r.roles_code = ANY($2) AND r.roles_code = ANY($3)`,
+		userID, pq.Array(managed), pq.Array(roles),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to restore mapped roles: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_roles (user_id, role_id, assigned_at, is_active)
+		SELECT $1, r.roles_id, CURRENT_TIMESTAMP, true
+		FROM users_roles r
+		WHERE r.is_active = true AND r.roles_code = ANY($2) AND r.roles_code = ANY($3)
+		  AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = $1 AND ur.role_id = r.roles_id)`,
+		userID, pq.Array(managed), pq.Array(roles),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to grant mapped roles: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActivityLoginRateLimited is logged when a login rate limit trips.
+const ActivityLoginRateLimited = "LOGIN_RATE_LIMITED"
+
+// Actions throttled by LoginRateLimiter. Each has its own counters.
+const (
+	RateLimitLogin = "login"
+	// RateLimitMFA covers every check of a two-factor code: the login
+	// challenge, disabling 2FA and regenerating recovery codes.
+	RateLimitMFA = "mfa"
+	// RateLimitForgotPassword is keyed by the email address instead of a
+	// username.
+	RateLimitForgotPassword = "forgot_password"
+	// RateLimitRegister is keyed by the email address of the applicant.
+	RateLimitRegister = "register"
+)
+
+// RateLimit is one counter checked by RateLimitStore.Take.
+type RateLimit struct {
+	Key   string
+	Limit int
+}
+
+// RateLimitStore keeps sliding-window hit logs per key.
+type RateLimitStore interface {
+	// Take records a hit for every limit, unless one of them already has
+	// Limit hits within the window ending at now. In that case nothing is
+	// recorded and Take returns the index of the first full limit and how
+	// long until its oldest hit leaves the window; otherwise the index is -1.
+	Take(limits []RateLimit, window time.Duration, now time.Time) (int, time.Duration, error)
+}
+
+// MemoryRateLimitStore keeps hits in process memory. Counters are per
+// instance; use PostgresRateLimitStore when several instances share traffic.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{hits: map[string][]time.Time{}}
+}
+
+func (s *MemoryRateLimitStore) Take(limits []RateLimit, window time.Duration, now time.Time) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keys nobody hits again would otherwise stay forever
+	if now.Sub(s.lastSweep) > window {
+		for k, hits := range s.hits {
+			if len(pruneHits(hits, now.Add(-window))) == 0 {
+				delete(s.hits, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	for i, limit := range limits {
+		hits := pruneHits(s.hits[limit.Key], now.Add(-window))
+		s.hits[limit.Key] = hits
+		if len(hits) >= limit.Limit {
+			return i, hits[len(hits)-limit.Limit].Add(window).Sub(now), nil
+		}
+	}
+	for _, limit := range limits {
+		s.hits[limit.Key] = append(s.hits[limit.Key], now)
+	}
+	return -1, 0, nil
+}
+
+// pruneHits drops the hits at or before since; hits are in ascending order.
+func pruneHits(hits []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(since) {
+		i++
+	}
+	return hits[i:]
+}
+
+// PostgresRateLimitStore keeps hits in the rate_limit_hits table so every
+// instance sees the same counters. Hits of one key are serialized with a
+// transaction-scoped advisory lock; the keys of one Take are locked in sorted
+// order so that concurrent calls cannot deadlock.
+type PostgresRateLimitStore struct {
+	db *sql.DB
+
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+func NewPostgresRateLimitStore(db *sql.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db}
+}
+
+func (s *PostgresRateLimitStore) Take(limits []RateLimit, window time.Duration, now time.Time) (int, time.Duration, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	keys := make([]string, 0, len(limits))
+	for _, limit := range limits {
+		keys = append(keys, limit.Key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+			return -1, 0, fmt.Errorf("failed to lock rate limit key: %w", err)
+		}
+	}
+
+	since := now.Add(-window)
+	for i, limit := range limits {
+		if _, err := tx.Exec(`DELETE FROM rate_limit_hits WHERE rate_key = $1 AND hit_at <= $2`, limit.Key, since); err != nil {
+			return -1, 0, fmt.Errorf("failed to prune rate limit hits: %w", err)
+		}
+
+		// The limit-th most recent hit is the one that has to expire first
+		var blocking sql.NullTime
+		err = tx.QueryRow(`
+			SELECT hit_at FROM rate_limit_hits
+			WHERE rate_key = $1
+			ORDER BY hit_at DESC
+			OFFSET $2 - 1 LIMIT 1`, limit.Key, limit.Limit,
+		).Scan(&blocking)
+		if err != nil && err != sql.ErrNoRows {
+			return -1, 0, fmt.Errorf("failed to count rate limit hits: %w", err)
+		}
+		if blocking.Valid {
+			return i, blocking.Time.Add(window).Sub(now), nil
+		}
+	}
+
+	for _, limit := range limits {
+		if _, err := tx.Exec(`INSERT INTO rate_limit_hits (rate_key, hit_at) VALUES ($1, $2)`, limit.Key, now); err != nil {
+			return -1, 0, fmt.Errorf("failed to record rate limit hit: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.cleanup(since, now, window)
+	return -1, 0, nil
+}
+
+// cleanup removes expired hits of every key, at most once per window per
+// instance; Take only prunes the key it is called for.
+func (s *PostgresRateLimitStore) cleanup(since, now time.Time, window time.Duration) {
+	s.mu.Lock()
+	due := now.Sub(s.lastCleanup) > window
+	if due {
+		s.lastCleanup = now
+	}
+	s.mu.Unlock()
+
+	if due {
+		if _, err := s.db.Exec(`DELETE FROM rate_limit_hits WHERE hit_at <= $1`, since); err != nil {
+			log.Printf("Failed to clean up rate limit hits: %v", err)
+		}
+	}
+}
+
+// LoginRateLimitPolicy is read from the security.login_rate_limit_* settings.
+// A limit of 0 disables that dimension.
+type LoginRateLimitPolicy struct {
+	Enabled       bool `json:"enabled"`
+	PerIP         int  `json:"per_ip"`
+	PerUsername   int  `json:"per_username"`
+	PerIPUsername int  `json:"per_ip_username"`
+	WindowSeconds int  `json:"window_seconds"`
+}
+
+// RateLimitDecision is the outcome of a login attempt check.
+type RateLimitDecision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	// Dimension is the limit that refused the attempt: "ip_username",
+	// "username" or "ip".
+	Dimension string
+}
+
+// LoginRateLimiter throttles login attempts by client IP, by username and by
+// the pair, so that neither spraying one password across accounts nor
+// guessing one account from many addresses goes unchecked. The same limits
+// apply to the other actions that let a client guess a secret.
+type LoginRateLimiter struct {
+	store RateLimitStore
+	// fallback counts attempts on this instance while store fails, so an
+	// outage of the shared store neither lets every attempt through nor
+	// refuses them all
+	fallback *MemoryRateLimitStore
+	settings *SettingsService
+	activity *ActivityLogService
+
+	mu sync.Mutex
+	// reported remembers when a trip was last logged per key, so an attack
+	// produces one activity entry per window rather than one per request
+	reported map[string]time.Time
+}
+
+func NewLoginRateLimiter(store RateLimitStore, settings *SettingsService, activity *ActivityLogService) *LoginRateLimiter {
+	return &LoginRateLimiter{
+		store:    store,
+		fallback: NewMemoryRateLimitStore(),
+		settings: settings,
+		activity: activity,
+		reported: map[string]time.Time{},
+	}
+}
+
+func (l *LoginRateLimiter) Policy() LoginRateLimitPolicy {
+	return LoginRateLimitPolicy{
+		Enabled:       l.settings.GetBool("security.login_rate_limit_enabled", true),
+		PerIP:         l.settings.GetInt("security.login_rate_limit_per_ip", 20),
+		PerUsername:   l.settings.GetInt("security.login_rate_limit_per_username", 10),
+		PerIPUsername: l.settings.GetInt("security.login_rate_limit_per_ip_username", 5),
+		WindowSeconds: l.settings.GetInt("security.login_rate_limit_window_seconds", 300),
+	}
+}
+
+// Allow records a login attempt and reports whether it may proceed.
+func (l *LoginRateLimiter) Allow(ipAddress, username, userAgent string) (*RateLimitDecision, error) {
+	return l.AllowAction(RateLimitLogin, ipAddress, username, userAgent)
+}
+
+// AllowAction records an attempt at action for the account named username
+// and reports whether it may proceed. The attempt counts against every limit
+// or, when one of them is reached, against none, so a refused attempt does
+// not use up the other limits. Without a username only the IP is limited.
+// Usernames are hashed into the keys, which keeps the keys short however
+// long the name a client sends.
+func (l *LoginRateLimiter) AllowAction(action, ipAddress, username, userAgent string) (*RateLimitDecision, error) {
+	policy := l.Policy()
+	if !policy.Enabled || policy.WindowSeconds <= 0 {
+		return &RateLimitDecision{Allowed: true}, nil
+	}
+	window := time.Duration(policy.WindowSeconds) * time.Second
+	username = strings.ToLower(strings.TrimSpace(username))
+	subject := hashToken(username)
+
+	// Most specific first: it is the one reported when several are full
+	checks := []struct {
+		dimension string
+		key       string
+		limit     int
+	}{
+		{"ip_username", action + ":ip_username:" + ipAddress + "|" + subject, policy.PerIPUsername},
+		{"username", action + ":username:" + subject, policy.PerUsername},
+		{"ip", action + ":ip:" + ipAddress, policy.PerIP},
+	}
+
+	var dimensions []string
+	var limits []RateLimit
+	for _, check := range checks {
+		if check.limit > 0 && (username != "" || check.dimension == "ip") {
+			dimensions = append(dimensions, check.dimension)
+			limits = append(limits, RateLimit{Key: check.key, Limit: check.limit})
+		}
+	}
+	if len(limits) == 0 {
+		return &RateLimitDecision{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	refused, retryAfter, err := l.store.Take(limits, window, now)
+	if err != nil {
+		log.Printf("Rate limit store failed, counting %s attempts on this instance: %v", action, err)
+		if refused, retryAfter, err = l.fallback.Take(limits, window, now); err != nil {
+			return nil, err
+		}
+	}
+	if refused >= 0 {
+		limit := limits[refused]
+		l.report(limit.Key, action, dimensions[refused], limit.Limit, policy.WindowSeconds, ipAddress, username, userAgent, now, window)
+		return &RateLimitDecision{RetryAfter: retryAfter, Dimension: dimensions[refused]}, nil
+	}
+	return &RateLimitDecision{Allowed: true}, nil
+}
+
+func (l *LoginRateLimiter) report(key, action, dimension string, limit, windowSeconds int, ipAddress, username, userAgent string, now time.Time, window time.Duration) {
+	l.mu.Lock()
+	for k, at := range l.reported {
+		if now.Sub(at) > window {
+			delete(l.reported, k)
+		}
+	}
+	_, recent := l.reported[key]
+	if !recent {
+		l.reported[key] = now
+	}
+	l.mu.Unlock()
+
+	if recent {
+		return
+	}
+
+	log.Printf("Rate limit of %s (%s) exceeded for ip=%s username=%s", action, dimension, ipAddress, username)
+	l.activity.Record(ActivityLogEntry{
+		Actor:       ActivityActor{Username: username, IPAddress: ipAddress, UserAgent: userAgent},
+		Action:      ActivityLoginRateLimited,
+		Description: fmt.Sprintf("More than %d %s attempts by %s in %d seconds", limit, action, dimension, windowSeconds),
+		RequestData: map[string]interface{}{
+			"action":         action,
+			"dimension":      dimension,
+			"limit":          limit,
+			"window_seconds": windowSeconds,
+		},
+	})
+}
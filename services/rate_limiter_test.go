@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMemoryRateLimitStoreRefusalTakesNoHits(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	window := time.Minute
+	now := time.Unix(1700000000, 0)
+
+	narrow := []RateLimit{{Key: "pair", Limit: 1}, {Key: "ip", Limit: 3}}
+	if refused, _, _ := store.Take(narrow, window, now); refused != -1 {
+		t.Fatalf("first attempt refused by limit %d", refused)
+	}
+
+	// The pair is full; the ip counter must not be charged for the refusals
+	for i := 0; i < 5; i++ {
+		refused, retryAfter, err := store.Take(narrow, window, now.Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refused != 0 {
+			t.Fatalf("attempt %d: refused = %d, want 0", i, refused)
+		}
+		if retryAfter != window-time.Second {
+			t.Fatalf("attempt %d: retryAfter = %s, want %s", i, retryAfter, window-time.Second)
+		}
+	}
+
+	other := []RateLimit{{Key: "other-pair", Limit: 1}, {Key: "ip", Limit: 3}}
+	for i := 0; i < 2; i++ {
+		other[0].Key = "other-pair-" + string(rune('a'+i))
+		if refused, _, _ := store.Take(other, window, now.Add(2*time.Second)); refused != -1 {
+			t.Fatalf("attempt %d on another pair refused by limit %d", i, refused)
+		}
+	}
+	other[0].Key = "other-pair-c"
+	if refused, _, _ := store.Take(other, window, now.Add(2*time.Second)); refused != 1 {
+		t.Fatalf("fourth hit on ip: refused = %d, want 1", refused)
+	}
+}
+
+func TestMemoryRateLimitStoreWindowSlides(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	window := time.Minute
+	now := time.Unix(1700000000, 0)
+	limits := []RateLimit{{Key: "ip", Limit: 2}}
+
+	store.Take(limits, window, now)
+	store.Take(limits, window, now.Add(10*time.Second))
+	if refused, _, _ := store.Take(limits, window, now.Add(30*time.Second)); refused != 0 {
+		t.Fatalf("third hit inside the window: refused = %d, want 0", refused)
+	}
+	if refused, _, _ := store.Take(limits, window, now.Add(window+time.Second)); refused != -1 {
+		t.Fatalf("hit after the oldest one left the window refused by limit %d", refused)
+	}
+}
+
+// failingRateLimitStore records the keys it is asked for and always fails,
+// like a store that rejects them.
+type failingRateLimitStore struct {
+	keys []string
+}
+
+func (s *failingRateLimitStore) Take(limits []RateLimit, window time.Duration, now time.Time) (int, time.Duration, error) {
+	for _, limit := range limits {
+		s.keys = append(s.keys, limit.Key)
+	}
+	return -1, 0, errors.New("value too long for type character varying(255)")
+}
+
+func TestLoginRateLimiterKeysAndStoreFailure(t *testing.T) {
+	// Settings and activity queries fail, so the default policy applies:
+	// 5 attempts per IP and username pair
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := &failingRateLimitStore{}
+	limiter := NewLoginRateLimiter(store, NewSettingsService(db), NewActivityLogService(db))
+
+	username := strings.Repeat("a", 1000)
+	for i := 0; i < 5; i++ {
+		decision, err := limiter.AllowAction(RateLimitLogin, "2001:db8::1", username, "test")
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if !decision.Allowed {
+			t.Fatalf("attempt %d refused by %s", i, decision.Dimension)
+		}
+	}
+
+	// The failing store must not let attempts through unlimited
+	decision, err := limiter.AllowAction(RateLimitLogin, "2001:db8::1", username, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed || decision.Dimension != "ip_username" {
+		t.Errorf("sixth attempt: allowed = %v, dimension = %q; want refused by ip_username", decision.Allowed, decision.Dimension)
+	}
+
+	for _, key := range store.keys {
+		if len(key) > 255 || strings.Contains(key, username) {
+			t.Errorf("key %q does not fit rate_limit_hits.rate_key or holds the raw username", key)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// PermissionApproveRegistrations is required to approve or reject
+// self-registered accounts. Holders are notified of new registrations.
+const PermissionApproveRegistrations = "users.approve"
+
+const (
+	ActivityUserRegistered       = "USER_REGISTERED"
+	ActivityRegistrationApproved = "REGISTRATION_APPROVED"
+	ActivityRegistrationRejected = "REGISTRATION_REJECTED"
+)
+
+const (
+	registrationApprovedTemplate = "REGISTRATION_APPROVED"
+	registrationRejectedTemplate = "REGISTRATION_REJECTED"
+	// registrationNotificationType marks the approvers' notifications
+	registrationNotificationType = "REGISTRATION_PENDING"
+)
+
+var (
+	ErrRegistrationDisabled   = errors.New("self-registration is disabled")
+	ErrRegistrationConflict   = errors.New("username or email is already registered")
+	ErrRegistrationNotPending = errors.New("user has no pending registration")
+	ErrUnknownStatusCode      = errors.New("registration status is not configured")
+)
+
+type RegisterRequest struct {
+	Username  string  `json:"username" validate:"required,min=3,max=50"`
+	Email     string  `json:"email" validate:"required,email,max=100"`
+	Password  string  `json:"password" validate:"required"`
+	FirstName string  `json:"first_name" validate:"required,min=1,max=50"`
+	LastName  string  `json:"last_name" validate:"required,min=1,max=50"`
+	Phone     *string `json:"phone" validate:"omitempty,max=20"`
+}
+
+type RejectRegistrationRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// RegistrationService lets people request an account themselves. A
+// registration is an inactive users_application row in the status named by
+// registration.pending_status_code; approving it activates the account and
+// grants registration.default_roles, rejecting it moves it to
+// registration.rejected_status_code. The applicant is emailed either way.
+type RegistrationService struct {
+	db           *sql.DB
+	passwords    *PasswordService
+	verification *EmailVerificationService
+	email        *EmailService
+	settings     *SettingsService
+	activity     *ActivityLogService
+	appBaseURL   string
+}
+
+func NewRegistrationService(db *sql.DB, passwords *PasswordService, verification *EmailVerificationService, email *EmailService, settings *SettingsService, activity *ActivityLogService, appBaseURL string) *RegistrationService {
+	return &RegistrationService{
+		db:           db,
+		passwords:    passwords,
+		verification: verification,
+		email:        email,
+		settings:     settings,
+		activity:     activity,
+		appBaseURL:   strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// Enabled reports whether POST /auth/register accepts registrations.
+func (s *RegistrationService) Enabled() bool {
+	return s.settings.GetBool("registration.enabled", false)
+}
+
+// Register creates the pending account, notifies the approvers and sends the
+// applicant an email verification link.
+func (s *RegistrationService) Register(req RegisterRequest, ipAddress, userAgent string) (int, error) {
+	if !s.Enabled() {
+		return 0, ErrRegistrationDisabled
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+
+	// The policy is checked and the password hashed first, so that neither
+	// the answer nor the time it takes depends on whether the account exists
+	subject := PasswordSubject{Username: req.Username, Email: req.Email, FirstName: req.FirstName, LastName: req.LastName}
+	if err := s.passwords.ValidatePassword(req.Password, subject); err != nil {
+		return 0, err
+	}
+	hash, err := s.passwords.HashPassword(req.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	var exists bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users_application WHERE username = $1 OR LOWER(email) = LOWER($2))`,
+		req.Username, req.Email,
+	).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check existing account: %w", err)
+	}
+	if exists {
+		return 0, ErrRegistrationConflict
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	pendingStatusID, err := s.statusID(tx, "registration.pending_status_code", "PENDING")
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users_application
+			(username, email, password_hash, first_name, last_name, phone, status_id,
+			 is_active, created_by, password_changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, false, 'self_registration', CURRENT_TIMESTAMP)
+		RETURNING user_apps_id`,
+		req.Username, req.Email, hash, req.FirstName, req.LastName, req.Phone, pendingStatusID,
+	).Scan(&userID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return 0, ErrRegistrationConflict
+		}
+		return 0, fmt.Errorf("failed to create account: %w", err)
+	}
+
+	if err := s.notifyApprovers(tx, userID, req); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if err := s.verification.SendVerification(userID); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+	}
+	s.activity.Record(ActivityLogEntry{
+		Actor:       ActivityActor{UserID: userID, Username: req.Username, IPAddress: ipAddress, UserAgent: userAgent},
+		Action:      ActivityUserRegistered,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: "Account requested through self-registration",
+	})
+	return userID, nil
+}
+
+// notifyApprovers adds a notification for every active user who may approve
+// the registration.
+func (s *RegistrationService) notifyApprovers(tx *sql.Tx, userID int, req RegisterRequest) error {
+	rows, err := tx.Query(`
+		SELECT DISTINCT ur.user_id
+		FROM user_roles ur
+		JOIN users_application u ON u.user_apps_id = ur.user_id AND u.is_active = true
+		JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+		JOIN role_permissions rp ON rp.role_id = ur.role_id AND rp.is_active = true
+		JOIN permissions p ON p.permissions_id = rp.permission_id AND p.is_active = true
+		WHERE ur.is_active = true AND p.permission_code = $1`, PermissionApproveRegistrations)
+	if err != nil {
+		return fmt.Errorf("failed to load approvers: %w", err)
+	}
+	var approvers []int
+	for rows.Next() {
+		var approverID int
+		if err := rows.Scan(&approverID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to load approvers: %w", err)
+		}
+		approvers = append(approvers, approverID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load approvers: %w", err)
+	}
+	if len(approvers) == 0 {
+		log.Printf("Registration of user %d has no one to approve it: no active user holds %s", userID, PermissionApproveRegistrations)
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"user_id":  userID,
+		"username": req.Username,
+		"email":    req.Email,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	message := fmt.Sprintf("%s %s (%s, %s) requested an account and is waiting for approval.",
+		req.FirstName, req.LastName, req.Username, req.Email)
+
+	for _, approverID := range approvers {
+		_, err := tx.Exec(`
+			INSERT INTO notifications (user_id, notification_type, title, message, data, is_read, created_at)
+			VALUES ($1, $2, $3, $4, $5, false, CURRENT_TIMESTAMP)`,
+			approverID, registrationNotificationType, "New account request", message, string(data))
+		if err != nil {
+			return fmt.Errorf("failed to notify approvers: %w", err)
+		}
+	}
+	return nil
+}
+
+// Approve activates a pending registration and grants the default roles.
+func (s *RegistrationService) Approve(actor ActivityActor, userID int) error {
+	return s.decide(actor, userID, true, "")
+}
+
+// Reject closes a pending registration. The account stays inactive so the
+// decision remains on record.
+func (s *RegistrationService) Reject(actor ActivityActor, userID int, reason string) error {
+	return s.decide(actor, userID, false, strings.TrimSpace(reason))
+}
+
+func (s *RegistrationService) decide(actor ActivityActor, userID int, approve bool, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	pendingStatusID, err := s.statusID(tx, "registration.pending_status_code", "PENDING")
+	if err != nil {
+		return err
+	}
+
+	var username, firstName, email string
+	var statusID int
+	var isActive bool
+	err = tx.QueryRow(`
+		SELECT username, first_name, email, status_id, is_active
+		FROM users_application WHERE user_apps_id = $1 FOR UPDATE`, userID,
+	).Scan(&username, &firstName, &email, &statusID, &isActive)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("failed to load registration: %w", err)
+	}
+	if statusID != pendingStatusID || isActive {
+		return ErrRegistrationNotPending
+	}
+
+	statusKey, defaultCode := "registration.rejected_status_code", "REJECTED"
+	if approve {
+		statusKey, defaultCode = "registration.approved_status_code", "ACTIVE"
+	}
+	newStatusID, err := s.statusID(tx, statusKey, defaultCode)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users_application
+		SET status_id = $1, is_active = $2, updated_by = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE user_apps_id = $4`, newStatusID, approve, actor.Username, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update registration: %w", err)
+	}
+
+	if approve {
+		roles := s.settings.GetStringList("registration.default_roles", nil)
+		_, err = tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id, assigned_at, is_active)
+			SELECT $1, r.roles_id, CURRENT_TIMESTAMP, true
+			FROM users_roles r
+			WHERE r.is_active = true AND r.roles_code = ANY($2)
+			  AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = $1 AND ur.role_id = r.roles_id)`,
+			userID, pq.Array(roles))
+		if err != nil {
+			return fmt.Errorf("failed to assign default roles: %w", err)
+		}
+	}
+
+	// The request is handled; the other approvers need not act on it
+	_, err = tx.Exec(`
+		UPDATE notifications SET is_read = true, read_at = CURRENT_TIMESTAMP
+		WHERE notification_type = $1 AND is_read = false AND data->>'user_id' = $2`,
+		registrationNotificationType, fmt.Sprint(userID))
+	if err != nil {
+		return fmt.Errorf("failed to close notifications: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	action, template := ActivityRegistrationRejected, registrationRejectedTemplate
+	description := "Registration of " + username + " rejected"
+	variables := map[string]string{"username": username, "first_name": firstName, "reason": reason}
+	if approve {
+		action, template = ActivityRegistrationApproved, registrationApprovedTemplate
+		description = "Registration of " + username + " approved"
+		variables["login_link"] = s.appBaseURL + "/login"
+	}
+
+	go func() {
+		if err := s.email.SendTemplate(email, template, variables); err != nil {
+			log.Printf("%s email for user %d failed: %v", template, userID, err)
+		}
+	}()
+	entry := ActivityLogEntry{
+		Actor:       actor,
+		Action:      action,
+		TargetType:  "user",
+		TargetID:    userID,
+		Description: description,
+	}
+	if reason != "" {
+		entry.RequestData = map[string]string{"reason": reason}
+	}
+	s.activity.Record(entry)
+	return nil
+}
+
+// statusID resolves the users_application_status row named by a setting.
+func (s *RegistrationService) statusID(tx *sql.Tx, key, defaultCode string) (int, error) {
+	code := s.settings.GetString(key, defaultCode)
+	var id int
+	err := tx.QueryRow(`
+		SELECT users_application_status_id FROM users_application_status
+		WHERE status_code = $1 AND is_active = true`, code).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: no active status %q for %s", ErrUnknownStatusCode, code, key)
+		}
+		return 0, fmt.Errorf("failed to look up status %s: %w", code, err)
+	}
+	return id, nil
+}
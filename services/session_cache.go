@@ -0,0 +1,369 @@
+package services
+
+import (
+	"container/list"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SessionInvalidationChannel is the NOTIFY channel the user_sessions and
+// users_application triggers publish to. Payloads are "session:<session_id>"
+// or "user:<user_id>".
+const SessionInvalidationChannel = "session_invalidation"
+
+// sessionCachePingInterval keeps the LISTEN connection checked while the
+// database is quiet.
+const sessionCachePingInterval = 90 * time.Second
+
+// SessionCacheOptions bounds the session cache.
+type SessionCacheOptions struct {
+	TTL        time.Duration
+	MaxEntries int
+}
+
+// SessionCacheStats are the counters exposed for monitoring.
+type SessionCacheStats struct {
+	Enabled       bool    `json:"enabled"`
+	Listening     bool    `json:"listening"`
+	Entries       int     `json:"entries"`
+	MaxEntries    int     `json:"max_entries"`
+	TTLSeconds    float64 `json:"ttl_seconds"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Invalidations uint64  `json:"invalidations"`
+	Evictions     uint64  `json:"evictions"`
+	Purges        uint64  `json:"purges"`
+}
+
+type cachedSession struct {
+	key            string
+	validation     SessionValidation
+	sessionID      int
+	impersonatorID int
+	expiresAt      time.Time
+	touchedAt      time.Time
+}
+
+// sessionCacheHit is a valid session served from the cache. TouchDue is set
+// when last_seen_at should be refreshed.
+type sessionCacheHit struct {
+	Validation SessionValidation
+	SessionID  int
+	TouchDue   bool
+}
+
+// SessionCache keeps recent successful session validations in memory so that
+// authenticated requests do not hit the database every time. Only valid
+// sessions are cached, for at most TTL and never past the session's expiry.
+//
+// Entries are dropped as soon as the database reports a change through
+// LISTEN/NOTIFY: a session that is revoked, logged out or refreshed, or a user
+// that is deactivated. The cache is bypassed while the listener is not
+// connected, because notifications could be missed, and emptied whenever the
+// connection is re-established.
+type SessionCache struct {
+	options SessionCacheOptions
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List
+	bySession map[int]string
+	byUser    map[int]map[string]bool
+
+	listening atomic.Bool
+	// generation changes on every invalidation, so that a validation read
+	// from the database while an invalidation arrived is not cached
+	generation    atomic.Uint64
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+	evictions     atomic.Uint64
+	purges        atomic.Uint64
+}
+
+func NewSessionCache(options SessionCacheOptions) *SessionCache {
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = 10000
+	}
+	return &SessionCache{
+		options:   options,
+		entries:   map[string]*list.Element{},
+		lru:       list.New(),
+		bySession: map[int]string{},
+		byUser:    map[int]map[string]bool{},
+	}
+}
+
+func (c *SessionCache) usable() bool {
+	return c != nil && c.options.TTL > 0 && c.listening.Load()
+}
+
+// Get returns the cached validation of an access token.
+func (c *SessionCache) Get(token string) (*sessionCacheHit, bool) {
+	if !c.usable() {
+		return nil, false
+	}
+	key := hashToken(token)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := element.Value.(*cachedSession)
+	if !now.Before(entry.expiresAt) {
+		c.removeLocked(element)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.hits.Add(1)
+
+	hit := &sessionCacheHit{Validation: entry.validation, SessionID: entry.sessionID}
+	if now.Sub(entry.touchedAt) >= sessionTouchInterval {
+		entry.touchedAt = now
+		hit.TouchDue = true
+	}
+	return hit, true
+}
+
+// Generation is taken before a session is read from the database and passed
+// to Put.
+func (c *SessionCache) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.generation.Load()
+}
+
+// Put caches a valid session until the earlier of the cache TTL and the
+// session's own expiry. Nothing is cached when an invalidation arrived since
+// generation was taken.
+func (c *SessionCache) Put(token string, generation uint64, validation SessionValidation, sessionID, impersonatorID int, sessionExpiry time.Time) {
+	if !c.usable() || !validation.Valid {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(c.options.TTL)
+	if sessionExpiry.Before(expiresAt) {
+		expiresAt = sessionExpiry
+	}
+	key := hashToken(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation.Load() != generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.removeLocked(element)
+	}
+	for c.lru.Len() >= c.options.MaxEntries {
+		c.removeLocked(c.lru.Back())
+		c.evictions.Add(1)
+	}
+
+	entry := &cachedSession{
+		key:            key,
+		validation:     validation,
+		sessionID:      sessionID,
+		impersonatorID: impersonatorID,
+		expiresAt:      expiresAt,
+		touchedAt:      now,
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	// A refreshed session replaces its previous token
+	if previous, ok := c.bySession[sessionID]; ok && previous != key {
+		if element, ok := c.entries[previous]; ok {
+			c.removeLocked(element)
+		}
+	}
+	c.bySession[sessionID] = key
+	c.indexUserLocked(validation.UserID, key)
+	if impersonatorID != 0 {
+		c.indexUserLocked(impersonatorID, key)
+	}
+}
+
+func (c *SessionCache) indexUserLocked(userID int, key string) {
+	keys, ok := c.byUser[userID]
+	if !ok {
+		keys = map[string]bool{}
+		c.byUser[userID] = keys
+	}
+	keys[key] = true
+}
+
+func (c *SessionCache) unindexUserLocked(userID int, key string) {
+	if keys, ok := c.byUser[userID]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.byUser, userID)
+		}
+	}
+}
+
+func (c *SessionCache) removeLocked(element *list.Element) {
+	entry := c.lru.Remove(element).(*cachedSession)
+	delete(c.entries, entry.key)
+	if c.bySession[entry.sessionID] == entry.key {
+		delete(c.bySession, entry.sessionID)
+	}
+	c.unindexUserLocked(entry.validation.UserID, entry.key)
+	if entry.impersonatorID != 0 {
+		c.unindexUserLocked(entry.impersonatorID, entry.key)
+	}
+}
+
+// InvalidateSession drops the cached token of a session.
+func (c *SessionCache) InvalidateSession(sessionID int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation.Add(1)
+	if key, ok := c.bySession[sessionID]; ok {
+		if element, ok := c.entries[key]; ok {
+			c.removeLocked(element)
+			c.invalidations.Add(1)
+		}
+	}
+}
+
+// InvalidateUser drops every cached session of a user, including sessions in
+// which the user impersonates someone else.
+func (c *SessionCache) InvalidateUser(userID int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation.Add(1)
+	for key := range c.byUser[userID] {
+		if element, ok := c.entries[key]; ok {
+			c.removeLocked(element)
+			c.invalidations.Add(1)
+		}
+	}
+}
+
+// Purge empties the cache.
+func (c *SessionCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation.Add(1)
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.bySession = map[int]string{}
+	c.byUser = map[int]map[string]bool{}
+	c.purges.Add(1)
+}
+
+func (c *SessionCache) Stats() SessionCacheStats {
+	if c == nil {
+		return SessionCacheStats{}
+	}
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	stats := SessionCacheStats{
+		Enabled:       c.options.TTL > 0,
+		Listening:     c.listening.Load(),
+		Entries:       entries,
+		MaxEntries:    c.options.MaxEntries,
+		TTLSeconds:    c.options.TTL.Seconds(),
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+		Evictions:     c.evictions.Load(),
+		Purges:        c.purges.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// Listen subscribes to SessionInvalidationChannel on a dedicated connection
+// and keeps it open in the background. The cache stays bypassed until the
+// subscription is active.
+func (c *SessionCache) Listen(dsn string) {
+	if c == nil || c.options.TTL <= 0 {
+		return
+	}
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			if c.listening.Swap(false) {
+				log.Printf("Session cache disabled, invalidation listener lost: %v", err)
+			}
+			c.Purge()
+		case pq.ListenerEventReconnected:
+			// Notifications sent while disconnected are lost
+			c.Purge()
+			c.listening.Store(true)
+			log.Println("Session cache invalidation listener reconnected")
+		}
+	})
+
+	go func() {
+		// Listen blocks until the first connection is established
+		if err := listener.Listen(SessionInvalidationChannel); err != nil {
+			log.Printf("Session cache disabled, failed to listen for invalidations: %v", err)
+			return
+		}
+		c.listening.Store(true)
+
+		for {
+			select {
+			case notification := <-listener.Notify:
+				// nil is sent after a reconnect, which the event callback handles
+				if notification != nil {
+					c.handleNotification(notification.Extra)
+				}
+			case <-time.After(sessionCachePingInterval):
+				go listener.Ping()
+			}
+		}
+	}()
+}
+
+func (c *SessionCache) handleNotification(payload string) {
+	kind, rawID, ok := strings.Cut(payload, ":")
+	id, err := strconv.Atoi(rawID)
+	if !ok || err != nil {
+		log.Printf("Ignoring malformed session invalidation %q", payload)
+		return
+	}
+
+	switch kind {
+	case "session":
+		c.InvalidateSession(id)
+	case "user":
+		c.InvalidateUser(id)
+	default:
+		log.Printf("Ignoring unknown session invalidation %q", payload)
+	}
+}
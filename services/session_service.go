@@ -0,0 +1,128 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ActiveSession is one of the caller's own sessions as shown in the account
+// security page.
+type ActiveSession struct {
+	SessionID  int        `json:"session_id"`
+	IPAddress  *string    `json:"ip_address"`
+	UserAgent  *string    `json:"user_agent"`
+	Device     DeviceInfo `json:"device"`
+	LoginAt    time.Time  `json:"login_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+}
+
+// SessionService lets users inspect and end their own sessions.
+type SessionService struct {
+	db *sql.DB
+}
+
+func NewSessionService(db *sql.DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+// ListActive returns the user's active, unexpired sessions, newest first.
+// currentSessionID marks the session the request was made with.
+func (s *SessionService) ListActive(userID, currentSessionID int) ([]ActiveSession, error) {
+	rows, err := s.db.Query(`
+		SELECT session_id, host(ip_address), user_agent, login_at, last_seen_at, expires_at
+		FROM user_sessions
+		WHERE user_id = $1 AND is_active = true AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY COALESCE(last_seen_at, login_at) DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []ActiveSession{}
+	for rows.Next() {
+		var session ActiveSession
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&session.SessionID, &session.IPAddress, &session.UserAgent,
+			&session.LoginAt, &lastSeen, &session.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if lastSeen.Valid {
+			session.LastSeenAt = &lastSeen.Time
+		}
+		if session.UserAgent != nil {
+			session.Device = ParseUserAgent(*session.UserAgent)
+		} else {
+			session.Device = ParseUserAgent("")
+		}
+		session.Current = session.SessionID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of the user's sessions. Sessions of other users are
+// reported as not found.
+func (s *SessionService) Revoke(userID, sessionID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRow(`
+		SELECT is_active FROM user_sessions
+		WHERE session_id = $1 AND user_id = $2
+		FOR UPDATE`, sessionID, userID).Scan(&active)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	if !active {
+		return ErrSessionNotFound
+	}
+
+	if err := revokeSessionTx(tx, sessionID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RevokeOthers ends every session of the user except currentSessionID and
+// returns how many were ended.
+func (s *SessionService) RevokeOthers(userID, currentSessionID int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM user_sessions
+		WHERE user_id = $1 AND session_id <> $2 AND is_active = true`, userID, currentSessionID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	if err := revokeUserSessionsTx(tx, userID, currentSessionID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SettingsService reads typed values from the system_settings table. Missing,
+// inactive or unparsable settings fall back to the supplied default so callers
+// always get a usable value.
+type SettingsService struct {
+	db *sql.DB
+}
+
+func NewSettingsService(db *sql.DB) *SettingsService {
+	return &SettingsService{db: db}
+}
+
+// lookup returns the raw value of an active setting and whether it was found.
+func (s *SettingsService) lookup(key string) (string, bool, error) {
+	query := `SELECT setting_value FROM system_settings WHERE setting_key = $1 AND is_active = true`
+
+	var value sql.NullString
+	err := s.db.QueryRow(query, key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read setting %s: %w", key, err)
+	}
+	if !value.Valid {
+		return "", false, nil
+	}
+	return strings.TrimSpace(value.String), true, nil
+}
+
+func (s *SettingsService) GetString(key, defaultValue string) string {
+	value, ok, err := s.lookup(key)
+	if err != nil || !ok {
+		return defaultValue
+	}
+	return value
+}
+
+func (s *SettingsService) GetInt(key string, defaultValue int) int {
+	value, ok, err := s.lookup(key)
+	if err != nil || !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return parsed
+}
+
+func (s *SettingsService) GetFloat(key string, defaultValue float64) float64 {
+	value, ok, err := s.lookup(key)
+	if err != nil || !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return parsed
+}
+
+// GetBool accepts the same truthy values as the public settings endpoint ("true", "1").
+func (s *SettingsService) GetBool(key string, defaultValue bool) bool {
+	value, ok, err := s.lookup(key)
+	if err != nil || !ok {
+		return defaultValue
+	}
+	switch strings.ToLower(value) {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+	return defaultValue
+}
+
+// GetStringList splits a comma separated setting into trimmed, non-empty items.
+func (s *SettingsService) GetStringList(key string, defaultValue []string) []string {
+	value, ok, err := s.lookup(key)
+	if err != nil || !ok {
+		return defaultValue
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
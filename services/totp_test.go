@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; the last six digits are the 6-digit code
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTPStepWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+
+	for delta := int64(-3); delta <= 3; delta++ {
+		code, err := totpCode(rfc6238Secret, current+delta)
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		step, ok := verifyTOTP(rfc6238Secret, code, now)
+
+		inWindow := delta >= -totpSkew && delta <= totpSkew
+		if ok != inWindow {
+			t.Errorf("code of step %+d accepted = %v, want %v", delta, ok, inWindow)
+		}
+		if ok && step != current+delta {
+			t.Errorf("code of step %+d matched step %d, want %d", delta, step, current+delta)
+		}
+	}
+}
+
+func TestVerifyTOTPRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := verifyTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("verifyTOTP accepted %q", code)
+		}
+	}
+	if _, ok := verifyTOTP("not base32!", "005924", now); ok {
+		t.Error("verifyTOTP accepted a code for an invalid secret")
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DeviceInfo is a best-effort description of a User-Agent header, good enough
+// for users to recognise their own sessions.
+type DeviceInfo struct {
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browser_version"`
+	OS             string `json:"os"`
+	DeviceType     string `json:"device_type"`
+}
+
+type uaPattern struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// Order matters: several browsers also advertise the tokens of the engines
+// they are built on (Edge and Opera claim to be Chrome, Chrome claims Safari).
+var browserPatterns = []uaPattern{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+	{"curl", regexp.MustCompile(`curl/([\d.]+)`)},
+	{"Postman", regexp.MustCompile(`PostmanRuntime/([\d.]+)`)},
+	{"Go HTTP client", regexp.MustCompile(`Go-http-client/([\d.]+)`)},
+}
+
+var osPatterns = []struct {
+	name  string
+	token string
+}{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iOS", "iPhone"},
+	{"iPadOS", "iPad"},
+	{"macOS", "Mac OS X"},
+	{"ChromeOS", "CrOS"},
+	{"Linux", "Linux"},
+}
+
+// ParseUserAgent extracts browser, operating system and device type from a
+// User-Agent header. Unknown parts are reported as "Unknown".
+func ParseUserAgent(userAgent string) DeviceInfo {
+	info := DeviceInfo{Browser: "Unknown", OS: "Unknown", DeviceType: "desktop"}
+	if strings.TrimSpace(userAgent) == "" {
+		info.DeviceType = "unknown"
+		return info
+	}
+
+	for _, browser := range browserPatterns {
+		if match := browser.pattern.FindStringSubmatch(userAgent); match != nil {
+			info.Browser = browser.name
+			info.BrowserVersion = match[1]
+			break
+		}
+	}
+
+	for _, system := range osPatterns {
+		if strings.Contains(userAgent, system.token) {
+			info.OS = system.name
+			break
+		}
+	}
+
+	lowered := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(lowered, "ipad") || strings.Contains(lowered, "tablet"):
+		info.DeviceType = "tablet"
+	case strings.Contains(lowered, "mobi") || strings.Contains(lowered, "iphone") || strings.Contains(lowered, "android"):
+		info.DeviceType = "mobile"
+	case strings.Contains(lowered, "bot") || strings.Contains(lowered, "curl") ||
+		strings.Contains(lowered, "postman") || strings.Contains(lowered, "go-http-client"):
+		info.DeviceType = "other"
+	}
+	return info
+}
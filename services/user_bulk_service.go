@@ -0,0 +1,308 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// MaxBulkUsers caps the users a single bulk request may touch.
+const MaxBulkUsers = 1000
+
+const (
+	ActivityUserBulkUpdated = "USER_BULK_UPDATED"
+	ActivityUserBulkDeleted = "USER_BULK_DELETED"
+)
+
+var (
+	ErrBulkTarget        = errors.New("either ids or a non-empty filter is required")
+	ErrBulkNoChanges     = errors.New("at least one of status_id, department_id or is_active must be changed")
+	ErrBulkTooMany       = fmt.Errorf("a bulk request may affect at most %d users", MaxBulkUsers)
+	ErrBulkUnknownStatus = errors.New("status not found")
+	ErrBulkUnknownDept   = errors.New("department not found")
+)
+
+// BulkUserTarget selects the users of a bulk request: a list of IDs or a
+// filter, never both.
+type BulkUserTarget struct {
+	IDs    []int       `json:"ids"`
+	Filter *UserFilter `json:"filter"`
+	// DryRun reports what would change without changing anything
+	DryRun bool `json:"dry_run"`
+}
+
+// BulkUserChanges lists the fields a bulk update sets; nil fields are kept.
+type BulkUserChanges struct {
+	StatusID     *int  `json:"status_id" validate:"omitempty,min=1"`
+	DepartmentID *int  `json:"department_id" validate:"omitempty,min=1"`
+	IsActive     *bool `json:"is_active"`
+}
+
+type BulkUpdateUsersRequest struct {
+	BulkUserTarget
+	Changes BulkUserChanges `json:"changes"`
+}
+
+type BulkDeleteUsersRequest struct {
+	BulkUserTarget
+}
+
+// BulkUserState is the part of a user a bulk operation may change.
+type BulkUserState struct {
+	StatusID     int  `json:"status_id"`
+	DepartmentID *int `json:"department_id"`
+	IsActive     bool `json:"is_active"`
+}
+
+// BulkUserResult is the outcome for one user. Failed users are left as they
+// were; the others are changed regardless.
+type BulkUserResult struct {
+	ID       int            `json:"id"`
+	Username string         `json:"username,omitempty"`
+	Success  bool           `json:"success"`
+	Error    string         `json:"error,omitempty"`
+	Before   *BulkUserState `json:"before,omitempty"`
+	After    *BulkUserState `json:"after,omitempty"`
+}
+
+type BulkUserResponse struct {
+	DryRun    bool             `json:"dry_run"`
+	Matched   int              `json:"matched"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkUserResult `json:"results"`
+}
+
+// bulkUserRow is a user locked by a bulk operation.
+type bulkUserRow struct {
+	id       int
+	username string
+	state    BulkUserState
+}
+
+// errBulkItem carries a per-user failure that is reported to the client as is.
+type errBulkItem struct {
+	message string
+}
+
+func (e *errBulkItem) Error() string {
+	return e.message
+}
+
+// UserBulkService changes many users in one transaction. Every user is
+// changed under its own savepoint, so a failing user is reported and skipped
+// without undoing the others. A dry run executes the same statements and
+// rolls everything back.
+type UserBulkService struct {
+	db       *sql.DB
+	activity *ActivityLogService
+}
+
+func NewUserBulkService(db *sql.DB, activity *ActivityLogService) *UserBulkService {
+	return &UserBulkService{db: db, activity: activity}
+}
+
+// Update applies changes to the target users.
+func (s *UserBulkService) Update(actor ActivityActor, req BulkUpdateUsersRequest) (*BulkUserResponse, error) {
+	changes := req.Changes
+	if changes.StatusID == nil && changes.DepartmentID == nil && changes.IsActive == nil {
+		return nil, ErrBulkNoChanges
+	}
+	if changes.StatusID != nil {
+		if err := s.requireExists(`SELECT EXISTS(SELECT 1 FROM users_application_status WHERE users_application_status_id = $1 AND is_active = true)`, *changes.StatusID, ErrBulkUnknownStatus); err != nil {
+			return nil, err
+		}
+	}
+	if changes.DepartmentID != nil {
+		if err := s.requireExists(`SELECT EXISTS(SELECT 1 FROM departments WHERE department_id = $1 AND is_active = true)`, *changes.DepartmentID, ErrBulkUnknownDept); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.run(actor, req.BulkUserTarget, ActivityUserBulkUpdated, func(tx *sql.Tx, user bulkUserRow) (*BulkUserState, error) {
+		if user.id == actor.UserID && changes.IsActive != nil && !*changes.IsActive {
+			return nil, &errBulkItem{"you cannot deactivate your own account"}
+		}
+
+		after := &BulkUserState{}
+		err := tx.QueryRow(`
+			UPDATE users_application
+			SET status_id = COALESCE($1, status_id),
+			    department_id = COALESCE($2, department_id),
+			    is_active = COALESCE($3, is_active),
+			    updated_by = $4, updated_at = CURRENT_TIMESTAMP
+			WHERE user_apps_id = $5
+			RETURNING status_id, department_id, is_active`,
+			changes.StatusID, changes.DepartmentID, changes.IsActive, actor.Username, user.id,
+		).Scan(&after.StatusID, &after.DepartmentID, &after.IsActive)
+		return after, err
+	})
+}
+
+// Delete soft-deletes the target users like DELETE /users/:id does.
+func (s *UserBulkService) Delete(actor ActivityActor, req BulkDeleteUsersRequest) (*BulkUserResponse, error) {
+	return s.run(actor, req.BulkUserTarget, ActivityUserBulkDeleted, func(tx *sql.Tx, user bulkUserRow) (*BulkUserState, error) {
+		if user.id == actor.UserID {
+			return nil, &errBulkItem{"you cannot delete your own account"}
+		}
+		if !user.state.IsActive {
+			return nil, &errBulkItem{"user is already deleted"}
+		}
+
+		_, err := tx.Exec(`
+			UPDATE users_application
+			SET is_active = false, updated_by = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE user_apps_id = $2`, actor.Username, user.id)
+		if err != nil {
+			return nil, err
+		}
+		after := user.state
+		after.IsActive = false
+		return &after, nil
+	})
+}
+
+func (s *UserBulkService) requireExists(query string, id int, notFound error) error {
+	var exists bool
+	if err := s.db.QueryRow(query, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check reference: %w", err)
+	}
+	if !exists {
+		return notFound
+	}
+	return nil
+}
+
+func (s *UserBulkService) run(actor ActivityActor, target BulkUserTarget, action string, apply func(*sql.Tx, bulkUserRow) (*BulkUserState, error)) (*BulkUserResponse, error) {
+	if (len(target.IDs) == 0) == target.Filter.IsEmpty() {
+		return nil, ErrBulkTarget
+	}
+	if len(target.IDs) > MaxBulkUsers {
+		return nil, ErrBulkTooMany
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	users, results, err := s.lockTargets(tx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &BulkUserResponse{DryRun: target.DryRun, Matched: len(users)}
+	for _, user := range users {
+		result := BulkUserResult{ID: user.id, Username: user.username, Before: &BulkUserState{}}
+		*result.Before = user.state
+
+		if _, err := tx.Exec(`SAVEPOINT bulk_user`); err != nil {
+			return nil, fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		after, err := apply(tx, user)
+		if err != nil {
+			if _, rollbackErr := tx.Exec(`ROLLBACK TO SAVEPOINT bulk_user`); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to roll back to savepoint: %w", rollbackErr)
+			}
+			var itemErr *errBulkItem
+			if errors.As(err, &itemErr) {
+				result.Error = itemErr.message
+			} else {
+				log.Printf("Bulk operation on user %d failed: %v", user.id, err)
+				result.Error = "failed to change user"
+			}
+		} else {
+			if _, err := tx.Exec(`RELEASE SAVEPOINT bulk_user`); err != nil {
+				return nil, fmt.Errorf("failed to release savepoint: %w", err)
+			}
+			result.Success = true
+			result.After = after
+		}
+		results = append(results, result)
+	}
+
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	response.Results = results
+
+	if target.DryRun {
+		return response, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		s.activity.Record(ActivityLogEntry{
+			Actor:       actor,
+			Action:      action,
+			TargetType:  "user",
+			TargetID:    result.ID,
+			Description: "Bulk change of " + result.Username,
+			RequestData: map[string]*BulkUserState{"before": result.Before, "after": result.After},
+		})
+	}
+	return response, nil
+}
+
+// lockTargets loads and locks the users selected by target. Requested IDs
+// that do not exist are returned as failed results.
+func (s *UserBulkService) lockTargets(tx *sql.Tx, target BulkUserTarget) ([]bulkUserRow, []BulkUserResult, error) {
+	query := `
+		SELECT user_apps_id, username, status_id, department_id, is_active
+		FROM users_application`
+	var args []interface{}
+	if len(target.IDs) > 0 {
+		query += ` WHERE user_apps_id = ANY($1)`
+		args = append(args, pq.Array(target.IDs))
+	} else {
+		where, filterArgs := target.Filter.Where("", 1)
+		query += ` WHERE ` + where
+		args = append(args, filterArgs...)
+	}
+	query += fmt.Sprintf(` ORDER BY user_apps_id LIMIT %d FOR UPDATE`, MaxBulkUsers+1)
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []bulkUserRow
+	found := make(map[int]bool)
+	for rows.Next() {
+		var user bulkUserRow
+		if err := rows.Scan(&user.id, &user.username, &user.state.StatusID, &user.state.DepartmentID, &user.state.IsActive); err != nil {
+			return nil, nil, fmt.Errorf("failed to load users: %w", err)
+		}
+		users = append(users, user)
+		found[user.id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	if len(users) > MaxBulkUsers {
+		return nil, nil, ErrBulkTooMany
+	}
+
+	var missing []BulkUserResult
+	for _, id := range target.IDs {
+		if !found[id] {
+			missing = append(missing, BulkUserResult{ID: id, Error: "user not found"})
+			found[id] = true
+		}
+	}
+	return users, missing, nil
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+	ExportPDF  = "pdf"
+)
+
+const ActivityUsersExported = "USERS_EXPORTED"
+
+var (
+	ErrUnknownExportColumn = errors.New("unknown export column")
+	ErrUnknownExportFormat = errors.New("unknown export format")
+	ErrExportTooLarge      = errors.New("too many users for a PDF export, narrow the filter or export to CSV or Excel")
+)
+
+// UserExportColumn is a column that can be selected for an export.
+type UserExportColumn struct {
+	Key    string `json:"key"`
+	Header string `json:"header"`
+	expr   string
+	// width is the relative column width, in characters
+	width float64
+}
+
+// userExportColumns are the selectable columns in their export order. Every
+// expression yields text so all formats share one scan.
+var userExportColumns = []UserExportColumn{
+	{Key: "id", Header: "ID", expr: "u.user_apps_id::text", width: 7},
+	{Key: "username", Header: "Username", expr: "u.username", width: 16},
+	{Key: "email", Header: "Email", expr: "u.email", width: 28},
+	{Key: "first_name", Header: "First Name", expr: "u.first_name", width: 16},
+	{Key: "last_name", Header: "Last Name", expr: "u.last_name", width: 16},
+	{Key: "employee_id", Header: "Employee ID", expr: "u.employee_id", width: 12},
+	{Key: "phone", Header: "Phone", expr: "u.phone", width: 14},
+	{Key: "status", Header: "Status", expr: "s.status_name", width: 12},
+	{Key: "department", Header: "Department", expr: "d.department_name", width: 20},
+	{Key: "roles", Header: "Roles", expr: `(
+		SELECT string_agg(r.roles_name, ', ' ORDER BY r.roles_name)
+		FROM user_roles ur
+		JOIN users_roles r ON r.roles_id = ur.role_id
+		WHERE ur.user_id = u.user_apps_id AND ur.is_active = true)`, width: 24},
+	{Key: "is_active", Header: "Active", expr: "CASE WHEN u.is_active THEN 'Yes' ELSE 'No' END", width: 7},
+	{Key: "is_service_account", Header: "Service Account", expr: "CASE WHEN u.is_service_account THEN 'Yes' ELSE 'No' END", width: 9},
+	{Key: "last_login_at", Header: "Last Login", expr: "to_char(u.last_login_at, 'YYYY-MM-DD HH24:MI')", width: 16},
+	{Key: "created_at", Header: "Created At", expr: "to_char(u.created_at, 'YYYY-MM-DD HH24:MI')", width: 16},
+}
+
+// DefaultUserExportColumns are exported when no columns are requested.
+var DefaultUserExportColumns = []string{
+	"id", "username", "email", "first_name", "last_name", "status", "department", "roles", "is_active", "last_login_at",
+}
+
+// UserExportColumns returns every selectable column.
+func UserExportColumns() []UserExportColumn {
+	return append([]UserExportColumn(nil), userExportColumns...)
+}
+
+// ParseUserExportColumns resolves a comma separated list of column keys. An
+// empty list selects DefaultUserExportColumns.
+func ParseUserExportColumns(spec string) ([]UserExportColumn, error) {
+	keys := DefaultUserExportColumns
+	if strings.TrimSpace(spec) != "" {
+		keys = nil
+		for _, key := range strings.Split(spec, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	var columns []UserExportColumn
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		found := false
+		for _, column := range userExportColumns {
+			if column.Key == key {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownExportColumn, key)
+		}
+	}
+	return columns, nil
+}
+
+// exportWriter receives an export row by row and finishes it on Close.
+type exportWriter interface {
+	WriteRow(values []string) error
+	Close() error
+}
+
+// UserExportService exports users with the filters of GET /users. Rows are
+// read from the database one at a time and handed to the format writer: CSV
+// goes straight to the client and excelize spills large worksheets to a
+// temporary file. PDF pages are built in memory, so PDF exports are capped by
+// export.pdf_max_rows.
+type UserExportService struct {
+	db       *sql.DB
+	settings *SettingsService
+	activity *ActivityLogService
+}
+
+func NewUserExportService(db *sql.DB, settings *SettingsService, activity *ActivityLogService) *UserExportService {
+	return &UserExportService{db: db, settings: settings, activity: activity}
+}
+
+// Export writes the users matching filter to out and returns how many were
+// exported.
+func (s *UserExportService) Export(ctx context.Context, actor ActivityActor, format string, filter *UserFilter, columns []UserExportColumn, out io.Writer) (int, error) {
+	maxRows := 0
+	switch format {
+	case ExportCSV, ExportXLSX:
+	case ExportPDF:
+		maxRows = s.settings.GetInt("export.pdf_max_rows", 2000)
+	default:
+		return 0, ErrUnknownExportFormat
+	}
+
+	expressions := make([]string, len(columns))
+	for i, column := range columns {
+		expressions[i] = column.expr
+	}
+	where, args := filter.Where("u", 1)
+	query := `
+		SELECT ` + strings.Join(expressions, ", ") + `
+		FROM users_application u
+		LEFT JOIN users_application_status s ON s.users_application_status_id = u.status_id
+		LEFT JOIN departments d ON d.department_id = u.department_id
+		WHERE ` + where + `
+		ORDER BY u.user_apps_id`
+
+	// Query first: the CSV writer sends its header right away, after which
+	// a failure can no longer be reported with an error status
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var writer exportWriter
+	switch format {
+	case ExportCSV:
+		writer, err = newCSVExportWriter(out, columns)
+	case ExportXLSX:
+		writer, err = newXLSXExportWriter(out, columns)
+	case ExportPDF:
+		writer = newPDFExportWriter(out, columns)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	scanned := make([]sql.NullString, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range scanned {
+		targets[i] = &scanned[i]
+	}
+	values := make([]string, len(columns))
+	for rows.Next() {
+		if maxRows > 0 && count >= maxRows {
+			return count, ErrExportTooLarge
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return count, fmt.Errorf("failed to read user: %w", err)
+		}
+		for i, value := range scanned {
+			values[i] = value.String
+		}
+		if err := writer.WriteRow(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to read users: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return count, err
+	}
+
+	keys := make([]string, len(columns))
+	for i, column := range columns {
+		keys[i] = column.Key
+	}
+	s.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityUsersExported,
+		TargetType:  "user",
+		Description: fmt.Sprintf("Exported %d users as %s", count, format),
+		RequestData: map[string]interface{}{"format": format, "columns": keys, "filter": filter},
+	})
+	return count, nil
+}
+
+// csvExportWriter writes RFC 4180 CSV with a UTF-8 byte order mark so that
+// spreadsheet applications detect the encoding.
+type csvExportWriter struct {
+	writer *csv.Writer
+	rows   int
+}
+
+func newCSVExportWriter(out io.Writer, columns []UserExportColumn) (*csvExportWriter, error) {
+	if _, err := io.WriteString(out, "\ufeff"); err != nil {
+		return nil, err
+	}
+	w := &csvExportWriter{writer: csv.NewWriter(out)}
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.Header
+	}
+	if err := w.writer.Write(headers); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvExportWriter) WriteRow(values []string) error {
+	row := make([]string, len(values))
+	for i, value := range values {
+		row[i] = escapeSpreadsheetFormula(value)
+	}
+	if err := w.writer.Write(row); err != nil {
+		return err
+	}
+	// Flush regularly so the client receives data while the export runs
+	w.rows++
+	if w.rows%500 == 0 {
+		w.writer.Flush()
+	}
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// escapeSpreadsheetFormula keeps values such as "=HYPERLINK(...)" from being
+// evaluated when the CSV is opened in a spreadsheet application.
+func escapeSpreadsheetFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// xlsxExportWriter writes a single worksheet through excelize's stream
+// writer. Values are stored as strings, which are never evaluated.
+type xlsxExportWriter struct {
+	out    io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXLSXExportWriter(out io.Writer, columns []UserExportColumn) (*xlsxExportWriter, error) {
+	file := excelize.NewFile()
+	stream, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create worksheet: %w", err)
+	}
+	bold, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create worksheet: %w", err)
+	}
+
+	headers := make([]interface{}, len(columns))
+	for i, column := range columns {
+		if err := stream.SetColWidth(i+1, i+1, column.width+2); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create worksheet: %w", err)
+		}
+		headers[i] = excelize.Cell{StyleID: bold, Value: column.Header}
+	}
+	if err := stream.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create worksheet: %w", err)
+	}
+	if err := stream.SetRow("A1", headers); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create worksheet: %w", err)
+	}
+	return &xlsxExportWriter{out: out, file: file, stream: stream, row: 1}, nil
+}
+
+func (w *xlsxExportWriter) WriteRow(values []string) error {
+	w.row++
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		cells[i] = value
+	}
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	if err := w.stream.SetRow(cell, cells); err != nil {
+		return fmt.Errorf("failed to write worksheet row: %w", err)
+	}
+	return nil
+}
+
+func (w *xlsxExportWriter) Close() error {
+	defer w.file.Close()
+	if err := w.stream.Flush(); err != nil {
+		return fmt.Errorf("failed to write worksheet: %w", err)
+	}
+	if err := w.file.Write(w.out); err != nil {
+		return fmt.Errorf("failed to write workbook: %w", err)
+	}
+	return nil
+}
+
+// pdfExportWriter lays the export out as a landscape A4 table with the
+// header repeated on every page. Cells longer than their column are cut.
+type pdfExportWriter struct {
+	out       io.Writer
+	pdf       *gofpdf.Fpdf
+	widths    []float64
+	translate func(string) string
+}
+
+const (
+	pdfMargin     = 10.0
+	pdfRowHeight  = 6.0
+	pdfFontSize   = 8.0
+	pdfPageWidth  = 297.0
+	pdfTitleSize  = 12.0
+	pdfCellMargin = 1.0
+)
+
+func newPDFExportWriter(out io.Writer, columns []UserExportColumn) *pdfExportWriter {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetCellMargin(pdfCellMargin)
+	w := &pdfExportWriter{
+		out:       out,
+		pdf:       pdf,
+		translate: pdf.UnicodeTranslatorFromDescriptor(""),
+	}
+
+	// Spread the page width over the columns in proportion to their width
+	total := 0.0
+	for _, column := range columns {
+		total += column.width
+	}
+	available := pdfPageWidth - 2*pdfMargin
+	for _, column := range columns {
+		w.widths = append(w.widths, available*column.width/total)
+	}
+
+	pdf.SetHeaderFunc(func() {
+		if pdf.PageNo() == 1 {
+			pdf.SetFont("Helvetica", "B", pdfTitleSize)
+			pdf.CellFormat(available, 10, "Users", "", 1, "L", false, 0, "")
+		}
+		pdf.SetFont("Helvetica", "B", pdfFontSize)
+		pdf.SetFillColor(230, 230, 230)
+		for i, column := range columns {
+			pdf.CellFormat(w.widths[i], pdfRowHeight, w.fit(column.Header, w.widths[i]), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", pdfFontSize)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin)
+		pdf.SetFont("Helvetica", "", pdfFontSize)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+	return w
+}
+
+// fit translates text to the font encoding and shortens it to width.
+func (w *pdfExportWriter) fit(text string, width float64) string {
+	text = w.translate(text)
+	limit := width - 2*pdfCellMargin
+	if w.pdf.GetStringWidth(text) <= limit {
+		return text
+	}
+	for len(text) > 0 && w.pdf.GetStringWidth(text+"...") > limit {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+func (w *pdfExportWriter) WriteRow(values []string) error {
+	for i, value := range values {
+		w.pdf.CellFormat(w.widths[i], pdfRowHeight, w.fit(value, w.widths[i]), "1", 0, "L", false, 0, "")
+	}
+	w.pdf.Ln(-1)
+	return w.pdf.Error()
+}
+
+func (w *pdfExportWriter) Close() error {
+	if err := w.pdf.Output(w.out); err != nil {
+		return fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+)
+
+// UserFilter selects users by the same criteria as GET /users. Nil and empty
+// fields do not filter.
+type UserFilter struct {
+	Search           string `json:"search"`
+	StatusID         *int   `json:"status_id"`
+	DepartmentID     *int   `json:"department_id"`
+	IsActive         *bool  `json:"is_active"`
+	IsServiceAccount *bool  `json:"is_service_account"`
+}
+
+// IsEmpty reports whether the filter would match every user.
+func (f *UserFilter) IsEmpty() bool {
+	return f == nil || (strings.TrimSpace(f.Search) == "" && f.StatusID == nil &&
+		f.DepartmentID == nil && f.IsActive == nil && f.IsServiceAccount == nil)
+}
+
+// Where returns the filter as SQL conditions joined with AND, with
+// placeholders numbered from firstArg, and their arguments. Columns are
+// qualified with alias when it is not empty. An empty filter yields "TRUE".
+func (f *UserFilter) Where(alias string, firstArg int) (string, []interface{}) {
+	if f.IsEmpty() {
+		return "TRUE", nil
+	}
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		placeholder := "$" + strconv.Itoa(firstArg+len(args)-1)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", placeholder))
+	}
+
+	if f.StatusID != nil {
+		add(prefix+"status_id = ?", *f.StatusID)
+	}
+	if f.DepartmentID != nil {
+		add(prefix+"department_id = ?", *f.DepartmentID)
+	}
+	if f.IsActive != nil {
+		add(prefix+"is_active = ?", *f.IsActive)
+	}
+	if f.IsServiceAccount != nil {
+		add(prefix+"is_service_account = ?", *f.IsServiceAccount)
+	}
+	if search := strings.TrimSpace(f.Search); search != "" {
+		add("(LOWER("+prefix+"username) LIKE ? OR LOWER("+prefix+"email) LIKE ?"+
+			" OR LOWER("+prefix+"first_name) LIKE ? OR LOWER("+prefix+"last_name) LIKE ?"+
+			" OR LOWER("+prefix+"employee_id) LIKE ?)",
+			"%"+strings.ToLower(search)+"%")
+	}
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUserFilterWhere(t *testing.T) {
+	status, department := 2, 5
+	active := true
+
+	tests := []struct {
+		name     string
+		filter   *UserFilter
+		alias    string
+		firstArg int
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			name:   "nil filter",
+			filter: nil,
+			want:   "TRUE",
+		},
+		{
+			name:   "blank search",
+			filter: &UserFilter{Search: "   "},
+			want:   "TRUE",
+		},
+		{
+			name:     "numbering starts at firstArg",
+			filter:   &UserFilter{StatusID: &status, DepartmentID: &department},
+			firstArg: 3,
+			want:     "status_id = $3 AND department_id = $4",
+			wantArgs: []interface{}{2, 5},
+		},
+		{
+			name:     "search reuses one placeholder",
+			filter:   &UserFilter{IsActive: &active, Search: " Ann "},
+			alias:    "u",
+			firstArg: 1,
+			want: "u.is_active = $1 AND (LOWER(u.username) LIKE $2 OR LOWER(u.email) LIKE $2" +
+				" OR LOWER(u.first_name) LIKE $2 OR LOWER(u.last_name) LIKE $2" +
+				" OR LOWER(u.employee_id) LIKE $2)",
+			wantArgs: []interface{}{true, "%ann%"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := tt.filter.Where(tt.alias, tt.firstArg)
+			if got != tt.want {
+				t.Errorf("Where = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,881 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/xuri/excelize/v2"
+)
+
+// MaxUserImportFileSize caps the size of an uploaded import file.
+const MaxUserImportFileSize = 10 << 20
+
+// maxUserImportUnzipSize caps the unpacked size of an Excel workbook, so that
+// a small, highly compressed file cannot exhaust memory or disk.
+const maxUserImportUnzipSize = 10 * MaxUserImportFileSize
+
+// Import job statuses
+const (
+	UserImportStatusPreview   = "PREVIEW"
+	UserImportStatusRunning   = "RUNNING"
+	UserImportStatusCompleted = "COMPLETED"
+	UserImportStatusFailed    = "FAILED"
+)
+
+// Import row statuses
+const (
+	UserImportRowValid   = "VALID"
+	UserImportRowInvalid = "INVALID"
+	UserImportRowCreated = "CREATED"
+	UserImportRowFailed  = "FAILED"
+)
+
+const ActivityUsersImported = "USERS_IMPORTED"
+
+var (
+	ErrImportFileType         = errors.New("only .csv and .xlsx files can be imported")
+	ErrImportUnreadable       = errors.New("the file could not be read")
+	ErrImportEmpty            = errors.New("the file contains no users")
+	ErrImportTooMany          = errors.New("the file contains too many users")
+	ErrImportMissingColumns   = errors.New("required columns are missing")
+	ErrImportUnknownField     = errors.New("unknown import field")
+	ErrImportDuplicateColumns = errors.New("more than one column maps to the same field")
+	ErrImportNotPending       = errors.New("import has already been confirmed")
+	ErrImportNothingValid     = errors.New("import has no valid rows")
+	ErrImportRolesNotHeld     = errors.New("import assigns roles you do not hold")
+)
+
+// userImportFields are the fields a file column can map to.
+var userImportFields = []string{
+	"username", "email", "first_name", "last_name", "status_code", "department_code",
+	"employee_id", "phone", "roles", "is_service_account",
+}
+
+var userImportRequiredFields = []string{"username", "email", "first_name", "last_name"}
+
+// userImportAliases lets common header names map to a field without an
+// explicit mapping.
+var userImportAliases = map[string]string{
+	"status":          "status_code",
+	"department":      "department_code",
+	"role":            "roles",
+	"role_codes":      "roles",
+	"service_account": "is_service_account",
+}
+
+// UserImportRecord is one user read from an import file. The IDs are
+// resolved from the codes while previewing.
+type UserImportRecord struct {
+	Username         string   `json:"username"`
+	Email            string   `json:"email"`
+	FirstName        string   `json:"first_name"`
+	LastName         string   `json:"last_name"`
+	StatusCode       string   `json:"status_code"`
+	DepartmentCode   string   `json:"department_code,omitempty"`
+	EmployeeID       string   `json:"employee_id,omitempty"`
+	Phone            string   `json:"phone,omitempty"`
+	Roles            []string `json:"roles,omitempty"`
+	IsServiceAccount bool     `json:"is_service_account"`
+	StatusID         int      `json:"status_id,omitempty"`
+	DepartmentID     *int     `json:"department_id,omitempty"`
+	RoleIDs          []int    `json:"role_ids,omitempty"`
+}
+
+// UserImportCheck validates a record with the rules of POST /users and
+// returns one message per problem.
+type UserImportCheck func(record UserImportRecord) []string
+
+// UserImportRow is a record with its line in the file and its outcome.
+type UserImportRow struct {
+	Row     int              `json:"row"`
+	Status  string           `json:"status"`
+	Data    UserImportRecord `json:"data"`
+	Errors  []string         `json:"errors,omitempty"`
+	UserID  *int             `json:"user_id,omitempty"`
+	Message string           `json:"message,omitempty"`
+}
+
+type UserImportJob struct {
+	ID              int        `json:"id"`
+	FileName        string     `json:"file_name"`
+	Status          string     `json:"status"`
+	TotalRows       int        `json:"total_rows"`
+	ValidRows       int        `json:"valid_rows"`
+	InvalidRows     int        `json:"invalid_rows"`
+	CreatedRows     int        `json:"created_rows"`
+	FailedRows      int        `json:"failed_rows"`
+	SendInvitations bool       `json:"send_invitations"`
+	Error           *string    `json:"error,omitempty"`
+	CreatedBy       string     `json:"created_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ConfirmedBy     *string    `json:"confirmed_by"`
+	ConfirmedAt     *time.Time `json:"confirmed_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+}
+
+// UserImportPreview is returned when a file is uploaded. Columns maps every
+// used file header to its field.
+type UserImportPreview struct {
+	Job            *UserImportJob    `json:"job"`
+	Columns        map[string]string `json:"columns"`
+	IgnoredColumns []string          `json:"ignored_columns"`
+	Rows           []UserImportRow   `json:"rows"`
+}
+
+const userImportJobColumns = `
+	id, file_name, status, total_rows, valid_rows, invalid_rows, created_rows, failed_rows,
+	send_invitations, error, created_by, created_at, confirmed_by, confirmed_at, finished_at`
+
+// UserImportService creates users in bulk from a CSV or Excel file in two
+// steps. Preview parses and validates the file and stores the rows as an
+// import job; nothing else changes. Confirm then creates the valid rows in
+// the background, one transaction per user, assigns their roles and mails
+// each an invitation to choose a password. Progress and the per-row results
+// are kept in user_import_jobs and user_import_rows. A job interrupted by a
+// restart is marked FAILED by FailInterruptedJobs; its report shows which
+// rows were created. Importers can only assign roles they hold themselves.
+type UserImportService struct {
+	db        *sql.DB
+	passwords *PasswordService
+	resets    *PasswordResetService
+	settings  *SettingsService
+	activity  *ActivityLogService
+}
+
+func NewUserImportService(db *sql.DB, passwords *PasswordService, resets *PasswordResetService, settings *SettingsService, activity *ActivityLogService) *UserImportService {
+	return &UserImportService{db: db, passwords: passwords, resets: resets, settings: settings, activity: activity}
+}
+
+// Preview validates the file and stores it as an import job waiting for
+// confirmation. mapping optionally maps file headers to fields; an empty
+// field ignores the column. Headers without a mapping are matched by name.
+func (s *UserImportService) Preview(actor ActivityActor, fileName string, file io.Reader, mapping map[string]string, sendInvitations bool, check UserImportCheck) (*UserImportPreview, error) {
+	table, err := readUserImportFile(fileName, file)
+	if err != nil {
+		return nil, err
+	}
+	if len(table) < 2 {
+		return nil, ErrImportEmpty
+	}
+
+	preview := &UserImportPreview{Columns: make(map[string]string), IgnoredColumns: []string{}}
+	fieldColumns, err := mapUserImportColumns(table[0], mapping, preview)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRows := s.settings.GetInt("import.max_rows", 1000)
+	for i, cells := range table[1:] {
+		if isBlankRow(cells) {
+			continue
+		}
+		if len(preview.Rows) == maxRows {
+			return nil, fmt.Errorf("%w: at most %d rows can be imported at once", ErrImportTooMany, maxRows)
+		}
+		value := func(field string) string {
+			column, ok := fieldColumns[field]
+			if !ok || column >= len(cells) {
+				return ""
+			}
+			return strings.TrimSpace(cells[column])
+		}
+		row := UserImportRow{
+			Row: i + 2,
+			Data: UserImportRecord{
+				Username:       value("username"),
+				Email:          value("email"),
+				FirstName:      value("first_name"),
+				LastName:       value("last_name"),
+				StatusCode:     strings.ToUpper(value("status_code")),
+				DepartmentCode: strings.ToUpper(value("department_code")),
+				EmployeeID:     value("employee_id"),
+				Phone:          value("phone"),
+			},
+		}
+		for _, role := range strings.FieldsFunc(value("roles"), func(r rune) bool { return r == ',' || r == ';' }) {
+			if role = strings.ToUpper(strings.TrimSpace(role)); role != "" {
+				row.Data.Roles = append(row.Data.Roles, role)
+			}
+		}
+		switch strings.ToLower(value("is_service_account")) {
+		case "", "no", "n", "false", "0":
+		case "yes", "y", "true", "1":
+			row.Data.IsServiceAccount = true
+		default:
+			row.Errors = append(row.Errors, "is_service_account must be yes or no")
+		}
+		preview.Rows = append(preview.Rows, row)
+	}
+	if len(preview.Rows) == 0 {
+		return nil, ErrImportEmpty
+	}
+
+	if err := s.validateRows(actor, preview.Rows, check); err != nil {
+		return nil, err
+	}
+
+	job, err := s.saveJob(actor, fileName, sendInvitations, preview.Rows)
+	if err != nil {
+		return nil, err
+	}
+	preview.Job = job
+	return preview, nil
+}
+
+// readUserImportFile returns the cells of a CSV file or of the first
+// worksheet of an Excel workbook.
+func readUserImportFile(fileName string, file io.Reader) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrImportUnreadable, err)
+		}
+		reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+		reader.FieldsPerRecord = -1
+		table, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrImportUnreadable, err)
+		}
+		return table, nil
+	case ".xlsx":
+		workbook, err := excelize.OpenReader(file, excelize.Options{UnzipSizeLimit: maxUserImportUnzipSize})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrImportUnreadable, err)
+		}
+		defer workbook.Close()
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, ErrImportEmpty
+		}
+		table, err := workbook.GetRows(sheets[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrImportUnreadable, err)
+		}
+		return table, nil
+	default:
+		return nil, ErrImportFileType
+	}
+}
+
+// mapUserImportColumns returns the column index of every mapped field and
+// records the mapping in preview.
+func mapUserImportColumns(headers []string, mapping map[string]string, preview *UserImportPreview) (map[string]int, error) {
+	known := make(map[string]bool)
+	for _, field := range userImportFields {
+		known[field] = true
+	}
+	for _, field := range mapping {
+		if field != "" && !known[field] {
+			return nil, fmt.Errorf("%w: %s", ErrImportUnknownField, field)
+		}
+	}
+
+	columns := make(map[string]int)
+	for i, header := range headers {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		field, mapped := mapping[header]
+		if !mapped {
+			field = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(header))
+			if alias, ok := userImportAliases[field]; ok {
+				field = alias
+			}
+		}
+		if !known[field] {
+			preview.IgnoredColumns = append(preview.IgnoredColumns, header)
+			continue
+		}
+		if _, ok := columns[field]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrImportDuplicateColumns, field)
+		}
+		columns[field] = i
+		preview.Columns[header] = field
+	}
+
+	var missing []string
+	for _, field := range userImportRequiredFields {
+		if _, ok := columns[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrImportMissingColumns, strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+func isBlankRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// heldRolesQuery selects the active role codes and IDs assigned to a user.
+const heldRolesQuery = `
+	SELECT UPPER(r.roles_code), r.roles_id
+	FROM user_roles ur
+	JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+	WHERE ur.user_id = $1 AND ur.is_active = true`
+
+// validateRows resolves the codes of every row and reports what keeps it
+// from being imported, including usernames and emails that are taken or
+// repeated in the file and roles the importer does not hold.
+func (s *UserImportService) validateRows(actor ActivityActor, rows []UserImportRow, check UserImportCheck) error {
+	statuses, err := s.codeIDs(`SELECT UPPER(status_code), users_application_status_id FROM users_application_status WHERE is_active = true`)
+	if err != nil {
+		return err
+	}
+	departments, err := s.codeIDs(`SELECT UPPER(department_code), department_id FROM departments WHERE is_active = true`)
+	if err != nil {
+		return err
+	}
+	roles, err := s.codeIDs(`SELECT UPPER(roles_code), roles_id FROM users_roles WHERE is_active = true`)
+	if err != nil {
+		return err
+	}
+	heldRoles, err := s.codeIDs(heldRolesQuery, actor.UserID)
+	if err != nil {
+		return err
+	}
+	takenUsernames, takenEmails, err := s.existingAccounts(rows)
+	if err != nil {
+		return err
+	}
+
+	defaultStatus := strings.ToUpper(s.settings.GetString("import.default_status_code", "ACTIVE"))
+	fileUsernames := make(map[string]int)
+	fileEmails := make(map[string]int)
+	for i := range rows {
+		row := &rows[i]
+		record := &row.Data
+
+		if record.StatusCode == "" {
+			record.StatusCode = defaultStatus
+		}
+		if id, ok := statuses[record.StatusCode]; ok {
+			record.StatusID = id
+		} else {
+			row.Errors = append(row.Errors, "Unknown status code "+record.StatusCode)
+		}
+		if record.DepartmentCode != "" {
+			if id, ok := departments[record.DepartmentCode]; ok {
+				record.DepartmentID = &id
+			} else {
+				row.Errors = append(row.Errors, "Unknown department code "+record.DepartmentCode)
+			}
+		}
+		assigned := make(map[int]bool)
+		for _, code := range record.Roles {
+			id, known := roles[code]
+			_, held := heldRoles[code]
+			switch {
+			case !known:
+				row.Errors = append(row.Errors, "Unknown role code "+code)
+			case !held:
+				row.Errors = append(row.Errors, "Role "+code+" cannot be assigned because you do not hold it")
+			case !assigned[id]:
+				record.RoleIDs = append(record.RoleIDs, id)
+				assigned[id] = true
+			}
+		}
+
+		row.Errors = append(row.Errors, check(*record)...)
+
+		email := strings.ToLower(record.Email)
+		if takenUsernames[record.Username] {
+			row.Errors = append(row.Errors, "Username already exists")
+		} else if first, ok := fileUsernames[record.Username]; ok && record.Username != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("Username repeats row %d", first))
+		}
+		if takenEmails[email] {
+			row.Errors = append(row.Errors, "Email already exists")
+		} else if first, ok := fileEmails[email]; ok && email != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("Email repeats row %d", first))
+		}
+		if _, ok := fileUsernames[record.Username]; !ok {
+			fileUsernames[record.Username] = row.Row
+		}
+		if _, ok := fileEmails[email]; !ok {
+			fileEmails[email] = row.Row
+		}
+
+		row.Status = UserImportRowValid
+		if len(row.Errors) > 0 {
+			row.Status = UserImportRowInvalid
+		}
+	}
+	return nil
+}
+
+// codeIDs loads a code to ID lookup table.
+func (s *UserImportService) codeIDs(query string, args ...interface{}) (map[string]int, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load codes: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int)
+	for rows.Next() {
+		var code string
+		var id int
+		if err := rows.Scan(&code, &id); err != nil {
+			return nil, fmt.Errorf("failed to load codes: %w", err)
+		}
+		ids[code] = id
+	}
+	return ids, rows.Err()
+}
+
+// existingAccounts returns the usernames and lowercased emails of the rows
+// that already belong to an account.
+func (s *UserImportService) existingAccounts(rows []UserImportRow) (map[string]bool, map[string]bool, error) {
+	usernames := make([]string, len(rows))
+	emails := make([]string, len(rows))
+	for i, row := range rows {
+		usernames[i] = row.Data.Username
+		emails[i] = strings.ToLower(row.Data.Email)
+	}
+
+	result, err := s.db.Query(`
+		SELECT username, LOWER(email) FROM users_application
+		WHERE username = ANY($1) OR LOWER(email) = ANY($2)`, pq.Array(usernames), pq.Array(emails))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check existing accounts: %w", err)
+	}
+	defer result.Close()
+
+	takenUsernames := make(map[string]bool)
+	takenEmails := make(map[string]bool)
+	for result.Next() {
+		var username, email string
+		if err := result.Scan(&username, &email); err != nil {
+			return nil, nil, fmt.Errorf("failed to check existing accounts: %w", err)
+		}
+		takenUsernames[username] = true
+		takenEmails[email] = true
+	}
+	return takenUsernames, takenEmails, result.Err()
+}
+
+func (s *UserImportService) saveJob(actor ActivityActor, fileName string, sendInvitations bool, rows []UserImportRow) (*UserImportJob, error) {
+	valid := 0
+	for _, row := range rows {
+		if row.Status == UserImportRowValid {
+			valid++
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	job, err := scanUserImportJob(tx.QueryRow(`
+		INSERT INTO user_import_jobs
+			(file_name, status, total_rows, valid_rows, invalid_rows, send_invitations, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+		RETURNING `+userImportJobColumns,
+		filepath.Base(fileName), UserImportStatusPreview, len(rows), valid, len(rows)-valid, sendInvitations, actor.Username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import: %w", err)
+	}
+
+	for _, row := range rows {
+		data, err := json.Marshal(row.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode row %d: %w", row.Row, err)
+		}
+		var rowErrors interface{}
+		if len(row.Errors) > 0 {
+			encoded, err := json.Marshal(row.Errors)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode row %d: %w", row.Row, err)
+			}
+			rowErrors = string(encoded)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO user_import_rows (job_id, row_number, status, data, errors)
+			VALUES ($1, $2, $3, $4, $5)`,
+			job.ID, row.Row, row.Status, string(data), rowErrors)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store row %d: %w", row.Row, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return job, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUserImportJob(row rowScanner) (*UserImportJob, error) {
+	var job UserImportJob
+	err := row.Scan(&job.ID, &job.FileName, &job.Status, &job.TotalRows, &job.ValidRows, &job.InvalidRows,
+		&job.CreatedRows, &job.FailedRows, &job.SendInvitations, &job.Error, &job.CreatedBy, &job.CreatedAt,
+		&job.ConfirmedBy, &job.ConfirmedAt, &job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Job returns an import job, or sql.ErrNoRows.
+func (s *UserImportService) Job(id int) (*UserImportJob, error) {
+	job, err := scanUserImportJob(s.db.QueryRow(`SELECT `+userImportJobColumns+` FROM user_import_jobs WHERE id = $1`, id))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load import: %w", err)
+	}
+	return job, err
+}
+
+// Confirm starts creating the valid rows of a previewed import and returns
+// the running job. Poll Job for progress.
+func (s *UserImportService) Confirm(actor ActivityActor, id int) (*UserImportJob, error) {
+	job, err := s.Job(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != UserImportStatusPreview {
+		return nil, ErrImportNotPending
+	}
+	if job.ValidRows == 0 {
+		return nil, ErrImportNothingValid
+	}
+
+	// The preview was checked against the roles of whoever uploaded it
+	var notHeld bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1
+			FROM user_import_rows ir
+			CROSS JOIN LATERAL jsonb_array_elements_text(COALESCE(ir.data->'role_ids', '[]'::jsonb)) AS role_id
+			WHERE ir.job_id = $1 AND ir.status = $2
+			  AND role_id::int NOT IN (
+				SELECT ur.role_id
+				FROM user_roles ur
+				JOIN users_roles r ON r.roles_id = ur.role_id AND r.is_active = true
+				WHERE ur.user_id = $3 AND ur.is_active = true)
+		)`, id, UserImportRowValid, actor.UserID).Scan(&notHeld)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check import roles: %w", err)
+	}
+	if notHeld {
+		return nil, ErrImportRolesNotHeld
+	}
+
+	// The status check makes sure only one confirmation starts the job
+	job, err = scanUserImportJob(s.db.QueryRow(`
+		UPDATE user_import_jobs
+		SET status = $1, confirmed_by = $2, confirmed_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status = $4
+		RETURNING `+userImportJobColumns,
+		UserImportStatusRunning, actor.Username, id, UserImportStatusPreview))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrImportNotPending
+		}
+		return nil, fmt.Errorf("failed to start import: %w", err)
+	}
+
+	go s.run(actor, *job)
+	return job, nil
+}
+
+func (s *UserImportService) run(actor ActivityActor, job UserImportJob) {
+	// A panic would otherwise take the whole server down and leave the job
+	// RUNNING
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("User import %d panicked: %v\n%s", job.ID, r, debug.Stack())
+			_, err := s.db.Exec(`
+				UPDATE user_import_jobs
+				SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP
+				WHERE id = $3`, UserImportStatusFailed, "Import stopped unexpectedly", job.ID)
+			if err != nil {
+				log.Printf("Failed to finish user import %d: %v", job.ID, err)
+			}
+		}
+	}()
+
+	created, failed, err := s.createUsers(actor, job)
+
+	status := UserImportStatusCompleted
+	var message *string
+	if err != nil {
+		log.Printf("User import %d failed: %v", job.ID, err)
+		status = UserImportStatusFailed
+		text := err.Error()
+		message = &text
+	}
+	_, err = s.db.Exec(`
+		UPDATE user_import_jobs
+		SET status = $1, created_rows = $2, failed_rows = $3, error = $4, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $5`, status, created, failed, message, job.ID)
+	if err != nil {
+		log.Printf("Failed to finish user import %d: %v", job.ID, err)
+	}
+
+	s.activity.Record(ActivityLogEntry{
+		Actor:       actor,
+		Action:      ActivityUsersImported,
+		TargetType:  "user_import",
+		TargetID:    job.ID,
+		Description: fmt.Sprintf("Imported %d of %d users from %s", created, job.ValidRows, job.FileName),
+		RequestData: map[string]int{"created": created, "failed": failed},
+	})
+}
+
+// FailInterruptedJobs marks the jobs left RUNNING by a previous process as
+// FAILED and returns how many there were. Call it at startup, before any
+// import can be confirmed.
+func (s *UserImportService) FailInterruptedJobs() (int, error) {
+	result, err := s.db.Exec(`
+		UPDATE user_import_jobs
+		SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP
+		WHERE status = $3`, UserImportStatusFailed, "Interrupted by a server restart", UserImportStatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted imports: %w", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted imports: %w", err)
+	}
+	return int(count), nil
+}
+
+// createUsers creates the valid rows of job in file order and records the
+// outcome of each.
+func (s *UserImportService) createUsers(actor ActivityActor, job UserImportJob) (int, int, error) {
+	result, err := s.db.Query(`
+		SELECT id, data FROM user_import_rows
+		WHERE job_id = $1 AND status = $2
+		ORDER BY row_number`, job.ID, UserImportRowValid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load rows: %w", err)
+	}
+	type pendingRow struct {
+		id     int
+		record UserImportRecord
+	}
+	var pending []pendingRow
+	for result.Next() {
+		var row pendingRow
+		var data []byte
+		if err := result.Scan(&row.id, &data); err != nil {
+			result.Close()
+			return 0, 0, fmt.Errorf("failed to load rows: %w", err)
+		}
+		if err := json.Unmarshal(data, &row.record); err != nil {
+			result.Close()
+			return 0, 0, fmt.Errorf("failed to decode row: %w", err)
+		}
+		pending = append(pending, row)
+	}
+	result.Close()
+	if err := result.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to load rows: %w", err)
+	}
+
+	created, failed := 0, 0
+	for _, row := range pending {
+		status, message := UserImportRowCreated, ""
+		userID, err := s.createUser(actor, row.record)
+		if err != nil {
+			status = UserImportRowFailed
+			var itemErr *errBulkItem
+			if errors.As(err, &itemErr) {
+				message = itemErr.message
+			} else {
+				log.Printf("User import %d could not create %s: %v", job.ID, row.record.Username, err)
+				message = "Failed to create user"
+			}
+			failed++
+		} else {
+			created++
+			if job.SendInvitations && !row.record.IsServiceAccount {
+				if err := s.resets.Invite(userID); err != nil {
+					log.Printf("Failed to invite imported user %d: %v", userID, err)
+					message = "User created, but the invitation could not be sent"
+				}
+			}
+		}
+
+		var createdID *int
+		if err == nil {
+			createdID = &userID
+		}
+		if _, err := s.db.Exec(`
+			UPDATE user_import_rows SET status = $1, user_id = $2, message = $3 WHERE id = $4`,
+			status, createdID, message, row.id); err != nil {
+			return created, failed, fmt.Errorf("failed to record row result: %w", err)
+		}
+		if _, err := s.db.Exec(`
+			UPDATE user_import_jobs SET created_rows = $1, failed_rows = $2 WHERE id = $3`,
+			created, failed, job.ID); err != nil {
+			return created, failed, fmt.Errorf("failed to record progress: %w", err)
+		}
+	}
+	return created, failed, nil
+}
+
+// createUser creates one imported user with their roles. The password is
+// random and known to no one; the user chooses one through the invitation.
+func (s *UserImportService) createUser(actor ActivityActor, record UserImportRecord) (int, error) {
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return 0, err
+	}
+	hash, err := s.passwords.HashPassword(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Accounts may have been created since the preview
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users_application WHERE username = $1 OR LOWER(email) = LOWER($2))`,
+		record.Username, record.Email).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check existing account: %w", err)
+	}
+	if exists {
+		return 0, &errBulkItem{"Username or email already exists"}
+	}
+
+	var employeeID, phone *string
+	if record.EmployeeID != "" {
+		employeeID = &record.EmployeeID
+	}
+	if record.Phone != "" {
+		phone = &record.Phone
+	}
+
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users_application
+			(username, email, password_hash, first_name, last_name, status_id,
+			 department_id, employee_id, phone, is_active, is_service_account, created_by, password_changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, true, $10, $11, CURRENT_TIMESTAMP)
+		RETURNING user_apps_id`,
+		record.Username, record.Email, hash, record.FirstName, record.LastName, record.StatusID,
+		record.DepartmentID, employeeID, phone, record.IsServiceAccount, actor.Username,
+	).Scan(&userID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			switch pqErr.Code {
+			case "23505":
+				return 0, &errBulkItem{"Username or email already exists"}
+			case "23503":
+				return 0, &errBulkItem{"Status or department no longer exists"}
+			}
+		}
+		return 0, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	if len(record.RoleIDs) > 0 {
+		_, err = tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id, assigned_at, is_active)
+			SELECT $1, role_id, CURRENT_TIMESTAMP, true
+			FROM unnest($2::int[]) AS role_id`,
+			userID, pq.Array(record.RoleIDs))
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return 0, &errBulkItem{"Role no longer exists"}
+			}
+			return 0, fmt.Errorf("failed to assign roles: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// WriteReport writes the rows of an import and their outcome as CSV. Before
+// confirmation it lists the preview.
+func (s *UserImportService) WriteReport(id int, out io.Writer) error {
+	if _, err := s.Job(id); err != nil {
+		return err
+	}
+
+	result, err := s.db.Query(`
+		SELECT row_number, status, data->>'username', data->>'email', user_id, errors, message
+		FROM user_import_rows
+		WHERE job_id = $1
+		ORDER BY row_number`, id)
+	if err != nil {
+		return fmt.Errorf("failed to load rows: %w", err)
+	}
+	defer result.Close()
+
+	headers := []UserExportColumn{
+		{Header: "Row"}, {Header: "Username"}, {Header: "Email"}, {Header: "Result"}, {Header: "User ID"}, {Header: "Message"},
+	}
+	writer, err := newCSVExportWriter(out, headers)
+	if err != nil {
+		return err
+	}
+	for result.Next() {
+		var rowNumber int
+		var status string
+		var username, email, message sql.NullString
+		var userID sql.NullInt64
+		var rowErrors []byte
+		if err := result.Scan(&rowNumber, &status, &username, &email, &userID, &rowErrors, &message); err != nil {
+			return fmt.Errorf("failed to load rows: %w", err)
+		}
+
+		text := message.String
+		if len(rowErrors) > 0 {
+			var messages []string
+			if err := json.Unmarshal(rowErrors, &messages); err != nil {
+				return fmt.Errorf("failed to decode row %d: %w", rowNumber, err)
+			}
+			text = strings.Join(messages, "; ")
+		}
+		createdID := ""
+		if userID.Valid {
+			createdID = strconv.FormatInt(userID.Int64, 10)
+		}
+		if err := writer.WriteRow([]string{strconv.Itoa(rowNumber), username.String, email.String, status, createdID, text}); err != nil {
+			return err
+		}
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to load rows: %w", err)
+	}
+	return writer.Close()
+}